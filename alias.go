@@ -0,0 +1,136 @@
+package senpai
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxAliasDepth is the number of aliases that can run one another, to stop
+// aliases that run themselves.
+const maxAliasDepth = 8
+
+// patternAliasVariable matches the variables of the commands of an alias:
+// $1 is the first argument, $2- the arguments from the second one, $* all
+// arguments, $buffer, $nick and $network the current buffer, nick and
+// network, and $$ a dollar sign.
+var patternAliasVariable = regexp.MustCompile(`\$(?:(\d+)(-?)|\*|(buffer|nick|network)\b|\$)`)
+
+// aliasArgs returns the number of arguments required by the commands of an
+// alias, and whether they take any further arguments.
+func aliasArgs(lines []string) (n int, rest bool) {
+	for _, line := range lines {
+		for _, m := range patternAliasVariable.FindAllStringSubmatch(line, -1) {
+			if m[0] == "$*" {
+				rest = true
+				continue
+			}
+			if m[1] == "" {
+				continue
+			}
+			i, _ := strconv.Atoi(m[1])
+			if m[2] != "" {
+				// The arguments from $i on are optional.
+				rest = true
+				i--
+			}
+			n = max(n, i)
+		}
+	}
+	return n, rest
+}
+
+// expandAlias replaces the variables of a command of an alias with the
+// arguments it was run with, and vars.
+func expandAlias(line string, args []string, vars map[string]string) string {
+	return patternAliasVariable.ReplaceAllStringFunc(line, func(v string) string {
+		m := patternAliasVariable.FindStringSubmatch(v)
+		switch {
+		case v == "$$":
+			return "$"
+		case v == "$*":
+			return strings.Join(args, " ")
+		case m[3] != "":
+			return vars[m[3]]
+		}
+		i, _ := strconv.Atoi(m[1])
+		if i == 0 || i > len(args) {
+			return ""
+		}
+		if m[2] != "" {
+			return strings.Join(args[i-1:], " ")
+		}
+		return args[i-1]
+	})
+}
+
+// aliasCommand returns the command running the commands of an alias, from
+// an alias directive of the configuration.
+func aliasCommand(lines []string) *command {
+	n, rest := aliasArgs(lines)
+	usage := make([]string, 0, n+1)
+	for i := 1; i <= n; i++ {
+		usage = append(usage, fmt.Sprintf("<arg%d>", i))
+	}
+	maxArgs := n
+	if rest {
+		usage = append(usage, "[args...]")
+		maxArgs = maxArgsInfinite
+	}
+	return &command{
+		AllowHome: true,
+		MinArgs:   n,
+		MaxArgs:   maxArgs,
+		Usage:     strings.Join(usage, " "),
+		Desc:      fmt.Sprintf("alias for %s", strings.Join(lines, "; ")),
+		Handle: func(app *App, args []string) error {
+			return app.runAlias(lines, args)
+		},
+	}
+}
+
+// newCommandSet returns the built-in commands and the commands of aliases,
+// keyed by their uppercase name.
+func newCommandSet(aliases map[string][]string) commandSet {
+	cmds := make(commandSet, len(commands)+len(aliases))
+	for name, cmd := range commands {
+		cmds[name] = cmd
+	}
+	for name, lines := range aliases {
+		cmds[strings.ToUpper(name)] = aliasCommand(lines)
+	}
+	return cmds
+}
+
+// runAlias runs the commands of an alias one after the other, stopping at the
+// first one that fails.
+func (app *App) runAlias(lines []string, args []string) error {
+	if app.aliasDepth >= maxAliasDepth {
+		return fmt.Errorf("too many nested aliases")
+	}
+	app.aliasDepth++
+	defer func() {
+		app.aliasDepth--
+	}()
+
+	netID, buffer := app.win.CurrentBuffer()
+	vars := map[string]string{
+		"buffer":  buffer,
+		"network": app.networkName(netID),
+	}
+	if s := app.sessions[netID]; s != nil {
+		vars["nick"] = s.Nick()
+	}
+	for _, line := range lines {
+		line = expandAlias(line, args, vars)
+		// Commands may switch to another buffer.
+		_, buffer := app.win.CurrentBuffer()
+		// The commands of aliases are deliberate: run them without
+		// confirmation.
+		if err := app.runInput(buffer, line, true); err != nil {
+			return fmt.Errorf("%s: %v", line, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package senpai
+
+import "testing"
+
+func TestAliasArgs(t *testing.T) {
+	for _, tc := range []struct {
+		lines []string
+		n     int
+		rest  bool
+	}{
+		{[]string{"/me waves"}, 0, false},
+		{[]string{"/msg #ops !deploy $1", "/me is deploying $1"}, 1, false},
+		{[]string{"/msg $1 $2-"}, 1, true},
+		{[]string{"/me $*", "/msg $3 hi"}, 3, true},
+		{[]string{"/me costs $$2"}, 0, false},
+	} {
+		n, rest := aliasArgs(tc.lines)
+		if n != tc.n || rest != tc.rest {
+			t.Errorf("%q: got %v, %v, expected %v, %v", tc.lines, n, rest, tc.n, tc.rest)
+		}
+	}
+}
+
+func TestExpandAlias(t *testing.T) {
+	vars := map[string]string{
+		"buffer":  "#senpai",
+		"nick":    "alice",
+		"network": "libera",
+	}
+	for _, tc := range []struct {
+		line     string
+		args     []string
+		expected string
+	}{
+		{"/msg #ops !deploy $1", []string{"prod"}, "/msg #ops !deploy prod"},
+		{"/msg $1 $2-", []string{"bob", "hello", "there"}, "/msg bob hello there"},
+		{"/me says $*", []string{"a", "b"}, "/me says a b"},
+		{"$nick in $buffer on $network", nil, "alice in #senpai on libera"},
+		{"$buffers $3 $$1", []string{"a"}, "$buffers  $1"},
+	} {
+		if actual := expandAlias(tc.line, tc.args, vars); actual != tc.expected {
+			t.Errorf("%q, %q: got %q, expected %q", tc.line, tc.args, actual, tc.expected)
+		}
+	}
+}
@@ -1,24 +1,25 @@
 package senpai
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
-	"html"
 	"image"
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
 	"io"
+	"math"
 	"mime"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
-	"regexp"
 	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -27,7 +28,6 @@ import (
 	"unicode"
 	"unicode/utf8"
 
-	"git.sr.ht/~delthas/senpai/varlinkservice"
 	"git.sr.ht/~rockorager/vaxis"
 	"golang.org/x/net/proxy"
 
@@ -92,6 +92,21 @@ func (b *bound) IsZero() bool {
 	return b.first.IsZero()
 }
 
+// historyWindow is a window of history shown in place of the live lines of a
+// buffer, requested with /goto.
+type historyWindow struct {
+	first time.Time
+	last  time.Time
+
+	pending  string // "around", "before" or "after" if a request is in flight
+	canceled bool   // the window was closed while a request was in flight
+	loaded   bool   // the window is shown
+	complete bool   // the start of the history was reached
+
+	id string    // ID of the message to select once loaded, if any
+	at time.Time // time to scroll to once loaded, if no ID
+}
+
 type event struct {
 	src     string // "*" if UI, netID otherwise
 	content interface{}
@@ -125,10 +140,13 @@ type App struct {
 	cfg        Config
 	highlights []string
 	shortcuts  map[keyMatch][]string
+	commands   commandSet // built-in commands and aliases
+	aliasDepth int        // number of aliases being run
 
 	lastQuery     string
 	lastQueryNet  string
 	messageBounds map[boundKey]bound
+	windows       map[boundKey]*historyWindow
 	lastNetID     string
 	lastBuffer    string
 
@@ -137,6 +155,24 @@ type App struct {
 	networkLock sync.RWMutex                 // locks networks
 	networks    map[string]map[string]string // set of network IDs to attributes we want to connect to; to be locked with networkLock
 
+	servers map[string]*ServerConfig // map of network IDs of independent servers to their settings
+
+	logs          *logStore // on-disk message log, nil if disabled
+	shownLogError bool
+
+	ignores       *ignoreStore
+	notifications *bufferSettings // notification levels, for servers without metadata
+	unfurls       *bufferSettings // whether links are unfurled, per buffer, if set with /unfurl
+
+	images           *imageFetcher
+	gallery          *gallery                  // open image gallery, if any
+	logRequests      map[boundKey]struct{}     // buffers whose history is being read from the message log
+	inputHistoryPath string                    // where the input history is saved, if set
+	nickActivity     map[boundKey]nickActivity // recent interactions, per casemapped nick
+	activityPruned   time.Time                 // when nickActivity was last pruned
+
+	subscribers varlinkSubscribers // varlink clients listening to events
+
 	pendingCompletions    map[string][]pendingCompletion
 	pendingCompletionsOff int
 
@@ -159,11 +195,18 @@ type App struct {
 }
 
 func NewApp(cfg Config) (app *App, err error) {
-	if cfg.Addr == "" {
+	if cfg.Addr == "" && len(cfg.Servers) == 0 {
 		return nil, errors.New("address is required")
 	}
 	if cfg.Nick == "" {
-		return nil, errors.New("nick is required")
+		if cfg.Addr != "" {
+			return nil, errors.New("nick is required")
+		}
+		for _, srv := range cfg.Servers {
+			if srv.Nick == "" {
+				return nil, fmt.Errorf("server %q: nick is required", srv.Name)
+			}
+		}
 	}
 	if cfg.User == "" {
 		cfg.User = cfg.Nick
@@ -171,19 +214,76 @@ func NewApp(cfg Config) (app *App, err error) {
 	if cfg.Real == "" {
 		cfg.Real = cfg.Nick
 	}
+	for i := range cfg.Servers {
+		srv := &cfg.Servers[i]
+		if srv.Nick == "" {
+			srv.Nick = cfg.Nick
+		}
+		if srv.User == "" {
+			srv.User = srv.Nick
+		}
+		if srv.Real == "" {
+			srv.Real = srv.Nick
+		}
+	}
 
 	app = &App{
-		networks: map[string]map[string]string{
-			"": {}, // add the master network by default
-		},
+		networks:           map[string]map[string]string{},
+		servers:            map[string]*ServerConfig{},
 		pendingCompletions: make(map[string][]pendingCompletion),
 		sessions:           map[string]*irc.Session{},
 		events:             make(chan event, eventChanSize),
 		cfg:                cfg,
 		shortcuts:          make(map[keyMatch][]string),
+		commands:           newCommandSet(cfg.Aliases),
 		messageBounds:      map[boundKey]bound{},
+		windows:            map[boundKey]*historyWindow{},
+		logRequests:        map[boundKey]struct{}{},
+		nickActivity:       map[boundKey]nickActivity{},
 		monitor:            make(map[string]map[string]struct{}),
 	}
+	if cfg.Addr != "" {
+		app.networks[""] = map[string]string{} // add the master network
+	}
+	if cfg.Log && !cfg.Transient {
+		logPath := cfg.LogPath
+		if logPath == "" {
+			if logPath, err = DefaultLogPath(); err != nil {
+				return nil, err
+			}
+		}
+		app.logs = newLogStore(logPath)
+	}
+	var ignorePath, notifyPath, unfurlPath string
+	if !cfg.Transient {
+		// Without a config directory, these are not persisted.
+		ignorePath, _ = DefaultIgnorePath()
+		notifyPath, _ = DefaultNotifyPath()
+		unfurlPath, _ = DefaultUnfurlPath()
+	}
+	var imagePath string
+	if !cfg.Transient {
+		imagePath, _ = DefaultImageCachePath()
+	}
+	app.images = newImageFetcher(cfg.Previews, imagePath)
+	app.ignores = newIgnoreStore(ignorePath)
+	app.notifications = newNotifyStore(notifyPath)
+	app.unfurls = newUnfurlStore(unfurlPath)
+	for i := range app.cfg.Servers {
+		srv := &app.cfg.Servers[i]
+		netID := serverNetID(srv.Name)
+		attrs := map[string]string{
+			"name": srv.Name,
+		}
+		if host, port, err := net.SplitHostPort(srv.Addr); err == nil {
+			attrs["host"] = host
+			attrs["port"] = port
+		} else {
+			attrs["host"] = srv.Addr
+		}
+		app.networks[netID] = attrs
+		app.servers[netID] = srv
+	}
 	for _, m := range []map[string][]string{defaultCommands, app.cfg.Shortcuts} {
 		for name, actions := range m {
 			k := keyNameMatch(name)
@@ -218,7 +318,11 @@ func NewApp(cfg Config) (app *App, err error) {
 			app.mergeLine(former, addition)
 		},
 		Colors:            cfg.Colors,
+		Theme:             cfg.Theme,
+		LightTheme:        cfg.LightTheme,
+		BufferGroups:      cfg.BufferGroups,
 		LocalIntegrations: cfg.LocalIntegrations,
+		ViMode:            cfg.ViMode,
 		WithTTY:           cfg.WithTTY,
 		WithConsole:       cfg.WithConsole,
 	})
@@ -254,6 +358,7 @@ func (app *App) Close() {
 	ui.DBusStop()
 	app.harperClose()
 	app.closing.Store(true)
+	app.closeSubscribers()
 	go func() {
 		// drain remaining events
 		for {
@@ -277,7 +382,14 @@ func (app *App) Run() {
 	}
 	app.harperInit()
 	go app.uiLoop()
-	go app.ircLoop("")
+	if app.wantsNetwork("") {
+		go app.ircLoop("")
+	}
+	for _, srv := range app.cfg.Servers {
+		netID := serverNetID(srv.Name)
+		app.win.AddBuffer(netID, srv.Name, "")
+		go app.ircLoop(netID)
+	}
 	app.eventLoop()
 }
 
@@ -425,22 +537,64 @@ func (app *App) findNetworkByLink(link string) (host string, target string, netI
 	return u.Host, target, ""
 }
 
+// serverNetID returns the network ID of the independent server of the given
+// name.
+func serverNetID(name string) string {
+	return "server:" + name
+}
+
+// serverConfig returns the settings used to connect to the network netID:
+// either those of an independent server, or those of the main server for
+// bouncer networks.
+func (app *App) serverConfig(netID string) *ServerConfig {
+	if srv, ok := app.servers[netID]; ok {
+		return srv
+	}
+	return &app.cfg.ServerConfig
+}
+
+// saslClient returns the SASL client to authenticate to srv with, or nil if
+// no authentication is configured.
+func saslClient(srv *ServerConfig) irc.SASLClient {
+	mech := srv.SASLMechanism
+	if mech == "" {
+		if srv.Password != nil {
+			mech = "plain"
+		} else if srv.TLSCert != "" {
+			mech = "external"
+		}
+	}
+	switch mech {
+	case "plain":
+		return &irc.SASLPlain{
+			Username: srv.User,
+			Password: *srv.Password,
+		}
+	case "scram-sha-256":
+		return &irc.SASLScramSHA256{
+			Username: srv.User,
+			Password: *srv.Password,
+		}
+	case "external":
+		return &irc.SASLExternal{}
+	default:
+		return nil
+	}
+}
+
 // ircLoop maintains a connection to the IRC server by connecting and then
 // forwarding IRC events to app.events repeatedly.
 func (app *App) ircLoop(netID string) {
-	var auth irc.SASLClient
-	if app.cfg.Password != nil {
-		auth = &irc.SASLPlain{
-			Username: app.cfg.User,
-			Password: *app.cfg.Password,
-		}
-	}
+	srv := app.serverConfig(netID)
+	auth := saslClient(srv)
+	_, standalone := app.servers[netID]
 	params := irc.SessionParams{
-		Nickname: app.cfg.Nick,
-		Username: app.cfg.User,
-		RealName: app.cfg.Real,
-		NetID:    netID,
-		Auth:     auth,
+		Nickname:   srv.Nick,
+		Username:   srv.User,
+		RealName:   srv.Real,
+		NetID:      netID,
+		Auth:       auth,
+		Standalone: standalone,
 	}
 	const throttleInterval = 6 * time.Second
 	const throttleMax = 1 * time.Minute
@@ -453,7 +607,7 @@ func (app *App) ircLoop(netID string) {
 		if delay < throttleMax {
 			delay += throttleInterval
 		}
-		conn := app.connect(netID)
+		conn := app.connect(netID, srv)
 		if conn == nil {
 			continue
 		}
@@ -504,12 +658,12 @@ func (app *App) ircLoop(netID string) {
 	}
 }
 
-func (app *App) connect(netID string) net.Conn {
+func (app *App) connect(netID string, srv *ServerConfig) net.Conn {
 	app.queueStatusLine(netID, ui.Line{
 		Head: ui.PlainString("--"),
-		Body: ui.PlainSprintf("Connecting to %s...", app.cfg.Addr),
+		Body: ui.PlainSprintf("Connecting to %s...", srv.Addr),
 	})
-	conn, err := app.tryConnect()
+	conn, err := app.tryConnect(srv)
 	if err == nil {
 		return conn
 	}
@@ -520,14 +674,14 @@ func (app *App) connect(netID string) net.Conn {
 	return nil
 }
 
-func (app *App) tryConnect() (conn net.Conn, err error) {
-	addr := app.cfg.Addr
+func (app *App) tryConnect(srv *ServerConfig) (conn net.Conn, err error) {
+	addr := srv.Addr
 	colonIdx := strings.LastIndexByte(addr, ':')
 	bracketIdx := strings.LastIndexByte(addr, ']')
 	if colonIdx <= bracketIdx {
 		// either colonIdx < 0, or the last colon is before a ']' (end
 		// of IPv6 address). -> missing port
-		if app.cfg.TLS {
+		if srv.TLS {
 			addr += ":6697"
 		} else {
 			addr += ":6667"
@@ -545,13 +699,26 @@ func (app *App) tryConnect() (conn net.Conn, err error) {
 		return nil, fmt.Errorf("connect: %v", err)
 	}
 
-	if app.cfg.TLS {
+	if srv.TLS {
 		host, _, _ := net.SplitHostPort(addr) // should succeed since net.Dial did.
-		conn = tls.Client(conn, &tls.Config{
+		tlsConfig := &tls.Config{
 			ServerName:         host,
-			InsecureSkipVerify: app.cfg.TLSSkipVerify,
+			InsecureSkipVerify: srv.TLSSkipVerify,
 			NextProtos:         []string{"irc"},
-		})
+		}
+		if srv.TLSCert != "" {
+			key := srv.TLSKey
+			if key == "" {
+				key = srv.TLSCert
+			}
+			cert, err := tls.LoadX509KeyPair(srv.TLSCert, key)
+			if err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("loading tls certificate: %v", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		conn = tls.Client(conn, tlsConfig)
 		err = conn.(*tls.Conn).HandshakeContext(ctx)
 		if err != nil {
 			conn.Close()
@@ -574,7 +741,7 @@ func (app *App) debugOutputMessages(netID string, out chan<- irc.Message) chan<-
 				d.Params = append([]string{d.Params[0], placeholder}, d.Params[2:]...)
 			} else if msg.Command == "AUTHENTICATE" && len(d.Params) >= 1 {
 				switch d.Params[0] {
-				case "*", "PLAIN":
+				case "*", "+", "PLAIN", "EXTERNAL", "SCRAM-SHA-256":
 				default:
 					d.Params = append([]string{placeholder}, d.Params[1:]...)
 				}
@@ -606,6 +773,8 @@ func (app *App) handleUIEvent(ev interface{}) bool {
 	// TODO: when a no-modifier no-button mouse motion event is sent, just set the mouse cursor and avoid redrawing
 	// TODO: eat QuitEvent here?
 	switch ev := ev.(type) {
+	case *varlinkCall:
+		ev.run()
 	case vaxis.Resize:
 		app.win.SetWinPixels(ev.XPixel, ev.YPixel)
 		app.win.Resize()
@@ -640,6 +809,20 @@ func (app *App) handleUIEvent(ev interface{}) bool {
 		app.win.SetColorTheme(ev.Mode)
 	case *ui.NotifyEvent:
 		app.win.JumpBufferNetwork(ev.NetID, ev.Buffer)
+	case *ui.NotifyReplyEvent:
+		if err := app.sendMessage(ev.NetID, ev.Buffer, ev.Text); err != nil {
+			app.win.AddLine(ev.NetID, ev.Buffer, ui.Line{
+				At:     time.Now(),
+				Head:   ui.ColorString("!!", ui.ColorRed),
+				Notify: ui.NotifyUnread,
+				Body:   ui.PlainSprintf("REPLY: %s", err),
+			})
+		}
+	case *ui.NotifyReadEvent:
+		if s := app.sessions[ev.NetID]; s != nil {
+			s.ReadSet(ev.Buffer, ev.At)
+		}
+		app.win.SetRead(ev.NetID, ev.Buffer, ev.At)
 	case *ui.ScreenshotEvent:
 		if err := commandDoUpload(app, []string{ev.Path}); err != nil {
 			netID, buffer := app.win.CurrentBuffer()
@@ -651,6 +834,24 @@ func (app *App) handleUIEvent(ev interface{}) bool {
 			})
 			break
 		}
+	case *galleryImageLoaded:
+		app.handleGalleryImageLoaded(ev)
+	case *unfurlLoaded:
+		app.handleUnfurlLoaded(ev)
+	case *logHistoryLoaded:
+		app.handleLogHistoryLoaded(ev)
+	case *logSearchDone:
+		app.handleLogSearchDone(ev)
+	case *composeDone:
+		if err := app.handleComposeDone(ev); err != nil {
+			netID, buffer := app.win.CurrentBuffer()
+			app.win.AddLine(netID, buffer, ui.Line{
+				At:     time.Now(),
+				Head:   ui.ColorString("!!", ui.ColorRed),
+				Notify: ui.NotifyUnread,
+				Body:   ui.PlainSprintf("compose: %s", err),
+			})
+		}
 	case statusLine:
 		app.addStatusLine(ev.netID, ev.line)
 	case *events.EventClickNick:
@@ -659,6 +860,8 @@ func (app *App) handleUIEvent(ev interface{}) bool {
 		app.handleLinkEvent(ev)
 	case *events.EventClickChannel:
 		app.handleChannelEvent(ev)
+	case *events.EventClickReply:
+		app.win.SelectID(ev.NetID, ev.Buffer, ev.ID)
 	case *events.EventImageLoaded:
 		app.win.ShowImage(ev.Image)
 		if ev.Image == nil {
@@ -741,6 +944,12 @@ func (app *App) handleMouseEvent(ev vaxis.Mouse) {
 	x, y := ev.Col, ev.Row
 	w, h := app.win.Size()
 
+	if app.gallery != nil && ev.Button == vaxis.MouseLeftButton {
+		if ev.EventType == vaxis.EventPress {
+			app.closeGallery()
+		}
+		return
+	}
 	if app.imageOverlay && ev.Button == vaxis.MouseLeftButton {
 		if ev.EventType == vaxis.EventPress {
 			app.win.ShowImage(nil)
@@ -784,7 +993,9 @@ func (app *App) handleMouseEvent(ev vaxis.Mouse) {
 			if x == app.win.ChannelWidth()-1 {
 				app.win.ClickChannelCol(true)
 			} else if x < app.win.ChannelWidth() {
-				app.win.ClickBuffer(app.win.VerticalBufferOffset(y))
+				if !app.win.ToggleBufferGroupAt(y) {
+					app.win.ClickBuffer(app.win.VerticalBufferOffset(y))
+				}
 			} else if app.win.ChannelWidth() == 0 && y == h-1 {
 				app.win.ClickBuffer(app.win.HorizontalBufferOffset(x))
 			} else if x == w-app.win.MemberWidth() {
@@ -852,7 +1063,7 @@ func (app *App) handleMouseEvent(ev vaxis.Mouse) {
 	}
 	if ev.EventType == vaxis.EventRelease {
 		if x < app.win.ChannelWidth()-1 {
-			if i := app.win.VerticalBufferOffset(y); i == app.win.ClickedBuffer() {
+			if i := app.win.VerticalBufferOffset(y); i >= 0 && i == app.win.ClickedBuffer() {
 				app.win.GoToBufferNo(i)
 				app.clearBufferCommand()
 				app.spellCheck()
@@ -975,9 +1186,17 @@ func (app *App) handleAction(action string, args ...string) {
 	case "cursor-left":
 		app.win.InputLeft()
 	case "cursor-up":
-		app.win.InputUp()
+		if _, ok := app.win.Selection(); ok {
+			app.win.SelectPrevious()
+		} else {
+			app.win.InputUp()
+		}
 	case "cursor-down":
-		app.win.InputDown()
+		if _, ok := app.win.Selection(); ok {
+			app.win.SelectNext()
+		} else {
+			app.win.InputDown()
+		}
 	case "cursor-delete-previous-word":
 		if app.win.InputDeleteWord() {
 			app.typing()
@@ -1008,15 +1227,98 @@ func (app *App) handleAction(action string, args ...string) {
 			app.typing()
 			app.spellCheck()
 		}
+	case "yank":
+		if app.win.InputYank() {
+			app.typing()
+			app.spellCheck()
+		}
+	case "yank-pop":
+		if app.win.InputYankPop() {
+			app.typing()
+			app.spellCheck()
+		}
+	case "transpose-chars":
+		if app.win.InputTransposeChars() {
+			app.typing()
+			app.spellCheck()
+		}
+	case "transpose-words":
+		if app.win.InputTransposeWords() {
+			app.typing()
+			app.spellCheck()
+		}
+	case "undo":
+		if app.win.InputUndo() {
+			app.typing()
+			app.spellCheck()
+		}
+	case "redo":
+		if app.win.InputRedo() {
+			app.typing()
+			app.spellCheck()
+		}
 	case "search-editor":
-		app.win.InputBackSearch()
+		app.win.InputBackSearch(false)
+	case "search-editor-buffer":
+		app.win.InputBackSearch(true)
 	case "auto-complete":
 		if app.win.InputAutoComplete() {
 			app.typing()
 			app.spellCheck()
 		}
 	case "close-overlay":
-		app.win.CloseOverlay()
+		if app.win.ClearSelection() {
+			break
+		}
+		if app.win.HasOverlay() {
+			app.win.CloseOverlay()
+		} else {
+			app.returnToPresent()
+		}
+	case "select-previous":
+		app.win.SelectPrevious()
+	case "select-next":
+		app.win.SelectNext()
+	case "gallery":
+		if err := app.openGallery(); err != nil {
+			netID, buffer := app.win.CurrentBuffer()
+			app.win.AddLine(netID, buffer, ui.Line{
+				At:     time.Now(),
+				Head:   ui.ColorString("!!", ui.ColorRed),
+				Notify: ui.NotifyUnread,
+				Body:   ui.PlainSprintf("gallery: %s", err),
+			})
+		}
+	case "compose":
+		if err := app.compose(); err != nil {
+			netID, buffer := app.win.CurrentBuffer()
+			app.win.AddLine(netID, buffer, ui.Line{
+				At:     time.Now(),
+				Head:   ui.ColorString("!!", ui.ColorRed),
+				Notify: ui.NotifyUnread,
+				Body:   ui.PlainSprintf("compose: %s", err),
+			})
+		}
+	case "selection-copy", "selection-open-links", "selection-quote", "selection-raw":
+		if err := app.handleSelectionAction(action); err != nil {
+			netID, buffer := app.win.CurrentBuffer()
+			app.win.AddLine(netID, buffer, ui.Line{
+				At:     time.Now(),
+				Head:   ui.ColorString("!!", ui.ColorRed),
+				Notify: ui.NotifyUnread,
+				Body:   ui.PlainSprintf("%s: %s", action, err),
+			})
+		}
+	case "group-collapse":
+		app.win.SetBufferGroupCollapsed(true)
+	case "group-expand":
+		app.win.SetBufferGroupCollapsed(false)
+	case "group-toggle":
+		app.win.ToggleBufferGroup()
+	case "group-collapse-all":
+		app.win.SetAllBufferGroupsCollapsed(true)
+	case "group-expand-all":
+		app.win.SetAllBufferGroupsCollapsed(false)
 	case "toggle-channel-list":
 		app.win.ToggleChannelList()
 	case "toggle-member-list":
@@ -1025,21 +1327,31 @@ func (app *App) handleAction(action string, args ...string) {
 		if !app.win.InputEnter() {
 			netID, buffer := app.win.CurrentBuffer()
 			input := string(app.win.InputContent())
-			var err error
-			for _, part := range strings.Split(input, "\n") {
-				if err = app.handleInput(buffer, part); err != nil {
+			parts := strings.Split(input, "\n")
+			if len(parts) > 1 && !hasCommand(parts) {
+				// Send messages spanning several lines at once, so that
+				// they can be sent as a single multiline message.
+				parts = []string{strings.Trim(input, "\n")}
+			}
+			// Flush the input first, as commands may switch to another
+			// buffer, which has its own draft.
+			app.win.InputFlush()
+			for _, part := range parts {
+				if err := app.handleInput(buffer, part); err != nil {
 					app.win.AddLine(netID, buffer, ui.Line{
 						At:     time.Now(),
 						Head:   ui.ColorString("!!", ui.ColorRed),
 						Notify: ui.NotifyUnread,
 						Body:   ui.PlainSprintf("%q: %s", input, err),
 					})
+					// Keep failed input out of the history, as it is
+					// restored to be fixed and sent again.
+					app.win.InputDropLastHistory()
+					app.win.InputSet(input)
 					break
 				}
 			}
-			if err == nil {
-				app.win.InputFlush()
-			}
+			app.saveInputHistory()
 		}
 	case "scroll-next-highlight":
 		app.win.ScrollDownHighlight()
@@ -1101,8 +1413,16 @@ var defaultCommands = map[string][]string{
 	"Control+Left":    {"cursor-left-word"},
 	"Left":            {"cursor-left"},
 	"Alt+Up":          {"buffer-previous"},
+	"Control+Up":      {"select-previous"},
 	"Up":              {"cursor-up"},
 	"Alt+Down":        {"buffer-next"},
+	"Control+Down":    {"select-next"},
+	"Alt+g":           {"gallery"},
+	"Alt+e":           {"compose"},
+	"Alt+c":           {"selection-copy"},
+	"Alt+o":           {"selection-open-links"},
+	"Alt+q":           {"selection-quote"},
+	"Alt+r":           {"selection-raw"},
 	"Down":            {"cursor-down"},
 	"Alt+Home":        {"buffer", "0"},
 	"Home":            {"cursor-start"},
@@ -1114,11 +1434,21 @@ var defaultCommands = map[string][]string{
 	"Shift+BackSpace": {"cursor-delete-previous"},
 	"Delete":          {"cursor-delete-next"},
 	"Control+w":       {"cursor-delete-previous-word"},
+	"Control+y":       {"yank"},
+	"Alt+y":           {"yank-pop"},
+	"Control+t":       {"transpose-chars"},
+	"Alt+t":           {"transpose-words"},
+	"Control+_":       {"undo"},
+	"Control+/":       {"undo"},
+	"Alt+_":           {"redo"},
 	"Control+r":       {"search-editor"},
+	"Control+Alt+r":   {"search-editor-buffer"},
 	"Tab":             {"auto-complete"},
 	"Escape":          {"close-overlay"},
 	"F7":              {"toggle-channel-list"},
 	"F8":              {"toggle-member-list"},
+	"Alt+minus":       {"group-collapse"},
+	"Alt+equal":       {"group-expand"},
 	"\n":              {"send"},
 	"\r":              {"send"},
 	"Control+j":       {"send"},
@@ -1146,12 +1476,95 @@ var defaultCommands = map[string][]string{
 	"Alt+KP_9":        {"buffer", "8"},
 }
 
+// handleSelectionAction runs an action on the message selected in the
+// timeline.
+func (app *App) handleSelectionAction(action string) error {
+	line, ok := app.win.Selection()
+	if !ok {
+		return errors.New("no message selected")
+	}
+	if line.Redacted && (action == "selection-copy" || action == "selection-raw") {
+		return errors.New("the selected message was deleted")
+	}
+	switch action {
+	case "selection-copy":
+		app.win.CopyToClipboard(line.Body.String())
+	case "selection-open-links":
+		links := line.Body.URLs()
+		if len(links) == 0 {
+			return errors.New("no links in the selected message")
+		}
+		go func() {
+			for _, link := range links {
+				openLink(link)
+			}
+		}()
+	case "selection-quote":
+		quote := quoteLine(line)
+		if content := app.win.InputContent(); len(content) > 0 {
+			quote += "\n" + string(content)
+		}
+		app.win.InputSet(quote)
+		app.typing()
+		app.spellCheck()
+	case "selection-raw":
+		ev, ok := line.Data.(irc.MessageEvent)
+		if !ok || ev.Raw == "" {
+			return errors.New("the raw message is unknown")
+		}
+		app.showRawMessage(ev)
+	}
+	return nil
+}
+
+// quoteLine returns the quote of a line to insert in the editor.
+func quoteLine(line ui.Line) string {
+	var sb strings.Builder
+	sb.WriteString("> ")
+	if head := line.Head.String(); head != "" && head != "*" {
+		sb.WriteString("<" + head + "> ")
+	}
+	sb.WriteString(strings.ReplaceAll(line.Body.String(), "\n", "\n> "))
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+// showRawMessage opens an overlay with the raw IRC message of ev and its
+// tags.
+func (app *App) showRawMessage(ev irc.MessageEvent) {
+	app.win.OpenOverlay("Press Escape to close the raw message")
+	lines := []ui.Line{{
+		At:   ev.Time,
+		Head: ui.PlainString("raw"),
+		Body: ui.PlainString(ev.Raw),
+	}}
+	if msg, err := irc.ParseMessage(ev.Raw); err == nil {
+		keys := make([]string, 0, len(msg.Tags))
+		for k := range msg.Tags {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			lines = append(lines, ui.Line{
+				At:   ev.Time,
+				Head: ui.PlainString(k),
+				Body: ui.PlainString(msg.Tags[k]),
+			})
+		}
+	}
+	app.win.AddLines("", ui.Overlay, lines, nil)
+}
+
 func (app *App) handleKeyEvent(ev vaxis.Key) {
 	switch ev.EventType {
 	case vaxis.EventPress, vaxis.EventRepeat, vaxis.EventPaste:
 	default:
 		return
 	}
+	if app.gallery != nil {
+		app.handleGalleryKey(ev)
+		return
+	}
 	if len(ev.Text) == 1 && ev.Text[0] < ' ' {
 		// Drop control characters text (sent by some terminal emulators)
 		ev.Text = ""
@@ -1160,6 +1573,13 @@ func (app *App) handleKeyEvent(ev vaxis.Key) {
 		// Drop text when sent with modifiers preventing text
 		ev.Text = ""
 	}
+	if handled, changed := app.win.InputViKey(ev); handled {
+		if changed {
+			app.typing()
+			app.spellCheck()
+		}
+		return
+	}
 	if ev.Text != "" {
 		for _, r := range ev.Text {
 			app.win.InputRune(r)
@@ -1212,114 +1632,50 @@ func (app *App) handleChannelEvent(ev *events.EventClickChannel) {
 	}
 }
 
-var patternOpenGraphImage = regexp.MustCompile(`<meta property="og:image" content="(.*?)"/?>`)
-var patternOpenGraphVideo = regexp.MustCompile(`<meta property="og:video"`)
-
 func (app *App) fetchImage(link string) (image.Image, error) {
-	userAgent := "senpai"
-	if v, ok := BuildVersion(); ok {
-		userAgent = "senpai/" + v
-	}
-
-	if u, err := url.Parse(link); err == nil {
-		changed := true
-		switch u.Host {
-		case "twitter.com", "x.com":
-			u.Host = "fixupx.com"
-		default:
-			changed = false
-		}
-		if changed {
-			link = u.String()
-		}
-	}
-
-	cHead := http.Client{
-		Timeout: 1500 * time.Millisecond,
-	}
-	req, err := http.NewRequest("HEAD", link, nil)
+	b, err := app.images.Fetch(context.Background(), link)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", userAgent)
-	res, err := cHead.Do(req)
+	img, _, err := app.win.DecodeImage(bytes.NewReader(b))
 	if err != nil {
 		return nil, err
 	}
-	res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
-	}
-	contentType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
-	if err != nil {
-		return nil, fmt.Errorf("unexpected content type: %v", res.Header.Get("Content-Type"))
-	}
-	var isHTML bool
-	switch contentType {
-	case "image/gif", "image/jpeg", "image/png": // Actual image, fetch
-	case "text/html": // Might have an opengraph image, try fetching
-		isHTML = true
-	default:
-		return nil, fmt.Errorf("unexpected content type: %v", contentType)
+	return img, nil
+}
+
+// previewAllowed reports whether previews can be fetched for the links sent
+// by user, empty if unknown, in buffer.
+func (app *App) previewAllowed(netID, buffer, user string) bool {
+	s := app.sessions[netID]
+	if s == nil || buffer == "" || !s.IsChannel(buffer) {
+		return true
 	}
-	if isHTML {
-		req, err := http.NewRequest("GET", link, nil)
-		if err != nil {
-			return nil, err
-		}
-		req.Header.Set("User-Agent", userAgent)
-		var previewSize int64 = 10 * 1024
-		if res.Header.Get("Accept-Ranges") == "bytes" {
-			req.Header.Set("Range", fmt.Sprintf("bytes=0-%v", previewSize))
-		}
-		res, err = cHead.Do(req)
-		if err != nil {
-			return nil, err
-		}
-		b, err := io.ReadAll(io.LimitReader(res.Body, previewSize))
-		res.Body.Close()
-		if err != nil {
-			return nil, fmt.Errorf("unexpected read error: %v", err)
-		}
-		if patternOpenGraphVideo.Match(b) {
-			// Do not display image (previews) of video objects
-			return nil, fmt.Errorf("video embed found")
-		}
-		m := patternOpenGraphImage.FindSubmatch(b)
-		if len(m) < 2 {
-			return nil, fmt.Errorf("image embed not found")
+	for _, channel := range app.cfg.Previews.Untrusted {
+		if s.Casemap(channel) == s.Casemap(buffer) {
+			return false
 		}
-		link = html.UnescapeString(string(m[1]))
-	}
-	cGet := http.Client{
-		Timeout: 5 * time.Second,
-	}
-	req, err = http.NewRequest("GET", link, nil)
-	if err != nil {
-		return nil, err
 	}
-	req.Header.Set("User-Agent", userAgent)
-	res, err = cGet.Do(req)
-	if err != nil {
-		return nil, err
+	if app.cfg.Previews.MembersOnly && user != "" && !s.IsMe(user) && !s.IsMember(buffer, user) {
+		return false
 	}
-	img, _, err := app.win.DecodeImage(res.Body)
-	res.Body.Close()
-	if err != nil {
-		return nil, err
+	return true
+}
+
+// openLink opens link in the default application, waiting for it to exit.
+func openLink(link string) {
+	if strings.HasPrefix(link, "-") {
+		// Avoid injection of parameters.
+		// Sadly xdg-open does not support "--"...
+		return
 	}
-	return img, nil
+	cmd := exec.Command("xdg-open", link)
+	cmd.Run()
 }
 
 func (app *App) handleLinkEvent(ev *events.EventClickLink) {
 	open := func() {
-		if strings.HasPrefix(ev.Link, "-") {
-			// Avoid injection of parameters.
-			// Sadly xdg-open does not support "--"...
-			return
-		}
-		cmd := exec.Command("xdg-open", ev.Link)
-		cmd.Run()
+		openLink(ev.Link)
 	}
 
 	if ev.Event.Modifiers == vaxis.ModCtrl {
@@ -1337,6 +1693,17 @@ func (app *App) handleLinkEvent(ev *events.EventClickLink) {
 	// Try fetching as an image and displaying a preview;
 	// fall back to xdg-open if mouse links are enabled.
 
+	var user string
+	if m, ok := ev.Data.(irc.MessageEvent); ok {
+		user = m.User
+	}
+	if !app.previewAllowed(ev.NetID, ev.Buffer, user) {
+		if ev.Mouse {
+			go open()
+		}
+		return
+	}
+
 	app.imageLoading = true
 	go func() {
 		img, err := app.fetchImage(ev.Link)
@@ -1361,7 +1728,7 @@ func (app *App) handleLinkEvent(ev *events.EventClickLink) {
 	}()
 }
 
-func (app *App) upload(url string, r io.Reader, size int64, filename, mimetype string) (string, error) {
+func (app *App) upload(netID, url string, r io.Reader, size int64, filename, mimetype string) (string, error) {
 	c := http.Client{
 		Timeout: 30 * time.Second,
 	}
@@ -1384,8 +1751,8 @@ func (app *App) upload(url string, r io.Reader, size int64, filename, mimetype s
 	if err != nil {
 		return "", fmt.Errorf("creating upload request: %v", err)
 	}
-	if app.cfg.Password != nil {
-		req.SetBasicAuth(app.cfg.User, *app.cfg.Password)
+	if srv := app.serverConfig(netID); srv.Password != nil {
+		req.SetBasicAuth(srv.User, *srv.Password)
 	}
 	if size >= 0 {
 		req.ContentLength = size
@@ -1432,14 +1799,14 @@ func (app *App) upload(url string, r io.Reader, size int64, filename, mimetype s
 	return location.String(), nil
 }
 
-func (app *App) handleUpload(url string, r io.Reader, size int64, filename, mimetype string, closer io.Closer) {
+func (app *App) handleUpload(netID, url string, r io.Reader, size int64, filename, mimetype string, closer io.Closer) {
 	var progress float64 = 0
 	app.uploadingProgress = &progress
 	go func() {
 		if closer != nil {
 			defer closer.Close()
 		}
-		location, err := app.upload(url, r, size, filename, mimetype)
+		location, err := app.upload(netID, url, r, size, filename, mimetype)
 		if err != nil {
 			app.postEvent(event{
 				src: "*",
@@ -1470,11 +1837,23 @@ func (app *App) maybeRequestHistory() {
 		return
 	}
 	bk := boundKey{netID, s.Casemap(buffer)}
+	_, h := app.win.Size()
+	if w, ok := app.windows[bk]; ok {
+		app.maybeRequestWindowHistory(s, bk, buffer, w, h)
+		return
+	}
 	if app.messageBounds[bk].complete {
 		return
 	}
-	_, h := app.win.Size()
 	if l := app.win.LinesAboveOffset(); l < h*2 && buffer != "" {
+		if !s.HasCapability("draft/chathistory") && app.logs != nil {
+			before := time.Now()
+			if bound, ok := app.messageBounds[bk]; ok {
+				before = bound.first
+			}
+			app.requestLogHistory(netID, s, buffer, before)
+			return
+		}
 		if bound, ok := app.messageBounds[bk]; ok {
 			s.NewHistoryRequest(buffer).
 				WithLimit(200).
@@ -1487,6 +1866,58 @@ func (app *App) maybeRequestHistory() {
 	}
 }
 
+// maybeRequestWindowHistory requests the history around the window of history
+// shown in a buffer when the user scrolls to one of its ends.
+func (app *App) maybeRequestWindowHistory(s *irc.Session, bk boundKey, buffer string, w *historyWindow, h int) {
+	if w.pending != "" {
+		if s.HistoryPending(buffer) {
+			return
+		}
+		// The request failed.
+		w.pending = ""
+		if !w.loaded || w.canceled {
+			delete(app.windows, bk)
+			return
+		}
+	}
+	if !app.win.IsDetached(bk.netID, buffer) {
+		// The buffer was removed and added again.
+		delete(app.windows, bk)
+		return
+	}
+	if app.win.LinesAboveOffset() < h*2 && !w.complete {
+		w.pending = "before"
+		s.NewHistoryRequest(buffer).
+			WithLimit(200).
+			Before(w.first)
+	} else if app.win.IsAtBottom() {
+		w.pending = "after"
+		s.NewHistoryRequest(buffer).
+			WithLimit(200).
+			After(w.last)
+	}
+}
+
+// returnToPresent closes the window of history of the current buffer, if
+// any, and shows its live lines again.
+func (app *App) returnToPresent() {
+	netID, buffer := app.win.CurrentBuffer()
+	s := app.sessions[netID]
+	if s == nil {
+		app.win.Attach(netID, buffer)
+		return
+	}
+	bk := boundKey{netID, s.Casemap(buffer)}
+	if w, ok := app.windows[bk]; ok {
+		if w.pending != "" {
+			w.canceled = true
+		} else {
+			delete(app.windows, bk)
+		}
+	}
+	app.win.Attach(netID, buffer)
+}
+
 func (app *App) handleIRCEvent(netID string, ev interface{}) {
 	if ev == nil {
 		if s, ok := app.sessions[netID]; ok {
@@ -1566,7 +1997,8 @@ func (app *App) handleIRCEvent(netID string, ev interface{}) {
 	// Mutate UI state
 	switch ev := ev.(type) {
 	case irc.RegisteredEvent:
-		for _, channel := range app.cfg.Channels {
+		srv := app.serverConfig(netID)
+		for _, channel := range srv.Channels {
 			// TODO: group JOIN messages
 			// TODO: support autojoining channels with keys
 			s.Join(channel, "")
@@ -1575,7 +2007,7 @@ func (app *App) handleIRCEvent(netID string, ev interface{}) {
 			WithLimit(1000).
 			Targets(app.lastCloseTime, msg.TimeOrNow())
 		body := "Connected to the server"
-		if s.Nick() != app.cfg.Nick {
+		if s.Nick() != srv.Nick {
 			body = fmt.Sprintf("Connected to the server as %s", s.Nick())
 		}
 		app.addStatusLine(netID, ui.Line{
@@ -1639,17 +2071,15 @@ func (app *App) handleIRCEvent(netID string, ev interface{}) {
 			break
 		}
 		var body ui.StyledStringBuilder
-		body.WriteString(fmt.Sprintf("%s\u2192%s", ev.FormerNick, s.Nick()))
-		textStyle := vaxis.Style{
-			Foreground: app.cfg.Colors.Status,
-		}
-		var arrowStyle vaxis.Style
-		body.AddStyle(0, textStyle)
-		body.AddStyle(len(ev.FormerNick), arrowStyle)
-		body.AddStyle(body.Len()-len(s.Nick()), textStyle)
+		body.SetServerStyle()
+		body.WriteString(ev.FormerNick)
+		body.SetStyle(vaxis.Style{})
+		body.WriteString("\u2192")
+		body.SetServerStyle()
+		body.WriteString(s.Nick())
 		app.addStatusLine(netID, ui.Line{
 			At:        msg.TimeOrNow(),
-			Head:      ui.ColorString("--", app.cfg.Colors.Status),
+			Head:      ui.ServerString("--"),
 			Body:      body.StyledString(),
 			Highlight: true,
 			Readable:  true,
@@ -1686,6 +2116,7 @@ func (app *App) handleIRCEvent(netID string, ev interface{}) {
 				WithLimit(1000).
 				After(bounds.last)
 		}
+		app.publish("join", netID, ev.Channel, s.Nick(), nil, msg.TimeOrNow())
 		if ev.Requested {
 			app.win.JumpBufferIndex(i)
 		}
@@ -1702,6 +2133,7 @@ func (app *App) handleIRCEvent(netID string, ev interface{}) {
 			app.lastBuffer = ""
 		}
 	case irc.UserJoinEvent:
+		app.publish("join", netID, ev.Channel, ev.User, nil, ev.Time)
 		if !app.cfg.StatusEnabled {
 			break
 		}
@@ -1736,6 +2168,9 @@ func (app *App) handleIRCEvent(netID string, ev interface{}) {
 		line := app.formatEvent(ev)
 		app.win.AddLine(netID, ev.Channel, line)
 	case irc.InviteEvent:
+		if app.isIgnored(s, s.UserPrefix(ev.Inviter)) {
+			break
+		}
 		var buffer string
 		var notify ui.NotifyType
 		var body string
@@ -1753,20 +2188,28 @@ func (app *App) handleIRCEvent(netID string, ev interface{}) {
 			body = fmt.Sprintf("%s invited %s to join this channel", ev.Inviter, ev.Invitee)
 		}
 		app.win.AddLine(netID, buffer, ui.Line{
-			At:     msg.TimeOrNow(),
-			Head:   ui.ColorString("--", app.cfg.Colors.Status),
-			Notify: notify,
-			Body: ui.Styled(body, vaxis.Style{
-				Foreground: app.cfg.Colors.Status,
-			}),
+			At:        msg.TimeOrNow(),
+			Head:      ui.ServerString("--"),
+			Notify:    notify,
+			Body:      ui.ServerString(body),
 			Highlight: notify == ui.NotifyHighlight,
 			Readable:  true,
 		})
 	case irc.MessageEvent:
+		ignored := app.isIgnored(s, messagePrefix(s, ev))
+		if !ignored {
+			app.logMessage(s, netID, ev)
+		}
 		buffer, line := app.formatMessage(s, ev)
 		if line.IsZero() {
 			break
 		}
+		if ignored {
+			if app.cfg.IgnoreCollapse {
+				app.win.AddLine(netID, buffer, app.formatEvent(ignoredEvent{Time: line.At}))
+			}
+			break
+		}
 		if buffer != "" && !s.IsChannel(buffer) {
 			t, ok := msg.Time()
 			if !ok {
@@ -1775,10 +2218,16 @@ func (app *App) handleIRCEvent(netID string, ev interface{}) {
 			app.addUserBuffer(netID, buffer, t)
 		}
 		app.win.AddLine(netID, buffer, line)
+		app.maybeUnfurl(netID, buffer, ev, line)
+		app.recordActivity(netID, s, buffer, ev, line)
+		body := line.Body.String()
 		if line.Notify == ui.NotifyHighlight {
 			curNetID, curBuffer := app.win.CurrentBuffer()
 			current := app.win.Focused() && curNetID == netID && s.Casemap(curBuffer) == s.Casemap(buffer)
-			app.notifyHighlight(buffer, ev.User, line.Body.String(), current)
+			app.notifyHighlight(buffer, ev.User, body, current)
+			app.publish("highlight", netID, buffer, ev.User, &body, ev.Time)
+		} else {
+			app.publish("message", netID, buffer, ev.User, &body, ev.Time)
 		}
 		if !ev.TargetIsChannel && !s.IsMe(ev.User) {
 			app.lastQuery = ev.User
@@ -1788,6 +2237,10 @@ func (app *App) handleIRCEvent(netID string, ev interface{}) {
 		bounds := app.messageBounds[bk]
 		bounds.Update(&line)
 		app.messageBounds[bk] = bounds
+	case irc.ReactionEvent:
+		app.addReaction(s, ev)
+	case irc.RedactEvent:
+		app.redactLine(s, ev)
 	case irc.HistoryTargetsEvent:
 		type target struct {
 			name string
@@ -1814,65 +2267,14 @@ func (app *App) handleIRCEvent(netID string, ev interface{}) {
 			app.addUserBuffer(netID, target.name, target.last)
 		}
 	case irc.HistoryEvent:
-		var linesBefore []ui.Line
-		var linesAfter []ui.Line
 		bk := boundKey{netID, s.Casemap(ev.Target)}
-		bounds, hasBounds := app.messageBounds[bk]
-		boundsNew := bounds
-		for _, m := range ev.Messages {
-			var line ui.Line
-			switch ev := m.(type) {
-			case irc.MessageEvent:
-				_, line = app.formatMessage(s, ev)
-			default:
-				line = app.formatEvent(ev)
-			}
-			if line.IsZero() {
-				continue
-			}
-			boundsNew.Update(&line)
-			if _, ok := m.(irc.MessageEvent); !ok && !app.cfg.StatusEnabled {
-				continue
-			}
-			if hasBounds {
-				c := bounds.Compare(&line)
-				if c < 0 {
-					linesBefore = append(linesBefore, line)
-				} else if c > 0 {
-					linesAfter = append(linesAfter, line)
-				}
-			} else {
-				linesBefore = append(linesBefore, line)
-			}
-		}
-		app.win.AddLines(netID, ev.Target, linesBefore, linesAfter)
-
-		if !boundsNew.IsZero() {
-			app.messageBounds[bk] = boundsNew
-		}
-		if len(ev.Messages) < 10 {
-			// We're getting a non-full page: mark as complete to avoid indefinitely fetching the history.
-			// This should ideally be equal to the CHATHISTORY LIMIT, but it can be non advertised, or
-			// a full page could sometimes be less than a limit (because it could be filtered).
-			// It is also not zero, because bounds are inclusive, and not one, because we truncate based on
-			// the second of the message (because some bouncers have a second-level resolution).
-			// Be safe and pick 10 messages: less messages means that this was not a full page and we are done
-			// with fetching the backlog.
-			b := app.messageBounds[bk]
-			b.complete = true
-			app.messageBounds[bk] = b
+		if w, ok := app.windows[bk]; ok && w.pending != "" {
+			app.addWindowHistory(netID, s, w, ev)
+		} else {
+			app.addHistory(netID, s, ev)
 		}
 	case irc.SearchEvent:
-		app.win.OpenOverlay("Press Escape to close the search results")
-		lines := make([]ui.Line, 0, len(ev.Messages))
-		for _, m := range ev.Messages {
-			_, line := app.formatMessage(s, m)
-			if line.IsZero() {
-				continue
-			}
-			lines = append(lines, line)
-		}
-		app.win.AddLines("", ui.Overlay, lines, nil)
+		app.showSearchResults(s, ev.Messages)
 	case irc.ReadEvent:
 		app.win.SetRead(netID, ev.Target, ev.Timestamp)
 	case irc.MetadataChangeEvent:
@@ -1950,10 +2352,8 @@ func (app *App) handleIRCEvent(netID string, ev interface{}) {
 			}
 			app.addStatusLine(netID, ui.Line{
 				At:   msg.TimeOrNow(),
-				Head: ui.ColorString("List --", app.cfg.Colors.Status),
-				Body: ui.Styled(text, vaxis.Style{
-					Foreground: app.cfg.Colors.Status,
-				}),
+				Head: ui.ServerString("List --"),
+				Body: ui.ServerString(text),
 			})
 		}
 	case irc.InfoEvent:
@@ -1965,10 +2365,8 @@ func (app *App) handleIRCEvent(netID string, ev interface{}) {
 		}
 		app.addStatusLine(netID, ui.Line{
 			At:   msg.TimeOrNow(),
-			Head: ui.ColorString(head, app.cfg.Colors.Status),
-			Body: ui.Styled(ev.Message, vaxis.Style{
-				Foreground: app.cfg.Colors.Status,
-			}),
+			Head: ui.ServerString(head),
+			Body: ui.ServerString(ev.Message),
 		})
 	case irc.ErrorEvent:
 		var head string
@@ -1977,10 +2375,8 @@ func (app *App) handleIRCEvent(netID string, ev interface{}) {
 		case irc.SeverityNote:
 			app.addStatusLine(netID, ui.Line{
 				At:   msg.TimeOrNow(),
-				Head: ui.ColorString(fmt.Sprintf("(%s) --", ev.Code), app.cfg.Colors.Status),
-				Body: ui.Styled(ev.Message, vaxis.Style{
-					Foreground: app.cfg.Colors.Status,
-				}),
+				Head: ui.ServerString(fmt.Sprintf("(%s) --", ev.Code)),
+				Body: ui.ServerString(ev.Message),
 			})
 			return
 		case irc.SeverityFail:
@@ -2009,35 +2405,87 @@ func isWordBoundary(r rune) bool {
 	}
 }
 
-func isHighlight(text, nick string) bool {
+// wordRanges returns the byte ranges of the occurrences of word in text which
+// are surrounded by word boundaries.
+func wordRanges(text, word string) [][]int {
+	if word == "" {
+		return nil
+	}
+	var ranges [][]int
+	off := 0
 	for {
-		i := strings.Index(text, nick)
+		i := strings.Index(text[off:], word)
 		if i < 0 {
-			return false
+			return ranges
 		}
+		i += off
+		off = i + len(word)
 
 		left, _ := utf8.DecodeLastRuneInString(text[:i])
-		right, _ := utf8.DecodeRuneInString(text[i+len(nick):])
+		right, _ := utf8.DecodeRuneInString(text[off:])
 		if isWordBoundary(left) && isWordBoundary(right) {
-			return true
+			ranges = append(ranges, []int{i, off})
 		}
+	}
+}
 
-		text = text[i+len(nick):]
+// highlightRules returns the highlight rules which apply to buffer.
+func (app *App) highlightRules(s *irc.Session, buffer string) []*HighlightRule {
+	var rules []*HighlightRule
+	network := app.networkName(s.NetID())
+	for i := range app.cfg.HighlightRules {
+		rule := &app.cfg.HighlightRules[i]
+		if len(rule.Networks) > 0 && !slices.Contains(rule.Networks, network) {
+			continue
+		}
+		if len(rule.Channels) > 0 && !slices.ContainsFunc(rule.Channels, func(c string) bool {
+			return s.Casemap(c) == s.Casemap(buffer)
+		}) {
+			continue
+		}
+		rules = append(rules, rule)
 	}
+	return rules
 }
 
-// isHighlight reports whether the given message content is a highlight.
-func (app *App) isHighlight(s *irc.Session, content string) bool {
-	contentCf := s.Casemap(content)
+// highlightRanges returns the byte ranges of the highlights in the text of a
+// message sent by nick to buffer, or nil if the message is not a highlight.
+func (app *App) highlightRanges(s *irc.Session, buffer, nick, text string) [][]int {
+	rules := app.highlightRules(s, buffer)
+	for _, rule := range rules {
+		for _, re := range rule.ExcludeNicks {
+			if re.MatchString(nick) {
+				return nil
+			}
+		}
+		for _, re := range rule.Excludes {
+			if re.MatchString(text) {
+				return nil
+			}
+		}
+	}
+
+	textCf := s.Casemap(text)
+	var ranges [][]int
 	if app.highlights == nil {
-		return isHighlight(contentCf, s.NickCf())
+		ranges = wordRanges(textCf, s.NickCf())
 	}
 	for _, h := range app.highlights {
-		if isHighlight(contentCf, s.Casemap(h)) {
-			return true
+		ranges = append(ranges, wordRanges(textCf, s.Casemap(h))...)
+	}
+	for _, rule := range rules {
+		for _, k := range rule.Keywords {
+			ranges = append(ranges, wordRanges(textCf, s.Casemap(k))...)
+		}
+		for _, re := range rule.Regexps {
+			for _, r := range re.FindAllStringIndex(text, -1) {
+				if r[0] < r[1] {
+					ranges = append(ranges, r)
+				}
+			}
 		}
 	}
-	return false
+	return ranges
 }
 
 // notifyHighlight executes the script at "on-highlight-path" according to the given
@@ -2132,6 +2580,7 @@ func (app *App) completions(cursorIdx int, text []rune) []ui.Completion {
 		cs = app.completionsChannelTopic(cs, cursorIdx, text)
 		cs = app.completionsChannelMembers(cs, cursorIdx, text)
 	}
+	cs = app.completionsChannels(cs, cursorIdx, text)
 	cs = app.completionsJoin(cs, cursorIdx, text)
 	cs = app.completionsUpload(cs, cursorIdx, text)
 	cs = app.completionsMsg(cs, cursorIdx, text)
@@ -2155,6 +2604,12 @@ func (app *App) completions(cursorIdx int, text []rune) []ui.Completion {
 	return cs
 }
 
+// ignoredEvent is a message from an ignored user, shown collapsed with the
+// other events of its line.
+type ignoredEvent struct {
+	Time time.Time
+}
+
 type mergedEvent struct {
 	oldNick        string
 	nick           string
@@ -2171,22 +2626,29 @@ func (app *App) formatEvent(ev irc.Event) ui.Line {
 	switch ev := ev.(type) {
 	case irc.UserNickEvent:
 		var body ui.StyledStringBuilder
-		body.WriteString(fmt.Sprintf("%s\u2192%s", ev.FormerNick, ev.User))
-		textStyle := vaxis.Style{
-			Foreground: app.cfg.Colors.Status,
-		}
-		var arrowStyle vaxis.Style
-		body.AddStyle(0, textStyle)
-		body.AddStyle(len(ev.FormerNick), arrowStyle)
-		body.AddStyle(body.Len()-len(ev.User), textStyle)
+		body.SetServerStyle()
+		body.WriteString(ev.FormerNick)
+		body.SetStyle(vaxis.Style{})
+		body.WriteString("\u2192")
+		body.SetServerStyle()
+		body.WriteString(ev.User)
 		return ui.Line{
 			At:        ev.Time,
-			Head:      ui.ColorString("--", app.cfg.Colors.Status),
+			Head:      ui.ServerString("--"),
 			Body:      body.StyledString(),
 			Mergeable: true,
 			Data:      []irc.Event{ev},
 			Readable:  true,
 		}
+	case ignoredEvent:
+		return ui.Line{
+			At:        ev.Time,
+			Head:      ui.ServerString("--"),
+			Body:      app.ignoredBody(1),
+			Mergeable: true,
+			Data:      []irc.Event{ev},
+			Readable:  true,
+		}
 	case irc.UserJoinEvent:
 		var body ui.StyledStringBuilder
 		body.Grow(len(ev.User) + 1)
@@ -2194,13 +2656,11 @@ func (app *App) formatEvent(ev irc.Event) ui.Line {
 			Foreground: ui.ColorGreen,
 		})
 		body.WriteByte('+')
-		body.SetStyle(vaxis.Style{
-			Foreground: app.cfg.Colors.Status,
-		})
+		body.SetServerStyle()
 		body.WriteString(ev.User)
 		return ui.Line{
 			At:        ev.Time,
-			Head:      ui.ColorString("--", app.cfg.Colors.Status),
+			Head:      ui.ServerString("--"),
 			Body:      body.StyledString(),
 			Mergeable: true,
 			Data:      []irc.Event{ev},
@@ -2213,13 +2673,11 @@ func (app *App) formatEvent(ev irc.Event) ui.Line {
 			Foreground: ui.ColorRed,
 		})
 		body.WriteByte('-')
-		body.SetStyle(vaxis.Style{
-			Foreground: app.cfg.Colors.Status,
-		})
+		body.SetServerStyle()
 		body.WriteString(ev.User)
 		return ui.Line{
 			At:        ev.Time,
-			Head:      ui.ColorString("--", app.cfg.Colors.Status),
+			Head:      ui.ServerString("--"),
 			Body:      body.StyledString(),
 			Mergeable: true,
 			Data:      []irc.Event{ev},
@@ -2232,13 +2690,11 @@ func (app *App) formatEvent(ev irc.Event) ui.Line {
 			Foreground: ui.ColorRed,
 		})
 		body.WriteByte('-')
-		body.SetStyle(vaxis.Style{
-			Foreground: app.cfg.Colors.Status,
-		})
+		body.SetServerStyle()
 		body.WriteString(ev.User)
 		return ui.Line{
 			At:        ev.Time,
-			Head:      ui.ColorString("--", app.cfg.Colors.Status),
+			Head:      ui.ServerString("--"),
 			Body:      body.StyledString(),
 			Mergeable: true,
 			Data:      []irc.Event{ev},
@@ -2249,22 +2705,18 @@ func (app *App) formatEvent(ev irc.Event) ui.Line {
 		who := ui.IRCString(ev.Who).String()
 		body := fmt.Sprintf("Topic changed by %s to: %s", who, topic)
 		return ui.Line{
-			At:     ev.Time,
-			Head:   ui.ColorString("--", app.cfg.Colors.Status),
-			Notify: ui.NotifyUnread,
-			Body: ui.Styled(body, vaxis.Style{
-				Foreground: app.cfg.Colors.Status,
-			}),
+			At:       ev.Time,
+			Head:     ui.ServerString("--"),
+			Notify:   ui.NotifyUnread,
+			Body:     ui.ServerString(body),
 			Readable: true,
 		}
 	case irc.ModeChangeEvent:
 		body := fmt.Sprintf("[%s]", ev.Mode)
 		return ui.Line{
-			At:   ev.Time,
-			Head: ui.ColorString("--", app.cfg.Colors.Status),
-			Body: ui.Styled(body, vaxis.Style{
-				Foreground: app.cfg.Colors.Status,
-			}),
+			At:        ev.Time,
+			Head:      ui.ServerString("--"),
+			Body:      ui.ServerString(body),
 			Mergeable: true,
 			Data:      []irc.Event{ev},
 			Readable:  true,
@@ -2286,9 +2738,7 @@ func (app *App) formatEvent(ev irc.Event) ui.Line {
 				}
 			}
 			if ev.modeSet != "" || ev.modeUnset != "" {
-				body.SetStyle(vaxis.Style{
-					Foreground: app.cfg.Colors.Status,
-				})
+				body.SetServerStyle()
 				body.WriteByte('[')
 				if ev.modeSet != "" {
 					body.WriteByte('+')
@@ -2301,21 +2751,15 @@ func (app *App) formatEvent(ev irc.Event) ui.Line {
 				body.WriteByte(']')
 			}
 			if ev.oldNick != "" && ev.oldNick != ev.nick {
-				body.SetStyle(vaxis.Style{
-					Foreground: app.cfg.Colors.Status,
-				})
+				body.SetServerStyle()
 				body.WriteString(ev.oldNick)
 				body.SetStyle(vaxis.Style{})
 				body.WriteString("\u2192")
 			}
-			body.SetStyle(vaxis.Style{
-				Foreground: app.cfg.Colors.Status,
-			})
+			body.SetServerStyle()
 			body.WriteString(ev.nick)
 		} else if ev.nick == "" && ev.channelMode != "" {
-			body.SetStyle(vaxis.Style{
-				Foreground: app.cfg.Colors.Status,
-			})
+			body.SetServerStyle()
 			fmt.Fprintf(&body, "[%s]", ev.channelMode)
 		} else {
 			return ui.Line{}
@@ -2337,7 +2781,6 @@ func (app *App) formatEvent(ev irc.Event) ui.Line {
 func (app *App) formatMessage(s *irc.Session, ev irc.MessageEvent) (buffer string, line ui.Line) {
 	isFromSelf := s.IsMe(ev.User)
 	isToSelf := s.IsMe(ev.Target)
-	isHighlight := ev.TargetIsChannel && app.isHighlight(s, ev.Content)
 	isQuery := !ev.TargetIsChannel && ev.Command == "PRIVMSG"
 	isNotice := ev.Command == "NOTICE"
 
@@ -2370,6 +2813,17 @@ func (app *App) formatMessage(s *irc.Session, ev irc.MessageEvent) (buffer strin
 		buffer = ev.Target
 	}
 
+	text := ui.IRCString(content)
+	var hlRanges [][]int
+	if ev.TargetIsChannel && !isFromSelf {
+		hlRanges = app.highlightRanges(s, buffer, ev.User, text.String())
+		text = text.Emphasize(hlRanges, vaxis.Style{
+			Foreground: app.cfg.Colors.Highlight,
+			Attribute:  vaxis.AttrBold,
+		})
+	}
+	isHighlight := len(hlRanges) > 0
+
 	var notification ui.NotifyType
 	hlLine := ev.TargetIsChannel && isHighlight && !isFromSelf
 	if isFromSelf {
@@ -2379,6 +2833,15 @@ func (app *App) formatMessage(s *irc.Session, ev irc.MessageEvent) (buffer strin
 	} else {
 		notification = ui.NotifyUnread
 	}
+	switch app.notifyLevel(s, buffer) {
+	case notifyAll:
+		if notification == ui.NotifyUnread {
+			// Notify without counting it as a highlight.
+			notification = ui.NotifyMessage
+		}
+	case notifyNone:
+		notification = ui.NotifyNone
+	}
 
 	var head ui.StyledStringBuilder
 	if ev.TargetPrefix != "" {
@@ -2406,7 +2869,7 @@ func (app *App) formatMessage(s *irc.Session, ev irc.MessageEvent) (buffer strin
 		body.WriteString(ev.User)
 		body.SetStyle(vaxis.Style{})
 		body.WriteString(": ")
-		body.WriteStyledString(ui.IRCString(content))
+		body.WriteStyledString(text)
 	} else if isAction {
 		color := app.win.IdentColor(app.cfg.Colors.Nicks, ev.User, isFromSelf)
 		body.SetStyle(vaxis.Style{
@@ -2415,9 +2878,9 @@ func (app *App) formatMessage(s *irc.Session, ev irc.MessageEvent) (buffer strin
 		body.WriteString(ev.User)
 		body.SetStyle(vaxis.Style{})
 		body.WriteString(" ")
-		body.WriteStyledString(ui.IRCString(content))
+		body.WriteStyledString(text)
 	} else {
-		body.WriteStyledString(ui.IRCString(content))
+		body.WriteStyledString(text)
 	}
 
 	line = ui.Line{
@@ -2427,10 +2890,472 @@ func (app *App) formatMessage(s *irc.Session, ev irc.MessageEvent) (buffer strin
 		Body:      body.StyledString(),
 		Highlight: hlLine,
 		Readable:  true,
+		Data:      ev,
+		ID:        ev.ID,
+		ReplyTo:   ev.ReplyTo,
+	}
+	if ev.ReplyTo != "" {
+		if parent, ok := app.win.LineByID(s.NetID(), buffer, ev.ReplyTo); ok {
+			line.Reply = app.formatReply(s, &parent)
+		} else {
+			line.Reply = app.formatReply(s, nil)
+		}
 	}
 	return
 }
 
+// addReaction adds or removes a reaction to the line it refers to.
+func (app *App) addReaction(s *irc.Session, ev irc.ReactionEvent) {
+	if app.isIgnored(s, s.UserPrefix(ev.User)) {
+		return
+	}
+	buffer := ev.Target
+	if !ev.TargetIsChannel && s.IsMe(ev.Target) {
+		buffer = ev.User
+	}
+	// Identify users by their casemapped nick, so that a change of case of
+	// their nick does not count them twice.
+	app.win.AddReaction(s.NetID(), buffer, ev.ID, s.Casemap(ev.User), ev.Reaction, ev.Remove)
+}
+
+// redactLine replaces the line of a deleted message with a tombstone.
+func (app *App) redactLine(s *irc.Session, ev irc.RedactEvent) {
+	buffer := ev.Target
+	if !ev.TargetIsChannel && s.IsMe(ev.Target) {
+		buffer = ev.User
+	}
+	body := fmt.Sprintf("message deleted by %s", ev.User)
+	if ev.Reason != "" {
+		body += ": " + ev.Reason
+	}
+	app.win.RedactLine(s.NetID(), buffer, ev.ID, ui.Styled(body, vaxis.Style{
+		Foreground: app.cfg.Colors.Gray,
+		Attribute:  vaxis.AttrItalic,
+	}))
+}
+
+// addHistory adds the messages of a history batch to the buffer of its
+// target, skipping those already shown.
+func (app *App) addHistory(netID string, s *irc.Session, ev irc.HistoryEvent) {
+	var linesBefore []ui.Line
+	var linesAfter []ui.Line
+	bk := boundKey{netID, s.Casemap(ev.Target)}
+	bounds, hasBounds := app.messageBounds[bk]
+	boundsNew := bounds
+	var reactions []irc.ReactionEvent
+	var redactions []irc.RedactEvent
+	for _, m := range ev.Messages {
+		var line ui.Line
+		var ignored bool
+		switch ev := m.(type) {
+		case irc.MessageEvent:
+			_, line = app.formatMessage(s, ev)
+			ignored = app.isIgnored(s, messagePrefix(s, ev))
+		case irc.ReactionEvent:
+			reactions = append(reactions, ev)
+			continue
+		case irc.RedactEvent:
+			redactions = append(redactions, ev)
+			continue
+		default:
+			line = app.formatEvent(ev)
+		}
+		if line.IsZero() {
+			continue
+		}
+		boundsNew.Update(&line)
+		if _, ok := m.(irc.MessageEvent); !ok && !app.cfg.StatusEnabled {
+			continue
+		}
+		c := -1
+		if hasBounds {
+			c = bounds.Compare(&line)
+		}
+		if ignored {
+			if !app.cfg.IgnoreCollapse {
+				continue
+			}
+			line = app.formatEvent(ignoredEvent{Time: line.At})
+		}
+		if c < 0 {
+			linesBefore = append(linesBefore, line)
+		} else if c > 0 {
+			linesAfter = append(linesAfter, line)
+		}
+	}
+	app.win.AddLines(netID, ev.Target, linesBefore, linesAfter)
+	app.resolveReplies(s, netID, ev.Target, linesBefore)
+	app.resolveReplies(s, netID, ev.Target, linesAfter)
+	for _, r := range reactions {
+		app.addReaction(s, r)
+	}
+	for _, r := range redactions {
+		app.redactLine(s, r)
+	}
+
+	if !boundsNew.IsZero() {
+		app.messageBounds[bk] = boundsNew
+	}
+	if len(ev.Messages) < 10 {
+		// We're getting a non-full page: mark as complete to avoid indefinitely fetching the history.
+		// This should ideally be equal to the CHATHISTORY LIMIT, but it can be non advertised, or
+		// a full page could sometimes be less than a limit (because it could be filtered).
+		// It is also not zero, because bounds are inclusive, and not one, because we truncate based on
+		// the second of the message (because some bouncers have a second-level resolution).
+		// Be safe and pick 10 messages: less messages means that this was not a full page and we are done
+		// with fetching the backlog.
+		b := app.messageBounds[bk]
+		b.complete = true
+		app.messageBounds[bk] = b
+	}
+}
+
+// addWindowHistory adds the messages of a history batch to the window of
+// history of its target.
+func (app *App) addWindowHistory(netID string, s *irc.Session, w *historyWindow, ev irc.HistoryEvent) {
+	bk := boundKey{netID, s.Casemap(ev.Target)}
+	pending := w.pending
+	w.pending = ""
+	if w.canceled {
+		delete(app.windows, bk)
+		return
+	}
+	var lines []ui.Line
+	var reactions []irc.ReactionEvent
+	var redactions []irc.RedactEvent
+	for _, m := range ev.Messages {
+		var line ui.Line
+		var ignored bool
+		switch ev := m.(type) {
+		case irc.MessageEvent:
+			_, line = app.formatMessage(s, ev)
+			ignored = app.isIgnored(s, messagePrefix(s, ev))
+		case irc.ReactionEvent:
+			reactions = append(reactions, ev)
+			continue
+		case irc.RedactEvent:
+			redactions = append(redactions, ev)
+			continue
+		default:
+			line = app.formatEvent(ev)
+		}
+		if line.IsZero() {
+			continue
+		}
+		if w.first.IsZero() || line.At.Before(w.first) {
+			w.first = line.At
+		}
+		if line.At.After(w.last) {
+			w.last = line.At
+		}
+		if _, ok := m.(irc.MessageEvent); !ok && !app.cfg.StatusEnabled {
+			continue
+		}
+		if ignored {
+			if !app.cfg.IgnoreCollapse {
+				continue
+			}
+			line = app.formatEvent(ignoredEvent{Time: line.At})
+		}
+		lines = append(lines, line)
+	}
+
+	switch pending {
+	case "around":
+		if w.first.IsZero() {
+			delete(app.windows, bk)
+			app.win.AddLine(netID, ev.Target, ui.Line{
+				At:   time.Now(),
+				Head: ui.ColorString("!!", ui.ColorRed),
+				Body: ui.PlainString("GOTO: no messages found"),
+			})
+			return
+		}
+		w.loaded = true
+		app.win.Detach(netID, ev.Target, lines)
+		if w.id == "" || !app.win.SelectID(netID, ev.Target, w.id) {
+			app.win.ScrollToTime(netID, ev.Target, w.at)
+		}
+	case "before":
+		app.win.AddDetachedLines(netID, ev.Target, lines, nil)
+		if len(ev.Messages) < 10 {
+			// See addHistory.
+			w.complete = true
+		}
+	case "after":
+		live := app.messageBounds[bk]
+		if len(ev.Messages) < 10 || !live.IsZero() && !w.last.Before(live.first) {
+			// The window caught up with the live lines.
+			delete(app.windows, bk)
+			app.win.Attach(netID, ev.Target)
+			return
+		}
+		app.win.AddDetachedLines(netID, ev.Target, nil, lines)
+	}
+	app.resolveReplies(s, netID, ev.Target, lines)
+	for _, r := range reactions {
+		app.addReaction(s, r)
+	}
+	for _, r := range redactions {
+		app.redactLine(s, r)
+	}
+}
+
+// showSearchResults opens an overlay with the given messages.
+func (app *App) showSearchResults(s *irc.Session, messages []irc.MessageEvent) {
+	app.win.OpenOverlay("Press Escape to close the search results")
+	lines := make([]ui.Line, 0, len(messages))
+	for _, m := range messages {
+		_, line := app.formatMessage(s, m)
+		if line.IsZero() {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	app.win.AddLines("", ui.Overlay, lines, nil)
+	app.resolveReplies(s, "", ui.Overlay, lines)
+}
+
+// networkKey returns a name identifying the network netID across restarts, for
+// on-disk data such as the message log and the ignore lists.
+func (app *App) networkKey(netID string) string {
+	if _, ok := app.servers[netID]; ok {
+		// Server blocks may connect to the same host, as other
+		// accounts: keep them apart by their block name.
+		return netID
+	}
+	host, _, err := net.SplitHostPort(app.cfg.Addr)
+	if err != nil {
+		host = app.cfg.Addr
+	}
+	if netID == "" {
+		return host
+	}
+	// A bouncer network.
+	return host + "~" + netID
+}
+
+// networkName returns the name of the network netID, as shown to the user.
+func (app *App) networkName(netID string) string {
+	app.networkLock.RLock()
+	name := app.networks[netID]["name"]
+	app.networkLock.RUnlock()
+	if name != "" {
+		return name
+	}
+	host, _, err := net.SplitHostPort(app.serverConfig(netID).Addr)
+	if err != nil {
+		return app.serverConfig(netID).Addr
+	}
+	return host
+}
+
+// notifyLevel returns the notification level of buffer, or an empty string if
+// it is unset.
+func (app *App) notifyLevel(s *irc.Session, buffer string) string {
+	if level := s.NotifyGet(buffer); level != "" {
+		return level
+	}
+	return app.notifications.Get(app.networkKey(s.NetID()), s.Casemap(buffer))
+}
+
+// setNotifyLevel sets the notification level of buffer, on the server if it
+// supports it, or locally otherwise.
+func (app *App) setNotifyLevel(s *irc.Session, buffer, level string) error {
+	network := app.networkKey(s.NetID())
+	if s.NotifySet(buffer, level) {
+		level = ""
+	}
+	return app.notifications.Set(network, s.Casemap(buffer), level)
+}
+
+// isIgnored reports whether the user p matches an ignore mask of the network
+// of s.
+func (app *App) isIgnored(s *irc.Session, p irc.Prefix) bool {
+	if p.Name == "" || s.IsMe(p.Name) {
+		return false
+	}
+	return app.ignores.Match(app.networkKey(s.NetID()), p, s.Casemap)
+}
+
+// messagePrefix returns the sender of ev, with its user and host as sent with
+// the message, or as far as they are known otherwise.
+func messagePrefix(s *irc.Session, ev irc.MessageEvent) irc.Prefix {
+	if ev.Prefix.User != "" || ev.Prefix.Host != "" {
+		return ev.Prefix
+	}
+	return s.UserPrefix(ev.User)
+}
+
+// ignoredBody returns the text of a line collapsing n ignored messages.
+func (app *App) ignoredBody(n int) ui.StyledString {
+	text := "1 ignored message"
+	if n > 1 {
+		text = fmt.Sprintf("%d ignored messages", n)
+	}
+	return ui.Styled(text, vaxis.Style{
+		Foreground: app.cfg.Colors.Gray,
+	})
+}
+
+// logTarget returns the target of the conversation of ev in the message log.
+func logTarget(s *irc.Session, ev irc.MessageEvent) string {
+	target := ev.Target
+	if s.IsMe(target) {
+		target = ev.User
+	}
+	return s.Casemap(target)
+}
+
+// logMessage writes ev to the message log, if enabled.
+func (app *App) logMessage(s *irc.Session, netID string, ev irc.MessageEvent) {
+	if app.logs == nil {
+		return
+	}
+	target := logTarget(s, ev)
+	if target == "" || target == "*" {
+		return
+	}
+	if err := app.logs.Append(app.networkKey(netID), target, ev); err != nil && !app.shownLogError {
+		app.shownLogError = true
+		app.addStatusLine(netID, ui.Line{
+			At:   time.Now(),
+			Head: ui.ColorString("!!", ui.ColorRed),
+			Body: ui.PlainSprintf("Failed to write the message log: %v", err),
+		})
+	}
+}
+
+// fromLog restores the fields of a message read from the message log of
+// target.
+func fromLog(s *irc.Session, ev irc.MessageEvent) irc.MessageEvent {
+	ev.TargetIsChannel = s.IsChannel(ev.Target)
+	if !ev.TargetIsChannel && s.Casemap(ev.User) == ev.Target {
+		// A message sent to us in a query.
+		ev.Target = s.Nick()
+	}
+	return ev
+}
+
+// requestLogHistory reads the messages of buffer before the given time from
+// the message log in the background, as a replacement for CHATHISTORY.
+func (app *App) requestLogHistory(netID string, s *irc.Session, buffer string, before time.Time) {
+	bk := boundKey{netID, s.Casemap(buffer)}
+	if _, ok := app.logRequests[bk]; ok {
+		return
+	}
+	app.logRequests[bk] = struct{}{}
+	network := app.networkKey(netID)
+	go func() {
+		evs, err := app.logs.Before(network, bk.target, before, 200)
+		app.postEvent(event{
+			src: "*",
+			content: &logHistoryLoaded{
+				key:    bk,
+				netID:  netID,
+				buffer: buffer,
+				before: before,
+				evs:    evs,
+				err:    err,
+			},
+		})
+	}()
+}
+
+// handleLogHistoryLoaded adds the messages read by requestLogHistory.
+func (app *App) handleLogHistoryLoaded(ev *logHistoryLoaded) {
+	delete(app.logRequests, ev.key)
+	s := app.sessions[ev.netID]
+	if s == nil {
+		return
+	}
+	evs := ev.evs
+	if ev.err != nil {
+		app.addStatusLine(ev.netID, ui.Line{
+			At:   time.Now(),
+			Head: ui.ColorString("!!", ui.ColorRed),
+			Body: ui.PlainSprintf("Failed to read the message log: %v", ev.err),
+		})
+		evs = nil
+	}
+	// Skip the messages of the first second already shown, as done with
+	// bound.firstMessage for CHATHISTORY.
+	shown := make(map[string]struct{})
+	if bound, ok := app.messageBounds[ev.key]; ok {
+		lines, _ := app.win.Lines(ev.netID, ev.buffer, math.MaxInt)
+		for _, line := range lines {
+			if line.At.Truncate(time.Second).Equal(bound.first) {
+				shown[line.Body.String()] = struct{}{}
+			}
+		}
+	}
+	messages := make([]irc.Event, 0, len(evs))
+	for _, m := range evs {
+		m = fromLog(s, m)
+		if m.Time.Equal(ev.before) {
+			_, line := app.formatMessage(s, m)
+			if _, ok := shown[line.Body.String()]; ok {
+				continue
+			}
+		}
+		messages = append(messages, m)
+	}
+	app.addHistory(ev.netID, s, irc.HistoryEvent{
+		Target:   ev.buffer,
+		Messages: messages,
+	})
+}
+
+// formatReply returns the header shown above a reply to parent, an excerpt of
+// the parent message. parent is nil if it is not known.
+func (app *App) formatReply(s *irc.Session, parent *ui.Line) ui.StyledString {
+	var sb ui.StyledStringBuilder
+	sb.SetStyle(vaxis.Style{
+		Foreground: app.cfg.Colors.Gray,
+	})
+	sb.WriteString("  \u21aa ")
+	var ev irc.MessageEvent
+	ok := false
+	if parent != nil {
+		if parent.Redacted {
+			sb.WriteString("(message deleted)")
+			return sb.StyledString()
+		}
+		ev, ok = parent.Data.(irc.MessageEvent)
+	}
+	if !ok {
+		sb.WriteString("(message not loaded)")
+		return sb.StyledString()
+	}
+	if ev.Command == "PRIVMSG" && !strings.HasPrefix(ev.Content, "\x01") {
+		// Notices and actions already start with the nick.
+		sb.SetStyle(vaxis.Style{
+			Foreground: app.win.IdentColor(app.cfg.Colors.Nicks, ev.User, s.IsMe(ev.User)),
+		})
+		sb.WriteString(ev.User)
+		sb.SetStyle(vaxis.Style{
+			Foreground: app.cfg.Colors.Gray,
+		})
+		sb.WriteString(": ")
+	}
+	// The header is a single row.
+	sb.WriteString(strings.ReplaceAll(parent.Body.String(), "\n", " "))
+	return sb.StyledString()
+}
+
+// resolveReplies sets the reply headers of the lines of a buffer replying to
+// lines just added to it, such as messages of a history batch, whether the
+// replies were already shown or are part of the same lines.
+func (app *App) resolveReplies(s *irc.Session, netID, buffer string, lines []ui.Line) {
+	replies := make(map[string]ui.StyledString)
+	for i := range lines {
+		if lines[i].ID != "" {
+			replies[lines[i].ID] = app.formatReply(s, &lines[i])
+		}
+	}
+	app.win.SetReplies(netID, buffer, replies)
+}
+
 func (app *App) mergeLine(former *ui.Line, addition ui.Line) {
 	events := append(former.Data.([]irc.Event), addition.Data.([]irc.Event)...)
 	flows := make([]*mergedEvent, 0, len(events))
@@ -2444,8 +3369,11 @@ func (app *App) mergeLine(former *ui.Line, addition ui.Line) {
 		return nil
 	}
 
+	ignored := 0
 	for _, ev := range events {
 		switch ev := ev.(type) {
+		case ignoredEvent:
+			ignored++
 		case irc.UserNickEvent:
 			if f := flowNick(ev.User); f != nil {
 				// Drop any existing flow on the target user, effectively replacing it
@@ -2581,6 +3509,12 @@ func (app *App) mergeLine(former *ui.Line, addition ui.Line) {
 		}
 		newBody.WriteStyledString(l.Body)
 	}
+	if ignored > 0 {
+		if !first {
+			newBody.WriteString("  ")
+		}
+		newBody.WriteStyledString(app.ignoredBody(ignored))
+	}
 	former.Body = newBody.StyledString()
 	former.Data = events
 }
@@ -2599,6 +3533,11 @@ func (app *App) updatePrompt() {
 		prompt = ui.Styled("<offline>", vaxis.Style{
 			Foreground: ui.ColorRed,
 		})
+	} else if line, ok := app.win.Selection(); ok && line.ID != "" && s.CanReply() {
+		var sb ui.StyledStringBuilder
+		sb.WriteString("\u21aa ")
+		sb.WriteStyledString(app.win.IdentString(app.cfg.Colors.Nicks, s.Nick(), true))
+		prompt = sb.StyledString()
 	} else {
 		prompt = app.win.IdentString(app.cfg.Colors.Nicks, s.Nick(), true)
 	}
@@ -2620,10 +3559,8 @@ func (app *App) printTopic(netID, buffer string) (ok bool) {
 	}
 	app.win.AddLine(netID, buffer, ui.Line{
 		At:   time.Now(),
-		Head: ui.ColorString("--", app.cfg.Colors.Status),
-		Body: ui.Styled(body, vaxis.Style{
-			Foreground: app.cfg.Colors.Status,
-		}),
+		Head: ui.ServerString("--"),
+		Body: ui.ServerString(body),
 	})
 	return true
 }
@@ -2654,16 +3591,6 @@ func (app *App) addUserBuffer(netID, buffer string, t time.Time) (i int, added b
 	return
 }
 
-func (app *App) OpenLink(in *varlinkservice.OpenLinkIn) (*varlinkservice.OpenLinkOut, error) {
-	app.postEvent(event{
-		src: "*",
-		content: &events.EventOpenLink{
-			Link: in.Link,
-		},
-	})
-	return nil, nil
-}
-
 func keyNameMatch(name string) *keyMatch {
 	parts := strings.Split(name, "+")
 	mods := parts[:len(parts)-1]
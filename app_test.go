@@ -0,0 +1,42 @@
+package senpai
+
+import (
+	"testing"
+
+	"git.sr.ht/~delthas/senpai/irc"
+	"git.sr.ht/~delthas/senpai/ui"
+)
+
+func TestAddReaction(t *testing.T) {
+	app := &App{
+		win:     ui.NewHeadless(ui.Config{}),
+		ignores: newIgnoreStore(""),
+	}
+	s := irc.NewSession(make(chan irc.Message, 64), irc.SessionParams{
+		Nickname: "me",
+	})
+	app.win.AddBuffer(s.NetID(), "", "#chan")
+	app.win.AddLine(s.NetID(), "#chan", ui.Line{Body: ui.PlainString("hello"), ID: "1"})
+
+	react := func(user string, remove bool) {
+		app.addReaction(s, irc.ReactionEvent{
+			User:            user,
+			Target:          "#chan",
+			TargetIsChannel: true,
+			ID:              "1",
+			Reaction:        "👍",
+			Remove:          remove,
+		})
+	}
+	react("Alice", false)
+	react("alice", false)
+	l, _ := app.win.LineByID(s.NetID(), "#chan", "1")
+	if len(l.Reactions) != 1 || len(l.Reactions[0].Users) != 1 {
+		t.Fatalf("expected a single reacting user, got %v", l.Reactions)
+	}
+	react("ALICE", true)
+	l, _ = app.win.LineByID(s.NetID(), "#chan", "1")
+	if len(l.Reactions) != 0 {
+		t.Errorf("expected the reaction to be removed, got %v", l.Reactions)
+	}
+}
@@ -119,7 +119,6 @@ func main() {
 		fmt.Fprintf(os.Stderr, "The configuration file at %q was not found.\n", configPath)
 		fmt.Fprintf(os.Stderr, "Configuration assistant: senpai will create a configuration file for you.\n\n")
 		fmt.Fprintf(os.Stderr, "Important senpai information:\n")
-		fmt.Fprintf(os.Stderr, "* senpai is able to connect to at most 1 server at a time.\n")
 		fmt.Fprintf(os.Stderr, "* In order to connect to multiple networks, keep message history, search through your messages, and upload files, use an \x1B[1mIRC bouncer\x1B[0m and point senpai to the bouncer.\n")
 		fmt.Fprintf(os.Stderr, "* Most senpai users use senpai with the IRC bouncer software \x1B[1msoju\x1B[0m.\n")
 		fmt.Fprintf(os.Stderr, "** You can self-host \x1B[1msoju\x1B[0m yourself (it is free and open-source): https://soju.im/\n")
@@ -213,6 +212,9 @@ func main() {
 		lastNetID, lastBuffer := getLastBuffer(cfgHash)
 		app.SwitchToBuffer(lastNetID, lastBuffer)
 		app.SetLastClose(getLastStamp(cfgHash))
+		if err := app.LoadInputHistory(inputHistoryPath(cfgHash)); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read input history: %s\n", err)
+		}
 	}
 
 	sigCh := make(chan os.Signal, 1)
@@ -236,6 +238,7 @@ func main() {
 	if !cfg.Transient {
 		writeLastBuffer(app, cfgHash)
 		writeLastStamp(app, cfgHash)
+		writeInputHistory(app, cfgHash)
 	}
 }
 
@@ -374,6 +377,21 @@ func writeLastStamp(app *senpai.App, hash string) {
 	}
 }
 
+func inputHistoryPath(hash string) string {
+	name := "inputhistory.json"
+	if hash != "" {
+		name = "inputhistory-" + hash + ".json"
+	}
+	return path.Join(cachePath(), name)
+}
+
+func writeInputHistory(app *senpai.App, hash string) {
+	p := inputHistoryPath(hash)
+	if err := app.SaveInputHistory(p); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write input history at %q: %s\n", p, err)
+	}
+}
+
 func sendOpenLink(socketDir string, link string) (ok bool, err error) {
 	es, err := os.ReadDir(socketDir)
 	if os.IsNotExist(err) {
@@ -400,7 +418,7 @@ func sendOpenLink(socketDir string, link string) (ok bool, err error) {
 	return false, nil
 }
 
-func listenVarlink(socketPath string, backend varlinkservice.Backend) error {
+func listenVarlink(socketPath string, app *senpai.App) error {
 	if err := os.MkdirAll(filepath.Dir(socketPath), 0700); err != nil {
 		return err
 	}
@@ -411,12 +429,14 @@ func listenVarlink(socketPath string, backend varlinkservice.Backend) error {
 	if err != nil {
 		return err
 	}
-	server := varlink.NewServer()
-	server.Handler = varlinkservice.Handler{
-		Backend: backend,
+	handler := varlinkservice.StreamHandler{
+		Handler: varlinkservice.Handler{
+			Backend: app,
+		},
+		Subscriber: app,
 	}
 	go func() {
-		if err := server.Serve(l); err != nil {
+		if err := varlinkservice.Serve(l, handler); err != nil {
 			fmt.Fprintf(os.Stderr, "failed to serve varlink server: %v\n", err)
 		}
 	}()
@@ -167,9 +167,22 @@ func init() {
 			MinArgs:   1,
 			MaxArgs:   1,
 			Usage:     "<message>",
-			Desc:      "reply to the last query",
+			Desc:      "reply to the selected message, or to the last query",
 			Handle:    commandDoR,
 		},
+		"REACT": {
+			MinArgs: 1,
+			MaxArgs: 1,
+			Usage:   "<emoji>",
+			Desc:    "react to the selected message, or to the last message",
+			Handle:  commandDoReact,
+		},
+		"DELETE": {
+			MaxArgs: 1,
+			Usage:   "[reason]",
+			Desc:    "delete the selected message, or your last message",
+			Handle:  commandDoDelete,
+		},
 		"TOPIC": {
 			MaxArgs: 1,
 			Usage:   "[topic]",
@@ -184,6 +197,27 @@ func init() {
 			Desc:   "unmute the current channel",
 			Handle: commandDoUnmute,
 		},
+		"IGNORE": {
+			AllowHome: true,
+			MaxArgs:   1,
+			Usage:     "[nick|mask]",
+			Desc:      "hide messages from a user, or list ignored users",
+			Handle:    commandDoIgnore,
+		},
+		"UNIGNORE": {
+			AllowHome: true,
+			MinArgs:   1,
+			MaxArgs:   1,
+			Usage:     "<nick|mask>",
+			Desc:      "show messages from an ignored user again",
+			Handle:    commandDoUnignore,
+		},
+		"NOTIFY": {
+			MaxArgs: 1,
+			Usage:   "[all|highlights|none|default]",
+			Desc:    "show or set when to be notified of messages in the current buffer",
+			Handle:  commandDoNotify,
+		},
 		"PIN": {
 			Desc:   "pin the current channel (moving it to the top of the channel list)",
 			Handle: commandDoPin,
@@ -275,6 +309,19 @@ func init() {
 			Desc:    "search messages in a target",
 			Handle:  commandDoSearch,
 		},
+		"UNFURL": {
+			MaxArgs: 1,
+			Usage:   "[on|off]",
+			Desc:    "show or set whether summaries of links are shown below messages in the current buffer",
+			Handle:  commandDoUnfurl,
+		},
+		"GOTO": {
+			MinArgs: 1,
+			MaxArgs: 1,
+			Usage:   "<date|time|msgid=id|unread|present>",
+			Desc:    "jump to a date, time or message in the history of the current buffer",
+			Handle:  commandDoGoto,
+		},
 		"AWAY": {
 			AllowHome: true,
 			MinArgs:   0,
@@ -362,16 +409,25 @@ func noCommand(app *App, content string) error {
 		return errOffline
 	}
 
-	s.PrivMsg(buffer, content)
+	var replyTo string
+	if line, ok := app.win.Selection(); ok && s.CanReply() {
+		replyTo = line.ID
+		app.win.ClearSelection()
+	}
+
+	s.PrivMsgReply(buffer, content, replyTo)
 	if !s.HasCapability("echo-message") {
-		buffer, line := app.formatMessage(s, irc.MessageEvent{
+		ev := irc.MessageEvent{
 			User:            s.Nick(),
 			Target:          buffer,
 			TargetIsChannel: s.IsChannel(buffer),
 			Command:         "PRIVMSG",
 			Content:         content,
 			Time:            time.Now(),
-		})
+			ReplyTo:         replyTo,
+		}
+		app.logMessage(s, netID, ev)
+		buffer, line := app.formatMessage(s, ev)
 		app.win.AddLine(netID, buffer, line)
 	}
 
@@ -421,7 +477,7 @@ func commandDoHelp(app *App, args []string) (err error) {
 		sort.Strings(names)
 		var sb ui.StyledStringBuilder
 		for _, name := range names {
-			addLineCommand(&sb, name, commands[name])
+			addLineCommand(&sb, name, app.commands[name])
 		}
 	}
 
@@ -432,8 +488,8 @@ func commandDoHelp(app *App, args []string) (err error) {
 			Body: ui.PlainString("Available commands:"),
 		})
 
-		cmdNames := make([]string, 0, len(commands))
-		for cmdName := range commands {
+		cmdNames := make([]string, 0, len(app.commands))
+		for cmdName := range app.commands {
 			cmdNames = append(cmdNames, cmdName)
 		}
 		addLineCommands(cmdNames)
@@ -445,8 +501,8 @@ func commandDoHelp(app *App, args []string) (err error) {
 			Body: ui.PlainSprintf("Commands that match \"%s\":", search),
 		})
 
-		cmdNames := make([]string, 0, len(commands))
-		for cmdName := range commands {
+		cmdNames := make([]string, 0, len(app.commands))
+		for cmdName := range app.commands {
 			if !strings.Contains(cmdName, search) {
 				continue
 			}
@@ -491,14 +547,16 @@ func commandDoMe(app *App, args []string) (err error) {
 	content := fmt.Sprintf("\x01ACTION %s\x01", args[0])
 	s.PrivMsg(buffer, content)
 	if !s.HasCapability("echo-message") {
-		buffer, line := app.formatMessage(s, irc.MessageEvent{
+		ev := irc.MessageEvent{
 			User:            s.Nick(),
 			Target:          buffer,
 			TargetIsChannel: s.IsChannel(buffer),
 			Command:         "PRIVMSG",
 			Content:         content,
 			Time:            time.Now(),
-		})
+		}
+		app.logMessage(s, netID, ev)
+		buffer, line := app.formatMessage(s, ev)
 		app.win.AddLine(netID, buffer, line)
 	}
 	return nil
@@ -533,7 +591,7 @@ func commandDoUpload(app *App, args []string) (err error) {
 		if err != nil {
 			return err
 		}
-		app.handleUpload(upload, rc, -1, "", mimetype, rc)
+		app.handleUpload(s.NetID(), upload, rc, -1, "", mimetype, rc)
 		return nil
 	}
 
@@ -551,7 +609,7 @@ func commandDoUpload(app *App, args []string) (err error) {
 		return fmt.Errorf("opening file: %v", err)
 	}
 
-	app.handleUpload(upload, f, fi.Size(), filepath.Base(path), "", f)
+	app.handleUpload(s.NetID(), upload, f, fi.Size(), filepath.Base(path), "", f)
 	return nil
 }
 
@@ -578,9 +636,7 @@ func commandDoNames(app *App, args []string) (err error) {
 		return fmt.Errorf("this is not a channel")
 	}
 	var sb ui.StyledStringBuilder
-	sb.SetStyle(vaxis.Style{
-		Foreground: app.cfg.Colors.Status,
-	})
+	sb.SetServerStyle()
 	sb.WriteString("Names: ")
 	for _, name := range s.Names(buffer) {
 		if name.PowerLevel != "" {
@@ -588,9 +644,7 @@ func commandDoNames(app *App, args []string) (err error) {
 				Foreground: ui.ColorGreen,
 			})
 			sb.WriteString(name.PowerLevel)
-			sb.SetStyle(vaxis.Style{
-				Foreground: app.cfg.Colors.Status,
-			})
+			sb.SetServerStyle()
 		}
 		sb.WriteString(name.Name.Name)
 		sb.WriteByte(' ')
@@ -599,7 +653,7 @@ func commandDoNames(app *App, args []string) (err error) {
 	// TODO remove last space
 	app.win.AddLine(netID, buffer, ui.Line{
 		At:   time.Now(),
-		Head: ui.ColorString("--", app.cfg.Colors.Status),
+		Head: ui.ServerString("--"),
 		Body: body,
 	})
 	return nil
@@ -743,25 +797,103 @@ func commandDoList(app *App, args []string) (err error) {
 }
 
 func commandDoR(app *App, args []string) (err error) {
+	if _, ok := app.win.Selection(); ok {
+		return noCommand(app, args[0])
+	}
 	s := app.sessions[app.lastQueryNet]
 	if s == nil {
 		return errOffline
 	}
 	s.PrivMsg(app.lastQuery, args[0])
 	if !s.HasCapability("echo-message") {
-		buffer, line := app.formatMessage(s, irc.MessageEvent{
+		ev := irc.MessageEvent{
 			User:            s.Nick(),
 			Target:          app.lastQuery,
 			TargetIsChannel: s.IsChannel(app.lastQuery),
 			Command:         "PRIVMSG",
 			Content:         args[0],
 			Time:            time.Now(),
-		})
+		}
+		app.logMessage(s, app.lastQueryNet, ev)
+		buffer, line := app.formatMessage(s, ev)
 		app.win.AddLine(app.lastQueryNet, buffer, line)
 	}
 	return nil
 }
 
+func commandDoReact(app *App, args []string) (err error) {
+	netID, buffer := app.win.CurrentBuffer()
+	s := app.sessions[netID]
+	if s == nil {
+		return errOffline
+	}
+	if !s.CanReact() {
+		return errNotSupported
+	}
+
+	reaction := args[0]
+	if alias := strings.ToLower(strings.Trim(reaction, ":")); alias != "" {
+		if emojis := findEmoji(alias); len(emojis) > 0 && emojis[0].Alias == alias {
+			reaction = emojis[0].Emoji
+		}
+	}
+
+	line, ok := app.win.Selection()
+	if !ok {
+		line, ok = app.win.FindLine(netID, buffer, func(line *ui.Line) bool {
+			return line.ID != "" && !line.Redacted
+		})
+	}
+	if !ok || line.ID == "" || line.Redacted {
+		return fmt.Errorf("no message to react to")
+	}
+	app.win.ClearSelection()
+	s.React(buffer, line.ID, reaction)
+	if !s.HasCapability("echo-message") {
+		app.win.AddReaction(netID, buffer, line.ID, s.Nick(), reaction, false)
+	}
+	return nil
+}
+
+func commandDoDelete(app *App, args []string) (err error) {
+	netID, buffer := app.win.CurrentBuffer()
+	s := app.sessions[netID]
+	if s == nil {
+		return errOffline
+	}
+	if !s.CanRedact() {
+		return errNotSupported
+	}
+	var reason string
+	if len(args) > 0 {
+		reason = args[0]
+	}
+
+	line, ok := app.win.Selection()
+	if !ok {
+		line, ok = app.win.FindLine(netID, buffer, func(line *ui.Line) bool {
+			ev, ok := line.Data.(irc.MessageEvent)
+			return ok && line.ID != "" && !line.Redacted && s.IsMe(ev.User)
+		})
+	}
+	if !ok || line.ID == "" || line.Redacted {
+		return fmt.Errorf("no message to delete")
+	}
+	app.win.ClearSelection()
+	s.Redact(buffer, line.ID, reason)
+	if !s.HasCapability("echo-message") {
+		app.redactLine(s, irc.RedactEvent{
+			User:            s.Nick(),
+			Target:          buffer,
+			TargetIsChannel: s.IsChannel(buffer),
+			ID:              line.ID,
+			Reason:          reason,
+			Time:            time.Now(),
+		})
+	}
+	return nil
+}
+
 func commandDoTopic(app *App, args []string) (err error) {
 	netID, buffer := app.win.CurrentBuffer()
 	var ok bool
@@ -804,6 +936,85 @@ func commandDoUnmute(app *App, args []string) (err error) {
 	return nil
 }
 
+func commandDoIgnore(app *App, args []string) (err error) {
+	netID, buffer := app.win.CurrentBuffer()
+	network := app.networkKey(netID)
+	var body string
+	if len(args) == 0 {
+		masks := app.ignores.Masks(network)
+		if len(masks) == 0 {
+			body = "No ignored users"
+		} else {
+			body = "Ignored users: " + strings.Join(masks, " ")
+		}
+	} else {
+		mask := normalizeMask(args[0])
+		added, err := app.ignores.Add(network, mask)
+		if err != nil {
+			return fmt.Errorf("failed to save ignore list: %v", err)
+		}
+		if !added {
+			return fmt.Errorf("%s is already ignored", mask)
+		}
+		body = fmt.Sprintf("Ignoring %s", mask)
+	}
+	app.win.AddLine(netID, buffer, ui.Line{
+		At:   time.Now(),
+		Head: ui.ServerString("--"),
+		Body: ui.ServerString(body),
+	})
+	return nil
+}
+
+func commandDoUnignore(app *App, args []string) (err error) {
+	netID, buffer := app.win.CurrentBuffer()
+	mask := normalizeMask(args[0])
+	removed, err := app.ignores.Remove(app.networkKey(netID), mask)
+	if err != nil {
+		return fmt.Errorf("failed to save ignore list: %v", err)
+	}
+	if !removed {
+		return fmt.Errorf("%s is not ignored", mask)
+	}
+	app.win.AddLine(netID, buffer, ui.Line{
+		At:   time.Now(),
+		Head: ui.ServerString("--"),
+		Body: ui.ServerString(fmt.Sprintf("No longer ignoring %s", mask)),
+	})
+	return nil
+}
+
+func commandDoNotify(app *App, args []string) (err error) {
+	netID, buffer := app.win.CurrentBuffer()
+	s := app.sessions[netID]
+	if s == nil {
+		return errOffline
+	}
+	var level string
+	if len(args) == 0 {
+		level = app.notifyLevel(s, buffer)
+	} else {
+		level = strings.ToLower(args[0])
+		if level == "default" {
+			level = ""
+		} else if !isNotifyLevel(level) {
+			return fmt.Errorf("unknown notification level %q", args[0])
+		}
+		if err := app.setNotifyLevel(s, buffer, level); err != nil {
+			return fmt.Errorf("failed to save notification level: %v", err)
+		}
+	}
+	if level == "" {
+		level = notifyHighlights + " (default)"
+	}
+	app.win.AddLine(netID, buffer, ui.Line{
+		At:   time.Now(),
+		Head: ui.ServerString("--"),
+		Body: ui.ServerString(fmt.Sprintf("Notifications: %s", level)),
+	})
+	return nil
+}
+
 func commandDoPin(app *App, args []string) (err error) {
 	netID, buffer := app.win.CurrentBuffer()
 	s := app.sessions[netID]
@@ -942,6 +1153,110 @@ func commandDoUnban(app *App, args []string) (err error) {
 	return nil
 }
 
+func commandDoUnfurl(app *App, args []string) (err error) {
+	netID, buffer := app.win.CurrentBuffer()
+	s := app.sessions[netID]
+	if s == nil {
+		return errOffline
+	}
+	if len(args) > 0 {
+		state := strings.ToLower(args[0])
+		if state != "on" && state != "off" {
+			return fmt.Errorf("invalid argument %q, expected on or off", args[0])
+		}
+		if err := app.unfurls.Set(app.networkKey(netID), s.Casemap(buffer), state); err != nil {
+			return fmt.Errorf("saving the setting: %v", err)
+		}
+	}
+	state := "off"
+	if app.unfurlEnabled(netID, buffer) {
+		state = "on"
+	}
+	app.win.AddLine(netID, buffer, ui.Line{
+		At:   time.Now(),
+		Head: ui.ServerString("--"),
+		Body: ui.ServerString(fmt.Sprintf("Link summaries: %s", state)),
+	})
+	return nil
+}
+
+func commandDoGoto(app *App, args []string) (err error) {
+	netID, buffer := app.win.CurrentBuffer()
+	arg := strings.TrimSpace(args[0])
+	switch strings.ToLower(arg) {
+	case "present", "now":
+		app.returnToPresent()
+		return nil
+	}
+	s := app.sessions[netID]
+	if s == nil {
+		return errOffline
+	}
+	if !s.HasCapability("draft/chathistory") {
+		return errNotSupported
+	}
+	if s.HistoryPending(buffer) {
+		return errors.New("history is already being fetched, try again later")
+	}
+
+	bk := boundKey{netID, s.Casemap(buffer)}
+	w := &historyWindow{
+		pending: "around",
+	}
+	r := s.NewHistoryRequest(buffer).WithLimit(100)
+	if strings.EqualFold(arg, "unread") {
+		read := app.win.ReadTime(netID, buffer)
+		if read.IsZero() {
+			return errors.New("no read marker in this buffer")
+		}
+		w.at = read
+		app.windows[bk] = w
+		r.Between(read, time.Now())
+		return nil
+	}
+	if day, err := time.ParseInLocation("2006-01-02", arg, time.Local); err == nil {
+		w.at = day
+		app.windows[bk] = w
+		r.Between(day, day.AddDate(0, 0, 1))
+		return nil
+	}
+	if t, ok := parseGotoTime(arg); ok {
+		w.at = t
+		app.windows[bk] = w
+		r.Around(t)
+		return nil
+	}
+	id, ok := strings.CutPrefix(arg, "msgid=")
+	if !ok || id == "" {
+		return fmt.Errorf("invalid date, time or message: %q", arg)
+	}
+	w.id = id
+	app.windows[bk] = w
+	r.AroundID(id)
+	return nil
+}
+
+// parseGotoTime parses a time of day (today), a date and time, or an RFC 3339
+// timestamp.
+func parseGotoTime(s string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, true
+	}
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02 15:04:05", "2006-01-02T15:04", "2006-01-02T15:04:05"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, true
+		}
+	}
+	now := time.Now()
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			y, m, d := now.Date()
+			return time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), 0, time.Local), true
+		}
+	}
+	return time.Time{}, false
+}
+
 func commandDoSearch(app *App, args []string) (err error) {
 	if len(args) == 0 {
 		app.win.CloseOverlay()
@@ -954,7 +1269,26 @@ func commandDoSearch(app *App, args []string) (err error) {
 		return errOffline
 	}
 	if !s.HasCapability("soju.im/search") {
-		return errors.New("server does not support searching")
+		if app.logs == nil {
+			return errors.New("server does not support searching")
+		}
+		var target string
+		if channel != "" {
+			target = s.Casemap(channel)
+		}
+		network := app.networkKey(netID)
+		go func() {
+			evs, err := app.logs.Search(network, target, text, 100)
+			app.postEvent(event{
+				src: "*",
+				content: &logSearchDone{
+					netID: netID,
+					evs:   evs,
+					err:   err,
+				},
+			})
+		}()
+		return nil
 	}
 	s.Search(channel, text)
 	return nil
@@ -1043,26 +1377,45 @@ func parseCommand(s string) (command, args string, isCommand bool) {
 	return strings.ToUpper(s[1:i]), strings.TrimLeft(s[i:], " "), true
 }
 
+// hasCommand returns whether any of the given input lines is a command.
+func hasCommand(lines []string) bool {
+	for _, line := range lines {
+		if _, _, isCommand := parseCommand(line); isCommand {
+			return true
+		}
+	}
+	return false
+}
+
 func commandSendMessage(app *App, target string, content string) error {
 	netID, _ := app.win.CurrentBuffer()
+	return app.sendMessage(netID, target, content)
+}
+
+// sendMessage sends content to target on the network netID, and shows it
+// unless the server echoes it back.
+func (app *App) sendMessage(netID, target, content string) error {
 	s := app.sessions[netID]
 	if s == nil {
 		return errOffline
 	}
 	s.PrivMsg(target, content)
 	if !s.HasCapability("echo-message") {
-		buffer, line := app.formatMessage(s, irc.MessageEvent{
+		ev := irc.MessageEvent{
 			User:            s.Nick(),
 			Target:          target,
 			TargetIsChannel: s.IsChannel(target),
 			Command:         "PRIVMSG",
 			Content:         content,
 			Time:            time.Now(),
-		})
+		}
+		app.logMessage(s, netID, ev)
+		buffer, line := app.formatMessage(s, ev)
 		if buffer != "" && !s.IsChannel(target) {
 			app.addUserBuffer(netID, buffer, time.Time{})
 		}
 		app.win.AddLine(netID, buffer, line)
+		app.recordActivity(netID, s, buffer, ev, line)
 	}
 	return nil
 }
@@ -1080,7 +1433,12 @@ func commandDoTableFlip(app *App, args []string) (err error) {
 func (app *App) handleInput(buffer, content string) error {
 	confirmed := content == app.lastConfirm
 	app.lastConfirm = content
+	return app.runInput(buffer, content, confirmed)
+}
 
+// runInput sends a message or runs a command. Unless confirmed, input that
+// looks like a mistake is refused with an error.
+func (app *App) runInput(buffer, content string, confirmed bool) error {
 	if content == "" {
 		return nil
 	}
@@ -1100,17 +1458,21 @@ func (app *App) handleInput(buffer, content string) error {
 		cmdName = "BUFFER"
 	}
 
-	var chosenCMDName string
-	var found bool
-	for key := range commands {
-		if !strings.HasPrefix(key, cmdName) {
-			continue
-		}
-		if found {
-			return fmt.Errorf("ambiguous command %q (could mean %v or %v)", cmdName, chosenCMDName, key)
+	// An exact match wins over longer commands, e.g. for an alias named
+	// after the prefix of a command.
+	chosenCMDName := cmdName
+	_, found := app.commands[cmdName]
+	if !found {
+		for key := range app.commands {
+			if !strings.HasPrefix(key, cmdName) {
+				continue
+			}
+			if found {
+				return fmt.Errorf("ambiguous command %q (could mean %v or %v)", cmdName, chosenCMDName, key)
+			}
+			chosenCMDName = key
+			found = true
 		}
-		chosenCMDName = key
-		found = true
 	}
 	if !found {
 		if confirmed {
@@ -1129,7 +1491,7 @@ func (app *App) handleInput(buffer, content string) error {
 		}
 	}
 
-	cmd := commands[chosenCMDName]
+	cmd := app.commands[chosenCMDName]
 
 	var args []string
 	if rawArgs != "" && cmd.MaxArgs != 0 {
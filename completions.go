@@ -2,30 +2,201 @@ package senpai
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"git.sr.ht/~delthas/senpai/irc"
 	"git.sr.ht/~delthas/senpai/ui"
 )
 
-type members []irc.Member
+// completionHalfLife is the time after which an interaction counts half as
+// much when ranking completions.
+const completionHalfLife = 30 * time.Minute
 
-func (m members) Len() int {
-	return len(m)
+// completionForget is the age past which interactions are forgotten, as they
+// weigh less than a 4000th of current ones.
+const completionForget = 12 * completionHalfLife
+
+// nickActivity is the recent interactions with a user, to rank them in
+// completions.
+type nickActivity struct {
+	highlighted time.Time // they last highlighted us or messaged us privately
+	addressed   time.Time // we last addressed or replied to them
+}
+
+// recency returns the weight of an interaction at t, from 1 for a current
+// one down to 0.
+func recency(now, t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	d := now.Sub(t)
+	if d < 0 {
+		d = 0
+	}
+	return math.Exp2(-float64(d) / float64(completionHalfLife))
+}
+
+// Kinds of matches of completions, from worst to best.
+const (
+	matchNone = iota
+	matchFuzzy
+	matchSubstring
+	matchPrefix
+)
+
+// matchCompletion returns how well word matches name: as a prefix, a
+// substring, or with its characters in order in name. Words of a single
+// character only match as a prefix.
+func matchCompletion(name, word string) int {
+	switch {
+	case strings.HasPrefix(name, word):
+		return matchPrefix
+	case utf8.RuneCountInString(word) < 2:
+		return matchNone
+	case strings.Contains(name, word):
+		return matchSubstring
+	}
+	rest := name
+	for _, r := range word {
+		i := strings.IndexRune(rest, r)
+		if i < 0 {
+			return matchNone
+		}
+		rest = rest[i+utf8.RuneLen(r):]
+	}
+	return matchFuzzy
+}
+
+type rankedCompletion struct {
+	name  string
+	match int
+	score float64
+}
+
+// sortCompletions sorts completions by how well they match, then by score,
+// then by name.
+func sortCompletions(rs []rankedCompletion) {
+	sort.SliceStable(rs, func(i, j int) bool {
+		if rs[i].match != rs[j].match {
+			return rs[i].match > rs[j].match
+		}
+		if rs[i].score != rs[j].score {
+			return rs[i].score > rs[j].score
+		}
+		return strings.ToLower(rs[i].name) < strings.ToLower(rs[j].name)
+	})
+}
+
+// rankNicks returns the names of the members matching word, best first.
+// Members who recently addressed us, who we recently addressed, and who
+// recently spoke come first.
+func (app *App) rankNicks(netID string, s *irc.Session, names []irc.Member, word string) []string {
+	now := time.Now()
+	wordCf := s.Casemap(word)
+	rs := make([]rankedCompletion, 0, len(names))
+	for _, m := range names {
+		nickCf := s.Casemap(m.Name.Name)
+		match := matchCompletion(nickCf, wordCf)
+		if match == matchNone {
+			continue
+		}
+		var score float64
+		if m.Self || s.IsMe(m.Name.Name) {
+			score = -1
+		} else {
+			a := app.nickActivity[boundKey{netID, nickCf}]
+			score = 3*recency(now, a.addressed) + 2*recency(now, a.highlighted) + recency(now, m.LastActive)
+		}
+		rs = append(rs, rankedCompletion{
+			name:  m.Name.Name,
+			match: match,
+			score: score,
+		})
+	}
+	sortCompletions(rs)
+	nicks := make([]string, len(rs))
+	for i, r := range rs {
+		nicks[i] = r.name
+	}
+	return nicks
 }
 
-func (m members) Less(i, j int) bool {
-	if c := m[i].LastActive.Compare(m[j].LastActive); c != 0 {
-		return c > 0
+// addressedNick returns the nick a message starts by addressing, as in
+// "nick: hello" or "nick, hello".
+func addressedNick(content string) (string, bool) {
+	word, _, _ := strings.Cut(content, " ")
+	if len(word) < 2 {
+		return "", false
+	}
+	switch word[len(word)-1] {
+	case ':', ',':
+		return word[:len(word)-1], true
+	}
+	return "", false
+}
+
+// recordActivity records the interactions of a message in buffer, to rank
+// nick completions.
+func (app *App) recordActivity(netID string, s *irc.Session, buffer string, ev irc.MessageEvent, line ui.Line) {
+	app.pruneActivity(time.Now())
+	at := line.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+	if !s.IsMe(ev.User) {
+		if line.Notify == ui.NotifyHighlight || !ev.TargetIsChannel {
+			k := boundKey{netID, s.Casemap(ev.User)}
+			a := app.nickActivity[k]
+			a.highlighted = at
+			app.nickActivity[k] = a
+		}
+		return
+	}
+	var nicks []string
+	if !ev.TargetIsChannel {
+		nicks = append(nicks, ev.Target)
+	}
+	if nick, ok := addressedNick(ev.Content); ok {
+		nicks = append(nicks, nick)
+	}
+	if ev.ReplyTo != "" {
+		if l, ok := app.win.FindLine(netID, buffer, func(l *ui.Line) bool {
+			return l.ID == ev.ReplyTo && !l.Redacted
+		}); ok {
+			if m, ok := l.Data.(irc.MessageEvent); ok {
+				nicks = append(nicks, m.User)
+			}
+		}
+	}
+	for _, nick := range nicks {
+		if s.IsMe(nick) {
+			continue
+		}
+		k := boundKey{netID, s.Casemap(nick)}
+		a := app.nickActivity[k]
+		a.addressed = at
+		app.nickActivity[k] = a
 	}
-	return strings.ToLower(m[i].Name.Name) < strings.ToLower(m[j].Name.Name)
 }
 
-func (m members) Swap(i, j int) {
-	m[i], m[j] = m[j], m[i]
+// pruneActivity forgets the interactions older than completionForget, at most
+// once per completionHalfLife, so that users seen once are not kept forever.
+func (app *App) pruneActivity(now time.Time) {
+	if now.Sub(app.activityPruned) < completionHalfLife {
+		return
+	}
+	app.activityPruned = now
+	for k, a := range app.nickActivity {
+		if now.Sub(a.highlighted) > completionForget && now.Sub(a.addressed) > completionForget {
+			delete(app.nickActivity, k)
+		}
+	}
 }
 
 type completionAsync func(e irc.Event) []ui.Completion
@@ -47,31 +218,44 @@ func (app *App) completionsChannelMembers(cs []ui.Completion, cursorIdx int, tex
 	}
 	netID, buffer := app.win.CurrentBuffer()
 	s := app.sessions[netID] // is not nil
-	wordCf := s.Casemap(string(word))
-	names := members(s.Names(buffer))
-	sort.Sort(names)
-	for _, name := range names {
-		if strings.HasPrefix(s.Casemap(name.Name.Name), wordCf) {
-			nickComp := []rune(name.Name.Name)
-			if start == 0 {
-				nickComp = append(nickComp, ':')
-			}
-			nickComp = append(nickComp, ' ')
-			c := make([]rune, len(text)+len(nickComp)-len(word))
-			copy(c[:start], text[:start])
-			if cursorIdx < len(text) {
-				copy(c[start+len(nickComp):], text[cursorIdx:])
+	if s.IsChannel(string(word)) {
+		return cs
+	}
+	names := s.Names(buffer)
+	if !s.IsChannel(buffer) {
+		// In queries, also complete the users of other channels.
+		known := make(map[string]bool, len(names))
+		for _, m := range names {
+			known[s.Casemap(m.Name.Name)] = true
+		}
+		for _, user := range s.Users() {
+			if !known[s.Casemap(user)] {
+				names = append(names, irc.Member{
+					Name: &irc.Prefix{Name: user},
+				})
 			}
-			copy(c[start:], nickComp)
-			cs = append(cs, ui.Completion{
-				StartIdx:  start,
-				EndIdx:    cursorIdx,
-				Text:      c,
-				Display:   []rune(name.Name.Name),
-				CursorIdx: start + len(nickComp),
-			})
 		}
 	}
+	for _, name := range app.rankNicks(netID, s, names, string(word)) {
+		nickComp := []rune(name)
+		if start == 0 {
+			nickComp = append(nickComp, ':')
+		}
+		nickComp = append(nickComp, ' ')
+		c := make([]rune, len(text)+len(nickComp)-len(word))
+		copy(c[:start], text[:start])
+		if cursorIdx < len(text) {
+			copy(c[start+len(nickComp):], text[cursorIdx:])
+		}
+		copy(c[start:], nickComp)
+		cs = append(cs, ui.Completion{
+			StartIdx:  start,
+			EndIdx:    cursorIdx,
+			Text:      c,
+			Display:   []rune(name),
+			CursorIdx: start + len(nickComp),
+		})
+	}
 	return cs
 }
 
@@ -162,24 +346,83 @@ func (app *App) completionsMsg(cs []ui.Completion, cursorIdx int, text []rune) [
 		return cs
 	}
 	users := s.Users()
-	sort.Strings(users)
+	names := make([]irc.Member, 0, len(users))
 	for _, user := range users {
-		if strings.HasPrefix(s.Casemap(user), word) {
-			nickComp := append([]rune(user), ' ')
-			c := make([]rune, len(text)+5+len(nickComp)-cursorIdx)
-			copy(c[:5], []rune("/msg "))
-			copy(c[5:], nickComp)
-			if cursorIdx < len(text) {
-				copy(c[5+len(nickComp):], text[cursorIdx:])
-			}
-			cs = append(cs, ui.Completion{
-				StartIdx:  5,
-				EndIdx:    cursorIdx,
-				Text:      c,
-				Display:   []rune(user),
-				CursorIdx: 5 + len(nickComp),
-			})
+		names = append(names, irc.Member{
+			Name: &irc.Prefix{Name: user},
+		})
+	}
+	for _, user := range app.rankNicks(s.NetID(), s, names, word) {
+		nickComp := append([]rune(user), ' ')
+		c := make([]rune, len(text)+5+len(nickComp)-cursorIdx)
+		copy(c[:5], []rune("/msg "))
+		copy(c[5:], nickComp)
+		if cursorIdx < len(text) {
+			copy(c[5+len(nickComp):], text[cursorIdx:])
 		}
+		cs = append(cs, ui.Completion{
+			StartIdx:  5,
+			EndIdx:    cursorIdx,
+			Text:      c,
+			Display:   []rune(user),
+			CursorIdx: 5 + len(nickComp),
+		})
+	}
+	return cs
+}
+
+// completionsChannels completes the names of the joined channels, for words
+// starting like channels anywhere in the message.
+func (app *App) completionsChannels(cs []ui.Completion, cursorIdx int, text []rune) []ui.Completion {
+	if hasPrefix(text, []rune("/join ")) {
+		// Completed from the channels of the server instead.
+		return cs
+	}
+	var start int
+	for start = cursorIdx - 1; 0 <= start; start-- {
+		if text[start] == ' ' {
+			break
+		}
+	}
+	start++
+	word := string(text[start:cursorIdx])
+	netID, _ := app.win.CurrentBuffer()
+	s := app.sessions[netID] // is not nil
+	if word == "" || !s.IsChannel(word) {
+		return cs
+	}
+	now := time.Now()
+	wordCf := s.Casemap(word)
+	var rs []rankedCompletion
+	for _, b := range app.win.Buffers() {
+		if b.NetID != netID || !s.IsChannel(b.Title) {
+			continue
+		}
+		titleCf := s.Casemap(b.Title)
+		match := matchCompletion(titleCf, wordCf)
+		if match == matchNone {
+			continue
+		}
+		rs = append(rs, rankedCompletion{
+			name:  b.Title,
+			match: match,
+			score: recency(now, app.messageBounds[boundKey{netID, titleCf}].last),
+		})
+	}
+	sortCompletions(rs)
+	for _, r := range rs {
+		comp := append([]rune(r.name), ' ')
+		c := make([]rune, 0, len(text)+len(comp)-len(word))
+		c = append(c, text[:start]...)
+		c = append(c, comp...)
+		c = append(c, text[cursorIdx:]...)
+		cs = append(cs, ui.Completion{
+			StartIdx:  start,
+			EndIdx:    cursorIdx,
+			Text:      c,
+			Display:   []rune(r.name),
+			CursorIdx: start + len(comp),
+		})
 	}
 	return cs
 }
@@ -274,8 +517,8 @@ func (app *App) completionsCommands(cs []ui.Completion, cursorIdx int, text []ru
 	}
 
 	uText := strings.ToUpper(string(text[1:cursorIdx]))
-	names := make([]string, 0, len(commands))
-	for name := range commands {
+	names := make([]string, 0, len(app.commands))
+	for name := range app.commands {
 		names = append(names, name)
 	}
 	sort.Strings(names)
@@ -297,14 +540,33 @@ func (app *App) completionsCommands(cs []ui.Completion, cursorIdx int, text []ru
 	return cs
 }
 
+func isEmojiRune(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r == '_' || (r >= '0' && r <= '9')
+}
+
+// completionsEmoji completes :emoji: shortcodes, anywhere in words. The
+// cursor can be in the middle of the shortcode, or after its closing colon.
 func (app *App) completionsEmoji(cs []ui.Completion, cursorIdx int, text []rune) []ui.Completion {
+	end := cursorIdx
+	closed := cursorIdx > 0 && text[cursorIdx-1] == ':'
+	if closed {
+		// The cursor is after a whole shortcode: complete it exactly.
+		cursorIdx--
+	} else {
+		for end < len(text) && isEmojiRune(text[end]) {
+			end++
+		}
+		if end < len(text) && text[end] == ':' {
+			end++
+		}
+	}
 	var start int
 	for start = cursorIdx - 1; start >= 0; start-- {
 		r := text[start]
 		if r == ':' {
 			break
 		}
-		if !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r == '_' || (r >= '0' && r <= '9')) {
+		if !isEmojiRune(r) {
 			return cs
 		}
 	}
@@ -318,15 +580,16 @@ func (app *App) completionsEmoji(cs []ui.Completion, cursorIdx int, text []rune)
 	}
 	w := strings.ToLower(string(word))
 	for _, emoji := range findEmoji(w) {
-		c := make([]rune, 0, len(text)+len([]rune(emoji.Emoji))-len(word)-1)
+		if closed && emoji.Alias != w {
+			continue
+		}
+		c := make([]rune, 0, len(text)+len([]rune(emoji.Emoji))-(end-start)-1)
 		c = append(c, text[:start-1]...)
 		c = append(c, []rune(emoji.Emoji)...)
-		if cursorIdx < len(text) {
-			c = append(c, text[cursorIdx:]...)
-		}
+		c = append(c, text[end:]...)
 		cs = append(cs, ui.Completion{
 			StartIdx:  start - 1,
-			EndIdx:    cursorIdx,
+			EndIdx:    end,
 			Text:      c,
 			Display:   []rune(fmt.Sprintf("%v (%v)", emoji.Emoji, emoji.Alias)),
 			CursorIdx: start - 1 + len([]rune(emoji.Emoji)),
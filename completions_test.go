@@ -0,0 +1,110 @@
+package senpai
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatchCompletion(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		word     string
+		expected int
+	}{
+		{"alice", "al", matchPrefix},
+		{"alice", "a", matchPrefix},
+		{"malice", "a", matchNone},
+		{"malice", "al", matchSubstring},
+		{"john_doe", "jdoe", matchFuzzy},
+		{"john_doe", "doej", matchNone},
+	} {
+		if actual := matchCompletion(tc.name, tc.word); actual != tc.expected {
+			t.Errorf("%q, %q: got %v, expected %v", tc.name, tc.word, actual, tc.expected)
+		}
+	}
+}
+
+func TestSortCompletions(t *testing.T) {
+	now := time.Now()
+	rs := []rankedCompletion{
+		{name: "bob", match: matchFuzzy, score: 3},
+		{name: "carol", match: matchPrefix, score: recency(now, now.Add(-time.Hour))},
+		{name: "alice", match: matchPrefix, score: recency(now, now.Add(-time.Minute))},
+		{name: "dave", match: matchPrefix},
+	}
+	sortCompletions(rs)
+	for i, expected := range []string{"alice", "carol", "dave", "bob"} {
+		if rs[i].name != expected {
+			t.Errorf("completion #%d: got %q, expected %q", i, rs[i].name, expected)
+		}
+	}
+}
+
+func TestAddressedNick(t *testing.T) {
+	for _, tc := range []struct {
+		content string
+		nick    string
+	}{
+		{"alice: hello", "alice"},
+		{"bob, hello", "bob"},
+		{"hello alice:", ""},
+		{": hello", ""},
+	} {
+		if nick, ok := addressedNick(tc.content); nick != tc.nick || ok != (tc.nick != "") {
+			t.Errorf("%q: got %q, %v, expected %q", tc.content, nick, ok, tc.nick)
+		}
+	}
+}
+
+func TestCompletionsEmoji(t *testing.T) {
+	app := &App{}
+	for _, tc := range []struct {
+		text     string
+		cursor   int
+		expected string
+	}{
+		{"nice:thumbsup", 13, "nice👍"},
+		{"a :thumbsu b", 10, "a 👍 b"},
+		{"a :thumbsup: b", 10, "a 👍 b"},
+		{"a :thumbsup: b", 12, "a 👍 b"},
+	} {
+		cs := app.completionsEmoji(nil, tc.cursor, []rune(tc.text))
+		if len(cs) == 0 {
+			t.Errorf("%q at %d: got no completions", tc.text, tc.cursor)
+			continue
+		}
+		if actual := string(cs[0].Text); actual != tc.expected {
+			t.Errorf("%q at %d: got %q, expected %q", tc.text, tc.cursor, actual, tc.expected)
+		}
+	}
+	if cs := app.completionsEmoji(nil, 10, []rune(":thumbsu: b")); len(cs) != 0 {
+		t.Errorf("incomplete closed shortcode: got %d completions, expected none", len(cs))
+	}
+}
+
+func TestPruneActivity(t *testing.T) {
+	now := time.Now()
+	app := &App{
+		nickActivity: map[boundKey]nickActivity{
+			{"", "old"}:       {highlighted: now.Add(-completionForget - time.Minute)},
+			{"", "addressed"}: {addressed: now.Add(-time.Minute)},
+			{"", "both"}:      {highlighted: now.Add(-24 * time.Hour), addressed: now.Add(-time.Minute)},
+		},
+	}
+	app.pruneActivity(now)
+	for _, nick := range []string{"addressed", "both"} {
+		if _, ok := app.nickActivity[boundKey{"", nick}]; !ok {
+			t.Errorf("%q: expected the recent interaction to be kept", nick)
+		}
+	}
+	if _, ok := app.nickActivity[boundKey{"", "old"}]; ok {
+		t.Errorf("expected the old interaction to be forgotten")
+	}
+
+	// Pruning again right away is skipped.
+	app.nickActivity[boundKey{"", "old"}] = nickActivity{}
+	app.pruneActivity(now.Add(time.Minute))
+	if _, ok := app.nickActivity[boundKey{"", "old"}]; !ok {
+		t.Errorf("expected no pruning within a half-life of the last one")
+	}
+}
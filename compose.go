@@ -0,0 +1,89 @@
+package senpai
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// composeDone is posted when the editor composing a message exits.
+type composeDone struct {
+	path string // path of the file holding the message
+	err  error
+}
+
+// composeEditor returns the command line of the editor to compose messages
+// in.
+func composeEditor() string {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return "vi"
+}
+
+// compose opens the text of the input field in the editor of the user, giving
+// it the terminal. The input field is set to the message composed in the
+// editor once it exits.
+func (app *App) compose() error {
+	f, err := os.CreateTemp("", "senpai-*.txt")
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(string(app.win.InputContent()))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	// Run the editor through the shell, as it may contain arguments.
+	cmd := exec.Command("sh", "-c", composeEditor()+` "$1"`, "sh", f.Name())
+	if err := app.win.Suspend(cmd); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		app.win.Resume()
+		os.Remove(f.Name())
+		return err
+	}
+	// Wait for the editor in the background, so that the connections are
+	// kept alive meanwhile.
+	go func() {
+		err := cmd.Wait()
+		app.postEvent(event{
+			src: "*",
+			content: &composeDone{
+				path: f.Name(),
+				err:  err,
+			},
+		})
+	}()
+	return nil
+}
+
+func (app *App) handleComposeDone(ev *composeDone) error {
+	defer os.Remove(ev.path)
+	if err := app.win.Resume(); err != nil {
+		return err
+	}
+	if ev.err != nil {
+		return fmt.Errorf("editor failed, keeping the previous text: %v", ev.err)
+	}
+	b, err := os.ReadFile(ev.path)
+	if err != nil {
+		return err
+	}
+	text := strings.ReplaceAll(string(b), "\r\n", "\n")
+	// Editors usually end files with a newline.
+	text = strings.TrimRight(text, "\n")
+	app.win.InputSet(text)
+	app.typing()
+	app.spellCheck()
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -87,7 +88,9 @@ func parseColor(s string, c *vaxis.Color) error {
 	return nil
 }
 
-type Config struct {
+// ServerConfig holds the settings used to connect to a server.
+type ServerConfig struct {
+	Name          string
 	Addr          string
 	Nick          string
 	Real          string
@@ -95,16 +98,44 @@ type Config struct {
 	Password      *string
 	TLS           bool
 	TLSSkipVerify bool
+	TLSCert       string // path to the TLS client certificate
+	TLSKey        string // path to the TLS client key, if not in TLSCert
+	SASLMechanism string // lowercase SASL mechanism name, or empty for the default
 
 	Channels []string
+}
+
+// PreviewConfig holds the settings of link previews.
+type PreviewConfig struct {
+	CacheSize   int64    // maximum size of the on-disk cache, in bytes
+	Proxy       *url.URL // proxy to fetch previews through, if any
+	UserAgent   string   // empty for the default user agent
+	Allow       []string // domains to fetch previews from, all if empty
+	Deny        []string // domains never to fetch previews from
+	Untrusted   []string // channels never to fetch previews in
+	MembersOnly bool     // only fetch previews of links sent by channel members
+	Unfurl      bool     // show summaries of links below messages by default
+}
+
+type Config struct {
+	ServerConfig
+
+	// Servers are the independent servers to connect to, in addition to
+	// the main server.
+	Servers []ServerConfig
 
 	Typings    bool
 	Mouse      bool
 	SpellCheck bool
+	ViMode     bool
 
 	Highlights       []string
+	HighlightRules   []HighlightRule
 	OnHighlightPath  string
 	OnHighlightBeep  bool
+	Log              bool
+	LogPath          string
+	IgnoreCollapse   bool
 	NickColWidth     int
 	ChanColWidth     int
 	ChanColEnabled   bool
@@ -113,8 +144,15 @@ type Config struct {
 	TextMaxWidth     int
 	StatusEnabled    bool
 	Shortcuts        map[string][]string
+	Aliases          map[string][]string // commands of aliases, by uppercase name
 
-	Colors ui.ConfigColors
+	BufferGroups []ui.BufferGroup
+
+	Previews PreviewConfig
+
+	Colors     ui.ConfigColors
+	Theme      *ui.Theme
+	LightTheme *ui.Theme // theme for light terminals, Theme if nil
 
 	OpenLink          string
 	Debug             bool
@@ -125,6 +163,17 @@ type Config struct {
 	WithConsole console.Console
 }
 
+// HighlightRule is a set of highlight rules, from a highlight block.
+type HighlightRule struct {
+	Networks []string // names of the networks the rule applies to, or all if empty
+	Channels []string // names of the buffers the rule applies to, or all if empty
+
+	Keywords     []string
+	Regexps      []*regexp.Regexp
+	ExcludeNicks []*regexp.Regexp // senders that never highlight
+	Excludes     []*regexp.Regexp // messages that never highlight
+}
+
 func DefaultHighlightPath() (string, error) {
 	configDir, err := os.UserConfigDir()
 	if err != nil {
@@ -133,22 +182,63 @@ func DefaultHighlightPath() (string, error) {
 	return path.Join(configDir, "senpai", "highlight"), nil
 }
 
+func DefaultIgnorePath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(configDir, "senpai", "ignore"), nil
+}
+
+func DefaultNotifyPath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(configDir, "senpai", "notify"), nil
+}
+
+func DefaultUnfurlPath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(configDir, "senpai", "unfurl"), nil
+}
+
+func DefaultImageCachePath() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(cacheDir, "senpai", "images"), nil
+}
+
+func DefaultLogPath() (string, error) {
+	dataDir := os.Getenv("XDG_DATA_HOME")
+	if dataDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dataDir = path.Join(home, ".local", "share")
+	}
+	return path.Join(dataDir, "senpai", "logs"), nil
+}
+
 func Defaults() Config {
 	return Config{
-		Addr:             "",
-		Nick:             "",
-		Real:             "",
-		User:             "",
-		Password:         nil,
-		TLS:              true,
-		TLSSkipVerify:    false,
-		Channels:         nil,
+		ServerConfig:     defaultServer(),
+		Servers:          nil,
 		Typings:          true,
 		Mouse:            true,
 		SpellCheck:       false,
 		Highlights:       nil,
 		OnHighlightPath:  "",
 		OnHighlightBeep:  false,
+		Log:              false,
+		LogPath:          "",
+		IgnoreCollapse:   false,
 		NickColWidth:     14,
 		ChanColWidth:     16,
 		ChanColEnabled:   true,
@@ -156,6 +246,9 @@ func Defaults() Config {
 		MemberColEnabled: true,
 		TextMaxWidth:     0,
 		StatusEnabled:    true,
+		Previews: PreviewConfig{
+			CacheSize: 100 << 20,
+		},
 		Colors: ui.ConfigColors{
 			Status: ui.ColorDefault, // Overriden by UI later.
 			Prompt: ui.ColorDefault,
@@ -166,13 +259,30 @@ func Defaults() Config {
 			},
 		},
 		Shortcuts:         make(map[string][]string),
+		Aliases:           make(map[string][]string),
 		Debug:             false,
 		Transient:         false,
 		LocalIntegrations: true,
 	}
 }
 
-func ParseAddr(addr string, cfg *Config) error {
+func defaultServer() ServerConfig {
+	return ServerConfig{
+		Addr:          "",
+		Nick:          "",
+		Real:          "",
+		User:          "",
+		Password:      nil,
+		TLS:           true,
+		TLSSkipVerify: false,
+		TLSCert:       "",
+		TLSKey:        "",
+		SASLMechanism: "",
+		Channels:      nil,
+	}
+}
+
+func ParseAddr(addr string, cfg *ServerConfig) error {
 	if addr == "" {
 		return nil
 	}
@@ -225,12 +335,81 @@ func LoadConfigFile(filename string) (Config, error) {
 	if err != nil {
 		return Config{}, err
 	}
-	if err := ParseAddr(cfg.Addr, &cfg); err != nil {
+	if err := ParseAddr(cfg.Addr, &cfg.ServerConfig); err != nil {
+		return Config{}, err
+	}
+	if err := checkServer(&cfg.ServerConfig); err != nil {
 		return Config{}, err
 	}
+	for i := range cfg.Servers {
+		srv := &cfg.Servers[i]
+		if err := ParseAddr(srv.Addr, srv); err != nil {
+			return Config{}, fmt.Errorf("server %q: %v", srv.Name, err)
+		}
+		if err := checkServer(srv); err != nil {
+			return Config{}, fmt.Errorf("server %q: %v", srv.Name, err)
+		}
+	}
 	return cfg, nil
 }
 
+func unmarshalHighlight(block scfg.Block, rule *HighlightRule) error {
+	compile := func(d *scfg.Directive, res *[]*regexp.Regexp) error {
+		if len(d.Params) == 0 {
+			return fmt.Errorf("highlight: %v requires at least one parameter", d.Name)
+		}
+		for _, p := range d.Params {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return fmt.Errorf("highlight: %v: %v", d.Name, err)
+			}
+			*res = append(*res, re)
+		}
+		return nil
+	}
+	for _, d := range block {
+		var err error
+		switch d.Name {
+		case "network":
+			rule.Networks = append(rule.Networks, d.Params...)
+		case "channel":
+			rule.Channels = append(rule.Channels, d.Params...)
+		case "keyword":
+			rule.Keywords = append(rule.Keywords, d.Params...)
+		case "regex":
+			err = compile(d, &rule.Regexps)
+		case "exclude-nick":
+			err = compile(d, &rule.ExcludeNicks)
+		case "exclude":
+			err = compile(d, &rule.Excludes)
+		default:
+			err = fmt.Errorf("highlight: unknown directive %q", d.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkServer checks that the authentication settings of srv are consistent.
+func checkServer(srv *ServerConfig) error {
+	if srv.TLSCert != "" && !srv.TLS {
+		return fmt.Errorf("tls-certificate requires TLS")
+	}
+	switch srv.SASLMechanism {
+	case "plain", "scram-sha-256":
+		if srv.Password == nil {
+			return fmt.Errorf("sasl-mechanism %v requires a password", srv.SASLMechanism)
+		}
+	case "external":
+		if srv.TLSCert == "" {
+			return fmt.Errorf("sasl-mechanism external requires tls-certificate")
+		}
+	}
+	return nil
+}
+
 func unmarshal(filename string, cfg *Config) (err error) {
 	directives, err := scfg.Load(filename)
 	if err != nil {
@@ -239,55 +418,42 @@ func unmarshal(filename string, cfg *Config) (err error) {
 
 	for _, d := range directives {
 		switch d.Name {
-		case "address":
-			if err := d.ParseParams(&cfg.Addr); err != nil {
-				return err
-			}
-		case "nickname":
-			if err := d.ParseParams(&cfg.Nick); err != nil {
+		case "address", "nickname", "username", "realname", "password", "password-cmd", "channel", "tls", "tls-certificate", "sasl-mechanism":
+			if err := unmarshalServer(d, directives, &cfg.ServerConfig); err != nil {
 				return err
 			}
-		case "username":
-			if err := d.ParseParams(&cfg.User); err != nil {
+		case "server":
+			srv := defaultServer()
+			if err := d.ParseParams(&srv.Name); err != nil {
 				return err
 			}
-		case "realname":
-			if err := d.ParseParams(&cfg.Real); err != nil {
-				return err
+			if strings.ContainsAny(srv.Name, " \t") {
+				return fmt.Errorf("server %q: name must not contain spaces", srv.Name)
 			}
-		case "password":
-			// if a password-cmd is provided, don't use this value
-			if directives.Get("password-cmd") != nil {
-				continue
+			for _, other := range cfg.Servers {
+				if other.Name == srv.Name {
+					return fmt.Errorf("server %q: duplicate server name", srv.Name)
+				}
 			}
-
-			var password string
-			if err := d.ParseParams(&password); err != nil {
-				return err
+			for _, child := range d.Children {
+				if err := unmarshalServer(child, d.Children, &srv); err != nil {
+					return err
+				}
 			}
-			cfg.Password = &password
-		case "password-cmd":
-			var cmdName string
-			if err := d.ParseParams(&cmdName); err != nil {
-				return err
+			if srv.Addr == "" {
+				return fmt.Errorf("server %q: address is required", srv.Name)
 			}
-
-			cmd := exec.Command(cmdName, d.Params[1:]...)
-			var stdout []byte
-			if stdout, err = cmd.Output(); err != nil {
-				return fmt.Errorf("error running password command: %v", err)
+			cfg.Servers = append(cfg.Servers, srv)
+		case "highlight":
+			if d.Children == nil {
+				cfg.Highlights = append(cfg.Highlights, d.Params...)
+				continue
 			}
-
-			passCmdOut := strings.Split(string(stdout), "\n")
-			if len(passCmdOut) < 1 || strings.TrimSpace(passCmdOut[0]) == "" {
-				return fmt.Errorf("password command returned no data")
+			var rule HighlightRule
+			if err := unmarshalHighlight(d.Children, &rule); err != nil {
+				return err
 			}
-			cfg.Password = &passCmdOut[0]
-		case "channel":
-			// TODO: does this work with soju.im/bouncer-networks extension?
-			cfg.Channels = append(cfg.Channels, d.Params...)
-		case "highlight":
-			cfg.Highlights = append(cfg.Highlights, d.Params...)
+			cfg.HighlightRules = append(cfg.HighlightRules, rule)
 		case "on-highlight-path":
 			if err := d.ParseParams(&cfg.OnHighlightPath); err != nil {
 				return err
@@ -301,6 +467,33 @@ func unmarshal(filename string, cfg *Config) (err error) {
 			if cfg.OnHighlightBeep, err = strconv.ParseBool(onHighlightBeep); err != nil {
 				return err
 			}
+		case "log":
+			var log string
+			if err := d.ParseParams(&log); err != nil {
+				return err
+			}
+
+			if cfg.Log, err = strconv.ParseBool(log); err != nil {
+				return err
+			}
+		case "log-path":
+			if err := d.ParseParams(&cfg.LogPath); err != nil {
+				return err
+			}
+		case "ignore-mode":
+			var mode string
+			if err := d.ParseParams(&mode); err != nil {
+				return err
+			}
+
+			switch mode {
+			case "drop":
+				cfg.IgnoreCollapse = false
+			case "collapse":
+				cfg.IgnoreCollapse = true
+			default:
+				return fmt.Errorf("unknown ignore mode %q", mode)
+			}
 		case "pane-widths":
 			for _, child := range d.Children {
 				switch child.Name {
@@ -360,15 +553,6 @@ func unmarshal(filename string, cfg *Config) (err error) {
 					return fmt.Errorf("unknown directive %q", child.Name)
 				}
 			}
-		case "tls":
-			var tls string
-			if err := d.ParseParams(&tls); err != nil {
-				return err
-			}
-
-			if cfg.TLS, err = strconv.ParseBool(tls); err != nil {
-				return err
-			}
 		case "typings":
 			var typings string
 			if err := d.ParseParams(&typings); err != nil {
@@ -387,6 +571,19 @@ func unmarshal(filename string, cfg *Config) (err error) {
 			if cfg.Mouse, err = strconv.ParseBool(mouse); err != nil {
 				return err
 			}
+		case "editing-mode":
+			var mode string
+			if err := d.ParseParams(&mode); err != nil {
+				return err
+			}
+			switch mode {
+			case "emacs":
+				cfg.ViMode = false
+			case "vi":
+				cfg.ViMode = true
+			default:
+				return fmt.Errorf("unknown editing mode %q", mode)
+			}
 		case "spell-check":
 			var spellCheck string
 			if err := d.ParseParams(&spellCheck); err != nil {
@@ -451,10 +648,69 @@ func unmarshal(filename string, cfg *Config) (err error) {
 					cfg.Colors.Unread = color
 				case "status":
 					cfg.Colors.Status = color
+				case "highlight":
+					cfg.Colors.Highlight = color
 				default:
 					return fmt.Errorf("unknown colors directive %q", child.Name)
 				}
 			}
+		case "buffer-group":
+			var group ui.BufferGroup
+			if err := d.ParseParams(&group.Name); err != nil {
+				return err
+			}
+			for _, child := range d.Children {
+				switch child.Name {
+				case "network":
+					group.Networks = append(group.Networks, child.Params...)
+				case "channel":
+					group.Channels = append(group.Channels, child.Params...)
+				default:
+					return fmt.Errorf("buffer-group %q: unknown directive %q", group.Name, child.Name)
+				}
+			}
+			if len(group.Networks) == 0 && len(group.Channels) == 0 {
+				return fmt.Errorf("buffer-group %q: at least one network or channel is required", group.Name)
+			}
+			for _, other := range cfg.BufferGroups {
+				if other.Name == group.Name {
+					return fmt.Errorf("buffer-group %q: duplicate group name", group.Name)
+				}
+			}
+			cfg.BufferGroups = append(cfg.BufferGroups, group)
+		case "theme":
+			if len(d.Children) == 0 {
+				var name string
+				if err := d.ParseParams(&name); err != nil {
+					return err
+				}
+				if cfg.Theme, err = loadTheme(themePath(filename, name)); err != nil {
+					return fmt.Errorf("theme %q: %v", name, err)
+				}
+				continue
+			}
+			for _, child := range d.Children {
+				var name string
+				if err := child.ParseParams(&name); err != nil {
+					return err
+				}
+				theme, err := loadTheme(themePath(filename, name))
+				if err != nil {
+					return fmt.Errorf("theme %q: %v", name, err)
+				}
+				switch child.Name {
+				case "dark":
+					cfg.Theme = theme
+				case "light":
+					cfg.LightTheme = theme
+				default:
+					return fmt.Errorf("unknown theme directive %q", child.Name)
+				}
+			}
+		case "previews":
+			if err := unmarshalPreviews(d.Children, &cfg.Previews); err != nil {
+				return fmt.Errorf("previews: %v", err)
+			}
 		case "shortcuts":
 			for _, child := range d.Children {
 				if err := child.ParseParams(nil); err != nil {
@@ -462,6 +718,26 @@ func unmarshal(filename string, cfg *Config) (err error) {
 				}
 				cfg.Shortcuts[child.Name] = child.Params
 			}
+		case "alias":
+			var name string
+			if err := d.ParseParams(&name); err != nil {
+				return err
+			}
+			if name == "" || strings.ContainsAny(name, " /") {
+				return fmt.Errorf("alias %q: invalid name", name)
+			}
+			lines := d.Params[1:]
+			if len(lines) == 0 {
+				return fmt.Errorf("alias %q: at least one command is required", name)
+			}
+			upper := strings.ToUpper(name)
+			if _, ok := commands[upper]; ok {
+				return fmt.Errorf("alias %q: a command of the same name already exists", name)
+			}
+			if _, ok := cfg.Aliases[upper]; ok {
+				return fmt.Errorf("alias %q: duplicate alias name", name)
+			}
+			cfg.Aliases[upper] = lines
 		case "debug":
 			var debug string
 			if err := d.ParseParams(&debug); err != nil {
@@ -494,3 +770,157 @@ func unmarshal(filename string, cfg *Config) (err error) {
 
 	return
 }
+
+func unmarshalPreviews(block scfg.Block, cfg *PreviewConfig) error {
+	for _, d := range block {
+		switch d.Name {
+		case "cache-size":
+			var size string
+			if err := d.ParseParams(&size); err != nil {
+				return err
+			}
+			n, err := strconv.ParseInt(size, 10, 64)
+			if err != nil || n < 0 {
+				return fmt.Errorf("cache-size: invalid size %q", size)
+			}
+			cfg.CacheSize = n << 20
+		case "proxy":
+			var proxy string
+			if err := d.ParseParams(&proxy); err != nil {
+				return err
+			}
+			u, err := url.Parse(proxy)
+			if err != nil {
+				return fmt.Errorf("proxy: %v", err)
+			}
+			switch u.Scheme {
+			case "http", "https", "socks5", "socks5h":
+			default:
+				return fmt.Errorf("proxy: unsupported scheme %q", u.Scheme)
+			}
+			cfg.Proxy = u
+		case "user-agent":
+			if err := d.ParseParams(&cfg.UserAgent); err != nil {
+				return err
+			}
+		case "allow", "deny", "untrusted-channel":
+			if len(d.Params) == 0 {
+				return fmt.Errorf("%v: at least one parameter is required", d.Name)
+			}
+			switch d.Name {
+			case "allow":
+				cfg.Allow = append(cfg.Allow, d.Params...)
+			case "deny":
+				cfg.Deny = append(cfg.Deny, d.Params...)
+			case "untrusted-channel":
+				cfg.Untrusted = append(cfg.Untrusted, d.Params...)
+			}
+		case "members-only":
+			var membersOnly string
+			if err := d.ParseParams(&membersOnly); err != nil {
+				return err
+			}
+			var err error
+			if cfg.MembersOnly, err = strconv.ParseBool(membersOnly); err != nil {
+				return err
+			}
+		case "unfurl":
+			var unfurl string
+			if err := d.ParseParams(&unfurl); err != nil {
+				return err
+			}
+			var err error
+			if cfg.Unfurl, err = strconv.ParseBool(unfurl); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown directive %q", d.Name)
+		}
+	}
+	return nil
+}
+
+// unmarshalServer parses a directive of the server settings, either at the
+// top level for the main server, or in a server block.
+func unmarshalServer(d *scfg.Directive, block scfg.Block, srv *ServerConfig) (err error) {
+	switch d.Name {
+	case "address":
+		if err := d.ParseParams(&srv.Addr); err != nil {
+			return err
+		}
+	case "nickname":
+		if err := d.ParseParams(&srv.Nick); err != nil {
+			return err
+		}
+	case "username":
+		if err := d.ParseParams(&srv.User); err != nil {
+			return err
+		}
+	case "realname":
+		if err := d.ParseParams(&srv.Real); err != nil {
+			return err
+		}
+	case "password":
+		// if a password-cmd is provided, don't use this value
+		if block.Get("password-cmd") != nil {
+			return nil
+		}
+
+		var password string
+		if err := d.ParseParams(&password); err != nil {
+			return err
+		}
+		srv.Password = &password
+	case "password-cmd":
+		var cmdName string
+		if err := d.ParseParams(&cmdName); err != nil {
+			return err
+		}
+
+		cmd := exec.Command(cmdName, d.Params[1:]...)
+		var stdout []byte
+		if stdout, err = cmd.Output(); err != nil {
+			return fmt.Errorf("error running password command: %v", err)
+		}
+
+		passCmdOut := strings.Split(string(stdout), "\n")
+		if len(passCmdOut) < 1 || strings.TrimSpace(passCmdOut[0]) == "" {
+			return fmt.Errorf("password command returned no data")
+		}
+		srv.Password = &passCmdOut[0]
+	case "channel":
+		// TODO: does this work with soju.im/bouncer-networks extension?
+		srv.Channels = append(srv.Channels, d.Params...)
+	case "tls":
+		var tls string
+		if err := d.ParseParams(&tls); err != nil {
+			return err
+		}
+
+		if srv.TLS, err = strconv.ParseBool(tls); err != nil {
+			return err
+		}
+	case "tls-certificate":
+		if err := d.ParseParams(&srv.TLSCert); err != nil {
+			return err
+		}
+		if len(d.Params) >= 2 {
+			srv.TLSKey = d.Params[1]
+		}
+	case "sasl-mechanism":
+		var mech string
+		if err := d.ParseParams(&mech); err != nil {
+			return err
+		}
+		mech = strings.ToLower(mech)
+		switch mech {
+		case "plain", "external", "scram-sha-256":
+		default:
+			return fmt.Errorf("unknown SASL mechanism %q", mech)
+		}
+		srv.SASLMechanism = mech
+	default:
+		return fmt.Errorf("unknown directive %q", d.Name)
+	}
+	return nil
+}
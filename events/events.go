@@ -29,6 +29,7 @@ type EventClickLink struct {
 	EventClick
 	Link  string
 	Mouse bool
+	Data  interface{} // Data of the line of the link, if any
 }
 
 type EventClickChannel struct {
@@ -36,6 +37,11 @@ type EventClickChannel struct {
 	Channel string
 }
 
+type EventClickReply struct {
+	EventClick
+	ID string
+}
+
 type EventImageLoaded struct {
 	Image image.Image // nil if error
 }
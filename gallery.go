@@ -0,0 +1,200 @@
+package senpai
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"math"
+
+	"git.sr.ht/~rockorager/vaxis"
+
+	"git.sr.ht/~delthas/senpai/irc"
+)
+
+const (
+	galleryMaxLinks = 50 // number of most recent links shown in the gallery
+	galleryWorkers  = 4  // number of images fetched at the same time
+)
+
+type galleryEntry struct {
+	link   string
+	img    image.Image
+	err    error
+	loaded bool
+}
+
+// gallery is an overlay showing the images of the links of a buffer, one at
+// a time.
+type gallery struct {
+	entries []galleryEntry
+	index   int
+	cancel  context.CancelFunc
+}
+
+// galleryImageLoaded is posted when an image of a gallery is fetched.
+type galleryImageLoaded struct {
+	gallery *gallery
+	link    string
+	img     image.Image
+	err     error
+}
+
+// openGallery opens a gallery of the links of the current buffer, starting
+// from the most recent one, and starts fetching their images.
+func (app *App) openGallery() error {
+	netID, buffer := app.win.CurrentBuffer()
+	lines, _ := app.win.Lines(netID, buffer, math.MaxInt)
+	var links []string
+	seen := make(map[string]struct{})
+	for i := len(lines) - 1; i >= 0 && len(links) < galleryMaxLinks; i-- {
+		if lines[i].Redacted {
+			continue
+		}
+		var user string
+		if ev, ok := lines[i].Data.(irc.MessageEvent); ok {
+			user = ev.User
+		}
+		if !app.previewAllowed(netID, buffer, user) {
+			continue
+		}
+		urls := lines[i].Body.URLs()
+		for j := len(urls) - 1; j >= 0 && len(links) < galleryMaxLinks; j-- {
+			if _, ok := seen[urls[j]]; ok {
+				continue
+			}
+			seen[urls[j]] = struct{}{}
+			links = append(links, urls[j])
+		}
+	}
+	if len(links) == 0 {
+		return fmt.Errorf("no links in this buffer")
+	}
+
+	app.closeGallery()
+	ctx, cancel := context.WithCancel(context.Background())
+	g := &gallery{
+		entries: make([]galleryEntry, len(links)),
+		cancel:  cancel,
+	}
+	// Show links in chronological order.
+	for i, link := range links {
+		g.entries[len(links)-1-i].link = link
+	}
+	g.index = len(links) - 1
+	app.gallery = g
+	app.showGalleryImage()
+
+	go app.images.FetchAll(ctx, links, galleryWorkers, func(link string, b []byte, err error) {
+		var img image.Image
+		if err == nil {
+			img, _, err = app.win.DecodeImage(bytes.NewReader(b))
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		app.postEvent(event{
+			src: "*",
+			content: &galleryImageLoaded{
+				gallery: g,
+				link:    link,
+				img:     img,
+				err:     err,
+			},
+		})
+	})
+	return nil
+}
+
+func (app *App) closeGallery() {
+	if app.gallery == nil {
+		return
+	}
+	app.gallery.cancel()
+	app.gallery = nil
+	app.win.ShowImage(nil)
+}
+
+func (app *App) handleGalleryImageLoaded(ev *galleryImageLoaded) {
+	g := app.gallery
+	if g != ev.gallery {
+		return
+	}
+	for i := range g.entries {
+		e := &g.entries[i]
+		if e.link != ev.link {
+			continue
+		}
+		e.img = ev.img
+		e.err = ev.err
+		e.loaded = true
+		if i == g.index {
+			app.showGalleryImage()
+		}
+	}
+}
+
+// showGalleryImage shows the current image of the gallery, if loaded.
+func (app *App) showGalleryImage() {
+	e := app.gallery.entries[app.gallery.index]
+	app.win.ShowImage(e.img)
+}
+
+// moveGallery shows the first image from the i-th one, going in the direction
+// of d, skipping the links which are not images.
+func (app *App) moveGallery(i, d int) {
+	g := app.gallery
+	for ; 0 <= i && i < len(g.entries); i += d {
+		if g.entries[i].loaded && g.entries[i].err != nil {
+			continue
+		}
+		g.index = i
+		app.showGalleryImage()
+		return
+	}
+}
+
+// galleryActions are the actions of shortcuts which still run while the
+// gallery is open, instead of the keys of the gallery.
+var galleryActions = map[string]bool{
+	"quit":   true,
+	"redraw": true,
+}
+
+func (app *App) handleGalleryKey(ev vaxis.Key) {
+	for _, km := range keyMatches(ev) {
+		if d := app.shortcuts[km]; len(d) != 0 && galleryActions[d[0]] {
+			app.handleAction(d[0], d[1:]...)
+			return
+		}
+	}
+	g := app.gallery
+	switch {
+	case ev.Matches(vaxis.KeyEsc), ev.Matches('q'):
+		app.closeGallery()
+	case ev.Matches(vaxis.KeyLeft), ev.Matches(vaxis.KeyPgUp), ev.Matches('h'), ev.Matches('k'):
+		app.moveGallery(g.index-1, -1)
+	case ev.Matches(vaxis.KeyRight), ev.Matches(vaxis.KeyPgDown), ev.Matches('l'), ev.Matches('j'), ev.Matches(' '):
+		app.moveGallery(g.index+1, 1)
+	case ev.Matches(vaxis.KeyHome):
+		app.moveGallery(0, 1)
+	case ev.Matches(vaxis.KeyEnd):
+		app.moveGallery(len(g.entries)-1, -1)
+	case ev.Matches(vaxis.KeyEnter), ev.Matches('o'):
+		go openLink(g.entries[g.index].link)
+	}
+}
+
+// galleryStatus returns the status line shown while the gallery is open.
+func (app *App) galleryStatus() string {
+	g := app.gallery
+	e := g.entries[g.index]
+	var state string
+	switch {
+	case !e.loaded:
+		state = " (loading...)"
+	case e.err != nil:
+		state = " (no preview)"
+	}
+	return fmt.Sprintf("Image %d/%d%s: %s — Left/Right to browse, Enter to open, Escape to close", g.index+1, len(g.entries), state, e.link)
+}
@@ -0,0 +1,125 @@
+package senpai
+
+import (
+	"strings"
+
+	"git.sr.ht/~delthas/senpai/irc"
+)
+
+// ignoreStore keeps the masks of ignored users of each network. Unless it is
+// transient, masks are saved in one file per network, one mask per line.
+type ignoreStore struct {
+	files networkFiles
+	masks map[string][]string
+}
+
+func newIgnoreStore(root string) *ignoreStore {
+	return &ignoreStore{
+		files: networkFiles{root: root},
+		masks: make(map[string][]string),
+	}
+}
+
+// normalizeMask turns a nick or a partial mask into a full nick!user@host
+// mask.
+func normalizeMask(mask string) string {
+	if !strings.Contains(mask, "!") {
+		if strings.Contains(mask, "@") {
+			mask = "*!" + mask
+		} else {
+			mask += "!*@*"
+		}
+	}
+	if !strings.Contains(mask, "@") {
+		mask += "@*"
+	}
+	return mask
+}
+
+// matchMask reports whether s matches mask, in which '*' matches any
+// sequence of characters and '?' matches any single character.
+func matchMask(mask, s string) bool {
+	// Iterative wildcard matching, backtracking to the last '*' on failure.
+	m, i := 0, 0
+	star, next := -1, 0
+	for i < len(s) {
+		if m < len(mask) && (mask[m] == '?' || mask[m] == s[i]) {
+			m++
+			i++
+		} else if m < len(mask) && mask[m] == '*' {
+			star = m
+			next = i
+			m++
+		} else if star >= 0 {
+			m = star + 1
+			next++
+			i = next
+		} else {
+			return false
+		}
+	}
+	for m < len(mask) && mask[m] == '*' {
+		m++
+	}
+	return m == len(mask)
+}
+
+func (is *ignoreStore) load(network string) []string {
+	if masks, ok := is.masks[network]; ok {
+		return masks
+	}
+	masks := is.files.read(network)
+	is.masks[network] = masks
+	return masks
+}
+
+func (is *ignoreStore) save(network string) error {
+	return is.files.write(network, is.masks[network])
+}
+
+// Masks returns the ignore masks of network.
+func (is *ignoreStore) Masks(network string) []string {
+	return is.load(network)
+}
+
+// Add adds mask to the ignore masks of network. It returns false if the mask
+// was already present.
+func (is *ignoreStore) Add(network, mask string) (bool, error) {
+	masks := is.load(network)
+	for _, m := range masks {
+		if strings.EqualFold(m, mask) {
+			return false, nil
+		}
+	}
+	is.masks[network] = append(masks, mask)
+	return true, is.save(network)
+}
+
+// Remove removes mask from the ignore masks of network. It returns false if
+// the mask was not present.
+func (is *ignoreStore) Remove(network, mask string) (bool, error) {
+	masks := is.load(network)
+	for i, m := range masks {
+		if strings.EqualFold(m, mask) {
+			is.masks[network] = append(masks[:i:i], masks[i+1:]...)
+			return true, is.save(network)
+		}
+	}
+	return false, nil
+}
+
+// Match reports whether the user p is ignored on network, comparing masks
+// with casemap, the casemapping of the network.
+func (is *ignoreStore) Match(network string, p irc.Prefix, casemap func(string) string) bool {
+	masks := is.load(network)
+	if len(masks) == 0 {
+		return false
+	}
+	s := casemap(p.Name + "!" + p.User + "@" + p.Host)
+	for _, mask := range masks {
+		if matchMask(casemap(mask), s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package senpai
+
+import (
+	"testing"
+
+	"git.sr.ht/~delthas/senpai/irc"
+)
+
+func TestNormalizeMask(t *testing.T) {
+	for _, tc := range []struct {
+		mask     string
+		expected string
+	}{
+		{"nick", "nick!*@*"},
+		{"nick!user", "nick!user@*"},
+		{"user@host", "*!user@host"},
+		{"*@host", "*!*@host"},
+		{"nick!user@host", "nick!user@host"},
+	} {
+		if actual := normalizeMask(tc.mask); actual != tc.expected {
+			t.Errorf("%q: got %q, expected %q", tc.mask, actual, tc.expected)
+		}
+	}
+}
+
+func TestMatchMask(t *testing.T) {
+	for _, tc := range []struct {
+		mask     string
+		s        string
+		expected bool
+	}{
+		{"nick!*@*", "nick!user@host", true},
+		{"nick!*@*", "nick2!user@host", false},
+		{"*!*@host", "nick!user@host", true},
+		{"*!*@host", "nick!user@host.example", false},
+		{"n?ck!*@*", "nick!user@host", true},
+		{"n?ck!*@*", "nck!user@host", false},
+		{"*", "", true},
+		{"?", "", false},
+		{"", "", true},
+		// '*' must backtrack to match later occurrences.
+		{"*ab*ab", "xabyabab", true},
+		{"*ab*ab", "xabyaba", false},
+		{"*a?c", "aaabc", true},
+		{"a*b*c", "abxbxc", true},
+		{"a*b*c", "abxbx", false},
+		{"*!*@*.example", "nick!user@a.b.example", true},
+		{"**?", "a", true},
+	} {
+		if actual := matchMask(tc.mask, tc.s); actual != tc.expected {
+			t.Errorf("%q, %q: got %v, expected %v", tc.mask, tc.s, actual, tc.expected)
+		}
+	}
+}
+
+func TestIgnoreMatch(t *testing.T) {
+	is := newIgnoreStore("")
+	if _, err := is.Add("net", normalizeMask("[Foo]")); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		prefix   irc.Prefix
+		casemap  func(string) string
+		expected bool
+	}{
+		{irc.Prefix{Name: "{foo}", User: "u", Host: "h"}, irc.CasemapRFC1459, true},
+		{irc.Prefix{Name: "[FOO]", User: "u", Host: "h"}, irc.CasemapASCII, true},
+		{irc.Prefix{Name: "{foo}", User: "u", Host: "h"}, irc.CasemapASCII, false},
+		{irc.Prefix{Name: "bar", User: "u", Host: "h"}, irc.CasemapRFC1459, false},
+	} {
+		if actual := is.Match("net", tc.prefix, tc.casemap); actual != tc.expected {
+			t.Errorf("%q: got %v, expected %v", tc.prefix.Name, actual, tc.expected)
+		}
+	}
+}
+
+// TestIgnoreMessagePrefix checks that messages are matched with the user and
+// host they were sent with, as in history, even if the sender is not a known
+// member.
+func TestIgnoreMessagePrefix(t *testing.T) {
+	app := &App{
+		cfg: Config{
+			ServerConfig: ServerConfig{Addr: "irc.example.org:6697"},
+		},
+		ignores: newIgnoreStore(""),
+	}
+	if _, err := app.ignores.Add(app.networkKey(""), normalizeMask("*@spam.example")); err != nil {
+		t.Fatal(err)
+	}
+	s := irc.NewSession(make(chan irc.Message, 64), irc.SessionParams{
+		Nickname: "me",
+	})
+	ev := irc.MessageEvent{
+		User:   "spammer",
+		Prefix: irc.Prefix{Name: "spammer", User: "s", Host: "spam.example"},
+	}
+	if !app.isIgnored(s, messagePrefix(s, ev)) {
+		t.Errorf("expected the message to be ignored")
+	}
+	ev.Prefix = irc.Prefix{Name: "spammer"}
+	if app.isIgnored(s, messagePrefix(s, ev)) {
+		t.Errorf("expected the message without host not to be ignored")
+	}
+}
@@ -0,0 +1,238 @@
+package senpai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var patternOpenGraphImage = regexp.MustCompile(`<meta property="og:image" content="(.*?)"/?>`)
+var patternOpenGraphVideo = regexp.MustCompile(`<meta property="og:video"`)
+
+// maxImageSize is the maximum size of a fetched image, in bytes. Previews are
+// shown at the size of the terminal: larger images are not worth fetching.
+const maxImageSize = 2 << 20
+
+// imageFetcher fetches images, or the OpenGraph images of web pages, keeping
+// them in an on-disk cache.
+type imageFetcher struct {
+	userAgent string
+	transport http.RoundTripper // nil for the default transport
+	allow     []string          // domains to fetch from, all if empty
+	deny      []string          // domains never to fetch from
+	cache     *previewCache     // nil to disable the cache
+}
+
+// newImageFetcher returns a fetcher with the given preview settings, caching
+// previews in cacheDir unless it is empty.
+func newImageFetcher(cfg PreviewConfig, cacheDir string) *imageFetcher {
+	f := &imageFetcher{
+		userAgent: cfg.UserAgent,
+		allow:     cfg.Allow,
+		deny:      cfg.Deny,
+	}
+	if f.userAgent == "" {
+		f.userAgent = "senpai"
+		if v, ok := BuildVersion(); ok {
+			f.userAgent = "senpai/" + v
+		}
+	}
+	if cfg.Proxy != nil {
+		f.transport = &http.Transport{
+			Proxy: http.ProxyURL(cfg.Proxy),
+		}
+	}
+	if cacheDir != "" && cfg.CacheSize > 0 {
+		f.cache = newPreviewCache(cacheDir, cfg.CacheSize)
+	}
+	return f
+}
+
+// domainMatches reports whether host is one of domains, or one of their
+// subdomains.
+func domainMatches(host string, domains []string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, d := range domains {
+		d = strings.TrimSuffix(strings.ToLower(d), ".")
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *imageFetcher) checkURL(u *url.URL) error {
+	host := u.Hostname()
+	if domainMatches(host, f.deny) || (len(f.allow) > 0 && !domainMatches(host, f.allow)) {
+		return fmt.Errorf("previews are disabled for %v", host)
+	}
+	return nil
+}
+
+// Fetch returns the contents of the image at link, or of the OpenGraph image
+// of the page at link.
+func (f *imageFetcher) Fetch(ctx context.Context, link string) ([]byte, error) {
+	if f.cache != nil {
+		if b, ok := f.cache.Get(link); ok {
+			return b, nil
+		}
+	}
+	b, err := f.fetch(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+	if f.cache != nil {
+		// The cache is best-effort: ignore errors.
+		f.cache.Put(link, b)
+	}
+	return b, nil
+}
+
+func (f *imageFetcher) request(ctx context.Context, method, link string, timeout time.Duration) (*http.Request, *http.Client, error) {
+	req, err := http.NewRequestWithContext(ctx, method, link, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := f.checkURL(req.URL); err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("User-Agent", f.userAgent)
+	c := &http.Client{
+		Transport: f.transport,
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return f.checkURL(req.URL)
+		},
+	}
+	return req, c, nil
+}
+
+func (f *imageFetcher) fetch(ctx context.Context, link string) ([]byte, error) {
+	if u, err := url.Parse(link); err == nil {
+		changed := true
+		switch u.Host {
+		case "twitter.com", "x.com":
+			u.Host = "fixupx.com"
+		default:
+			changed = false
+		}
+		if changed {
+			link = u.String()
+		}
+	}
+
+	req, c, err := f.request(ctx, "HEAD", link, 1500*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+	contentType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, fmt.Errorf("unexpected content type: %v", res.Header.Get("Content-Type"))
+	}
+	var isHTML bool
+	switch contentType {
+	case "image/gif", "image/jpeg", "image/png": // Actual image, fetch
+		if res.ContentLength > maxImageSize {
+			return nil, errors.New("image too large")
+		}
+	case "text/html": // Might have an opengraph image, try fetching
+		isHTML = true
+	default:
+		return nil, fmt.Errorf("unexpected content type: %v", contentType)
+	}
+	if isHTML {
+		req, c, err := f.request(ctx, "GET", link, 1500*time.Millisecond)
+		if err != nil {
+			return nil, err
+		}
+		var previewSize int64 = 10 * 1024
+		if res.Header.Get("Accept-Ranges") == "bytes" {
+			req.Header.Set("Range", fmt.Sprintf("bytes=0-%v", previewSize))
+		}
+		res, err = c.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		b, err := io.ReadAll(io.LimitReader(res.Body, previewSize))
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unexpected read error: %v", err)
+		}
+		if patternOpenGraphVideo.Match(b) {
+			// Do not display image (previews) of video objects
+			return nil, fmt.Errorf("video embed found")
+		}
+		m := patternOpenGraphImage.FindSubmatch(b)
+		if len(m) < 2 {
+			return nil, fmt.Errorf("image embed not found")
+		}
+		link = html.UnescapeString(string(m[1]))
+	}
+	req, c, err = f.request(ctx, "GET", link, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	res, err = c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+	if res.ContentLength > maxImageSize {
+		return nil, errors.New("image too large")
+	}
+	b, err := io.ReadAll(io.LimitReader(res.Body, maxImageSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("unexpected read error: %v", err)
+	}
+	if len(b) > maxImageSize {
+		return nil, errors.New("image too large")
+	}
+	return b, nil
+}
+
+// FetchAll fetches links with at most workers requests in flight, calling
+// done as each of them completes. It returns once all links are fetched, or
+// ctx is done.
+func (f *imageFetcher) FetchAll(ctx context.Context, links []string, workers int, done func(link string, b []byte, err error)) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	for _, link := range links {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+		wg.Add(1)
+		go func(link string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			b, err := f.Fetch(ctx, link)
+			done(link, b, err)
+		}(link)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,199 @@
+package senpai
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testPNG = []byte("\x89PNG\r\n\x1a\nnot really")
+
+func TestImageFetch(t *testing.T) {
+	var hits atomic.Int32
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprintf(w, `<html><head><meta property="og:image" content="%s/image.png"/></head></html>`, srv.URL)
+		case "/video":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<meta property="og:video" content="x"><meta property="og:image" content="x">`)
+		case "/image.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(testPNG)
+		case "/large.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(bytes.Repeat([]byte{0}, maxImageSize+1))
+		case "/file.txt":
+			w.Header().Set("Content-Type", "text/plain")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	f := newImageFetcher(Defaults().Previews, t.TempDir())
+	ctx := context.Background()
+	for _, path := range []string{"/image.png", "/page"} {
+		b, err := f.Fetch(ctx, srv.URL+path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if !bytes.Equal(b, testPNG) {
+			t.Errorf("%s: got %q, expected %q", path, b, testPNG)
+		}
+	}
+	for _, path := range []string{"/video", "/large.png", "/file.txt", "/missing"} {
+		if _, err := f.Fetch(ctx, srv.URL+path); err == nil {
+			t.Errorf("%s: expected an error", path)
+		}
+	}
+
+	n := hits.Load()
+	if b, err := f.Fetch(ctx, srv.URL+"/page"); err != nil || !bytes.Equal(b, testPNG) {
+		t.Errorf("cached page: got %q, %v", b, err)
+	}
+	if hits.Load() != n {
+		t.Errorf("cached page: expected no requests, got %d", hits.Load()-n)
+	}
+}
+
+func TestImageFetchAll(t *testing.T) {
+	const workers = 3
+	var lock sync.Mutex
+	var inFlight, maxInFlight int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		lock.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(testPNG)
+		lock.Lock()
+		inFlight--
+		lock.Unlock()
+	}))
+	defer srv.Close()
+
+	var links []string
+	for i := 0; i < 20; i++ {
+		links = append(links, fmt.Sprintf("%s/%d.png", srv.URL, i))
+	}
+	f := newImageFetcher(Defaults().Previews, "")
+	done := make(map[string]bool)
+	f.FetchAll(context.Background(), links, workers, func(link string, b []byte, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if err != nil {
+			t.Errorf("%s: %v", link, err)
+		}
+		done[link] = true
+	})
+	if len(done) != len(links) {
+		t.Errorf("got %d fetched links, expected %d", len(done), len(links))
+	}
+	if maxInFlight > workers {
+		t.Errorf("got %d requests in flight, expected at most %d", maxInFlight, workers)
+	}
+}
+
+func TestImageFetchPolicy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://denied.example/image.png", http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(testPNG)
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	cfg := Defaults().Previews
+	cfg.Deny = []string{"example"}
+	f := newImageFetcher(cfg, "")
+	if _, err := f.Fetch(ctx, srv.URL+"/image.png"); err != nil {
+		t.Errorf("allowed domain: %v", err)
+	}
+	if _, err := f.Fetch(ctx, "http://denied.example/image.png"); err == nil {
+		t.Errorf("denied domain: expected an error")
+	}
+	if _, err := f.Fetch(ctx, srv.URL+"/redirect"); err == nil {
+		t.Errorf("redirect to denied domain: expected an error")
+	}
+
+	cfg = Defaults().Previews
+	cfg.Allow = []string{"allowed.example"}
+	f = newImageFetcher(cfg, "")
+	if _, err := f.Fetch(ctx, srv.URL+"/image.png"); err == nil {
+		t.Errorf("domain not in allow list: expected an error")
+	}
+
+	// The proxy serves all requests, even for unresolvable hosts.
+	cfg = Defaults().Previews
+	cfg.Proxy, _ = url.Parse(srv.URL)
+	f = newImageFetcher(cfg, "")
+	if b, err := f.Fetch(ctx, "http://img.allowed.example/image.png"); err != nil || !bytes.Equal(b, testPNG) {
+		t.Errorf("proxy: got %q, %v", b, err)
+	}
+}
+
+func TestPreviewCache(t *testing.T) {
+	dir := t.TempDir()
+	// Room for two previews of a few bytes, and three links.
+	c := newPreviewCache(dir, 3*sha256.Size*2+10)
+	for _, link := range []string{"a", "b"} {
+		if err := c.Put(link, []byte("12345")); err != nil {
+			t.Fatalf("put %q: %v", link, err)
+		}
+	}
+	// Links with the same contents share them.
+	if entries, _ := os.ReadDir(filepath.Join(dir, "blobs")); len(entries) != 1 {
+		t.Errorf("got %d blobs, expected 1", len(entries))
+	}
+	// A link to a missing preview, as left by an interrupted write.
+	if err := writeFileAtomic(c.linkPath("orphan"), []byte(hashHex([]byte("missing")))); err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(c.blobPath(hashHex([]byte("12345"))), old, old)
+	if err := c.Put("c", []byte("abcdef")); err != nil {
+		t.Fatalf("put %q: %v", "c", err)
+	}
+	for _, tc := range []struct {
+		link     string
+		expected string
+	}{
+		{"a", ""},
+		{"b", ""},
+		{"c", "abcdef"},
+	} {
+		b, ok := c.Get(tc.link)
+		if ok != (tc.expected != "") || string(b) != tc.expected {
+			t.Errorf("get %q: got %q, %v, expected %q", tc.link, b, ok, tc.expected)
+		}
+	}
+	// The links to the removed and missing previews are removed too.
+	if entries, _ := os.ReadDir(filepath.Join(dir, "links")); len(entries) != 1 {
+		t.Errorf("got %d links, expected 1", len(entries))
+	}
+	if err := c.Put("d", bytes.Repeat([]byte("x"), 3*sha256.Size*2)); err == nil {
+		t.Errorf("put larger than the cache: expected an error")
+	}
+}
@@ -0,0 +1,97 @@
+package senpai
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"strings"
+
+	"git.sr.ht/~delthas/senpai/ui"
+)
+
+const (
+	maxInputHistory     = 1000 // entries kept in the saved input history
+	maxInputHistoryText = 4096 // bytes of the longest saved entry
+)
+
+// patternSecret matches the commands that carry a password, such as
+// "/msg NickServ IDENTIFY hunter2" or "/oper admin hunter2". Messages merely
+// mentioning passwords are kept.
+var patternSecret = regexp.MustCompile(`(?i)^/(?:(?:msg|query|quote|raw)\s+(?:privmsg\s+)?\S*serv\s+:?(?:identify|id|register|ghost|recover|regain|release|set\s+password)\b|(?:quote|raw)\s+(?:pass|oper|authenticate)\b|oper\b|pass\b)`)
+
+// isSecret reports whether an entry of the input history looks like it
+// contains a password, to keep it out of the saved history.
+func isSecret(entry ui.HistoryEntry) bool {
+	if strings.HasSuffix(strings.ToLower(entry.Buffer), "serv") {
+		// Messages to services, e.g. "IDENTIFY hunter2" in the NickServ buffer.
+		return true
+	}
+	return patternSecret.MatchString(entry.Text)
+}
+
+type inputHistoryEntry struct {
+	Network string `json:"network"`
+	Buffer  string `json:"buffer"`
+	Text    string `json:"text"`
+}
+
+// LoadInputHistory adds the input history saved at path by SaveInputHistory
+// before the messages and commands sent so far. The history is then saved
+// there as entries are added.
+func (app *App) LoadInputHistory(path string) error {
+	app.inputHistoryPath = path
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var saved []inputHistoryEntry
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return err
+	}
+	entries := make([]ui.HistoryEntry, 0, len(saved))
+	for _, e := range saved {
+		entries = append(entries, ui.HistoryEntry{
+			NetID:  e.Network,
+			Buffer: e.Buffer,
+			Text:   e.Text,
+		})
+	}
+	app.win.AddInputHistory(entries)
+	return nil
+}
+
+// saveInputHistory saves the input history where it was loaded from, as it
+// grows, so that it survives crashes.
+func (app *App) saveInputHistory() {
+	if app.inputHistoryPath == "" {
+		return
+	}
+	// Errors are reported when saving the history on exit.
+	app.SaveInputHistory(app.inputHistoryPath)
+}
+
+// SaveInputHistory saves the last entries of the input history at path,
+// except those that look like they contain a password.
+func (app *App) SaveInputHistory(path string) error {
+	var saved []inputHistoryEntry
+	for _, e := range app.win.InputHistory() {
+		if len(e.Text) > maxInputHistoryText || isSecret(e) {
+			continue
+		}
+		saved = append(saved, inputHistoryEntry{
+			Network: e.NetID,
+			Buffer:  e.Buffer,
+			Text:    e.Text,
+		})
+	}
+	if len(saved) > maxInputHistory {
+		saved = saved[len(saved)-maxInputHistory:]
+	}
+	b, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
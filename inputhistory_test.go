@@ -0,0 +1,30 @@
+package senpai
+
+import (
+	"testing"
+
+	"git.sr.ht/~delthas/senpai/ui"
+)
+
+func TestIsSecret(t *testing.T) {
+	for _, tc := range []struct {
+		entry    ui.HistoryEntry
+		expected bool
+	}{
+		{ui.HistoryEntry{Buffer: "#senpai", Text: "hello world"}, false},
+		{ui.HistoryEntry{Buffer: "#senpai", Text: "/msg NickServ IDENTIFY hunter2"}, true},
+		{ui.HistoryEntry{Buffer: "#senpai", Text: "/quote PRIVMSG nickserv :ghost nick hunter2"}, true},
+		{ui.HistoryEntry{Buffer: "#senpai", Text: "/oper admin hunter2"}, true},
+		{ui.HistoryEntry{Buffer: "#senpai", Text: "/msg alice hi"}, false},
+		{ui.HistoryEntry{Buffer: "NickServ", Text: "id hunter2"}, true},
+		{ui.HistoryEntry{Buffer: "#senpai", Text: "/msg NickServ SET PASSWORD hunter2"}, true},
+		{ui.HistoryEntry{Buffer: "#senpai", Text: "/quote PASS hunter2"}, true},
+		{ui.HistoryEntry{Buffer: "#senpai", Text: "/msg NickServ INFO alice"}, false},
+		{ui.HistoryEntry{Buffer: "#senpai", Text: "I forgot my password again"}, false},
+		{ui.HistoryEntry{Buffer: "#senpai", Text: "don't forget to register for the meetup"}, false},
+	} {
+		if actual := isSecret(tc.entry); actual != tc.expected {
+			t.Errorf("%+v: got %v, expected %v", tc.entry, actual, tc.expected)
+		}
+	}
+}
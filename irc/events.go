@@ -85,12 +85,35 @@ type InviteEvent struct {
 
 type MessageEvent struct {
 	User            string
+	Prefix          Prefix // sender, with its user and host if sent.
 	Target          string
 	TargetIsChannel bool
 	TargetPrefix    string
 	Command         string
 	Content         string
 	Time            time.Time
+	ID              string // msgid of the message, if any.
+	ReplyTo         string // msgid of the message this is a reply to, if any.
+	Raw             string // raw IRC message, if known.
+}
+
+type ReactionEvent struct {
+	User            string
+	Target          string
+	TargetIsChannel bool
+	ID              string // msgid of the message reacted to.
+	Reaction        string
+	Remove          bool // whether the reaction is withdrawn.
+	Time            time.Time
+}
+
+type RedactEvent struct {
+	User            string
+	Target          string
+	TargetIsChannel bool
+	ID              string // msgid of the deleted message.
+	Reason          string
+	Time            time.Time
 }
 
 type ListItem struct {
@@ -123,6 +146,7 @@ type MetadataChangeEvent struct {
 	Target string
 	Pinned bool
 	Muted  bool
+	Notify string
 }
 
 type BouncerNetworkEvent struct {
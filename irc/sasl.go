@@ -0,0 +1,202 @@
+package irc
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type SASLClient interface {
+	Early() bool
+	Handshake() (mech string)
+	Respond(challenge string) (res string, err error)
+}
+
+type SASLPlain struct {
+	Username string
+	Password string
+}
+
+func (auth *SASLPlain) Early() bool {
+	return true
+}
+
+func (auth *SASLPlain) Handshake() (mech string) {
+	mech = "PLAIN"
+	return
+}
+
+func (auth *SASLPlain) Respond(challenge string) (res string, err error) {
+	if challenge != "+" {
+		err = errors.New("unexpected challenge")
+		return
+	}
+
+	user := []byte(auth.Username)
+	pass := []byte(auth.Password)
+	payload := bytes.Join([][]byte{user, user, pass}, []byte{0})
+	res = base64.StdEncoding.EncodeToString(payload)
+
+	return
+}
+
+// SASLExternal authenticates with credentials established outside of SASL,
+// typically a TLS client certificate.
+type SASLExternal struct{}
+
+func (auth *SASLExternal) Early() bool {
+	return true
+}
+
+func (auth *SASLExternal) Handshake() (mech string) {
+	mech = "EXTERNAL"
+	return
+}
+
+func (auth *SASLExternal) Respond(challenge string) (res string, err error) {
+	if challenge != "+" {
+		err = errors.New("unexpected challenge")
+		return
+	}
+
+	// Empty authorization identity: use the one of the certificate.
+	res = "+"
+	return
+}
+
+// SASLScramSHA256 implements the SCRAM-SHA-256 mechanism (RFC 7677), which
+// does not send the password to the server.
+type SASLScramSHA256 struct {
+	Username string
+	Password string
+
+	step            int
+	clientNonce     string
+	clientFirstBare string
+	serverSignature []byte
+}
+
+func (auth *SASLScramSHA256) Early() bool {
+	// The mechanism needs several round trips.
+	return false
+}
+
+func (auth *SASLScramSHA256) Handshake() (mech string) {
+	mech = "SCRAM-SHA-256"
+	auth.step = 0
+	auth.clientNonce = ""
+	return
+}
+
+func (auth *SASLScramSHA256) Respond(challenge string) (res string, err error) {
+	auth.step++
+	switch auth.step {
+	case 1:
+		if challenge != "+" {
+			return "", errors.New("unexpected challenge")
+		}
+		if auth.clientNonce == "" { // set beforehand by tests
+			nonce := make([]byte, 18)
+			if _, err := rand.Read(nonce); err != nil {
+				return "", err
+			}
+			auth.clientNonce = base64.RawStdEncoding.EncodeToString(nonce)
+		}
+		user := strings.NewReplacer("=", "=3D", ",", "=2C").Replace(auth.Username)
+		auth.clientFirstBare = "n=" + user + ",r=" + auth.clientNonce
+		return base64.StdEncoding.EncodeToString([]byte("n,," + auth.clientFirstBare)), nil
+	case 2:
+		serverFirst, err := base64.StdEncoding.DecodeString(challenge)
+		if err != nil {
+			return "", fmt.Errorf("invalid challenge: %v", err)
+		}
+		attrs := scramAttrs(string(serverFirst))
+		nonce := attrs["r"]
+		if !strings.HasPrefix(nonce, auth.clientNonce) || len(nonce) == len(auth.clientNonce) {
+			return "", errors.New("invalid server nonce")
+		}
+		salt, err := base64.StdEncoding.DecodeString(attrs["s"])
+		if err != nil {
+			return "", fmt.Errorf("invalid salt: %v", err)
+		}
+		iter, err := strconv.Atoi(attrs["i"])
+		if err != nil || iter <= 0 {
+			return "", errors.New("invalid iteration count")
+		}
+
+		saltedPassword := pbkdf2SHA256([]byte(auth.Password), salt, iter)
+		clientKey := hmacSHA256(saltedPassword, []byte("Client Key"))
+		storedKey := sha256.Sum256(clientKey)
+		clientFinal := "c=" + base64.StdEncoding.EncodeToString([]byte("n,,")) + ",r=" + nonce
+		authMessage := []byte(auth.clientFirstBare + "," + string(serverFirst) + "," + clientFinal)
+		clientSignature := hmacSHA256(storedKey[:], authMessage)
+		proof := make([]byte, len(clientKey))
+		subtle.XORBytes(proof, clientKey, clientSignature)
+		serverKey := hmacSHA256(saltedPassword, []byte("Server Key"))
+		auth.serverSignature = hmacSHA256(serverKey, authMessage)
+
+		clientFinal += ",p=" + base64.StdEncoding.EncodeToString(proof)
+		return base64.StdEncoding.EncodeToString([]byte(clientFinal)), nil
+	case 3:
+		serverFinal, err := base64.StdEncoding.DecodeString(challenge)
+		if err != nil {
+			return "", fmt.Errorf("invalid challenge: %v", err)
+		}
+		attrs := scramAttrs(string(serverFinal))
+		if e, ok := attrs["e"]; ok {
+			return "", fmt.Errorf("server error: %v", e)
+		}
+		signature, err := base64.StdEncoding.DecodeString(attrs["v"])
+		if err != nil || !hmac.Equal(signature, auth.serverSignature) {
+			return "", errors.New("invalid server signature")
+		}
+		return "+", nil
+	default:
+		return "", errors.New("unexpected challenge")
+	}
+}
+
+// scramAttrs parses the comma-separated attributes of a SCRAM message.
+func scramAttrs(msg string) map[string]string {
+	attrs := make(map[string]string)
+	for _, kv := range strings.Split(msg, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			continue
+		}
+		attrs[k] = v
+	}
+	return attrs
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// pbkdf2SHA256 derives a single-block key (RFC 8018) from password, as needed
+// by SCRAM-SHA-256.
+func pbkdf2SHA256(password, salt []byte, iter int) []byte {
+	prf := hmac.New(sha256.New, password)
+	prf.Write(salt)
+	prf.Write(binary.BigEndian.AppendUint32(nil, 1))
+	u := prf.Sum(nil)
+	key := make([]byte, len(u))
+	copy(key, u)
+	for i := 1; i < iter; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		subtle.XORBytes(key, key, u)
+	}
+	return key
+}
@@ -0,0 +1,119 @@
+package irc
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestPBKDF2SHA256(t *testing.T) {
+	for _, tc := range []struct {
+		password string
+		salt     string
+		iter     int
+		expected string
+	}{
+		{"password", "salt", 1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{"password", "salt", 4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+	} {
+		key := pbkdf2SHA256([]byte(tc.password), []byte(tc.salt), tc.iter)
+		if actual := hex.EncodeToString(key); actual != tc.expected {
+			t.Errorf("%q, %q, %d: got %s, expected %s", tc.password, tc.salt, tc.iter, actual, tc.expected)
+		}
+	}
+}
+
+// TestSASLScramSHA256 runs the example exchange of RFC 7677, section 3.
+func TestSASLScramSHA256(t *testing.T) {
+	auth := &SASLScramSHA256{
+		Username: "user",
+		Password: "pencil",
+	}
+	if mech := auth.Handshake(); mech != "SCRAM-SHA-256" {
+		t.Fatalf("got mechanism %q", mech)
+	}
+	auth.clientNonce = "rOprNGfwEbeRWgbNEkqO"
+
+	steps := []struct {
+		challenge string
+		response  string
+	}{{
+		challenge: "+",
+		response:  "n,,n=user,r=rOprNGfwEbeRWgbNEkqO",
+	}, {
+		challenge: "r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+		response:  "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=",
+	}, {
+		challenge: "v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=",
+		response:  "+",
+	}}
+	for i, step := range steps {
+		challenge := step.challenge
+		if challenge != "+" {
+			challenge = base64.StdEncoding.EncodeToString([]byte(challenge))
+		}
+		res, err := auth.Respond(challenge)
+		if err != nil {
+			t.Fatalf("step %d: %v", i+1, err)
+		}
+		if res != "+" {
+			b, err := base64.StdEncoding.DecodeString(res)
+			if err != nil {
+				t.Fatalf("step %d: invalid response: %v", i+1, err)
+			}
+			res = string(b)
+		}
+		if res != step.response {
+			t.Errorf("step %d: got %q, expected %q", i+1, res, step.response)
+		}
+	}
+
+	auth.Handshake()
+	auth.clientNonce = "rOprNGfwEbeRWgbNEkqO"
+	auth.Respond("+")
+	auth.Respond(base64.StdEncoding.EncodeToString([]byte(steps[1].challenge)))
+	forged := base64.StdEncoding.EncodeToString([]byte("v=" + base64.StdEncoding.EncodeToString(make([]byte, 32))))
+	if _, err := auth.Respond(forged); err == nil {
+		t.Errorf("expected an error for an invalid server signature")
+	}
+}
+
+func TestAuthenticateChunks(t *testing.T) {
+	for _, tc := range []struct {
+		len      int
+		expected []int // lengths of the AUTHENTICATE payloads, 0 for "+"
+	}{
+		{0, []int{0}},
+		{399, []int{399}},
+		{400, []int{400, 0}},
+		{900, []int{400, 400, 100}},
+	} {
+		out := make(chan Message, 8)
+		s := &Session{out: out}
+		s.authenticate(strings.Repeat("a", tc.len))
+		close(out)
+		var lens []int
+		for msg := range out {
+			if msg.Command != "AUTHENTICATE" || len(msg.Params) != 1 {
+				t.Fatalf("%d: unexpected message %+v", tc.len, msg)
+			}
+			if msg.Params[0] == "+" {
+				lens = append(lens, 0)
+			} else {
+				lens = append(lens, len(msg.Params[0]))
+			}
+		}
+		if len(lens) != len(tc.expected) {
+			t.Errorf("%d: got payloads of lengths %v, expected %v", tc.len, lens, tc.expected)
+			continue
+		}
+		for i := range lens {
+			if lens[i] != tc.expected[i] {
+				t.Errorf("%d: got payloads of lengths %v, expected %v", tc.len, lens, tc.expected)
+				break
+			}
+		}
+	}
+}
@@ -1,9 +1,6 @@
 package irc
 
 import (
-	"bytes"
-	"encoding/base64"
-	"errors"
 	"fmt"
 	"sort"
 	"strconv"
@@ -15,40 +12,6 @@ import (
 	"golang.org/x/time/rate"
 )
 
-type SASLClient interface {
-	Early() bool
-	Handshake() (mech string)
-	Respond(challenge string) (res string, err error)
-}
-
-type SASLPlain struct {
-	Username string
-	Password string
-}
-
-func (auth *SASLPlain) Early() bool {
-	return true
-}
-
-func (auth *SASLPlain) Handshake() (mech string) {
-	mech = "PLAIN"
-	return
-}
-
-func (auth *SASLPlain) Respond(challenge string) (res string, err error) {
-	if challenge != "+" {
-		err = errors.New("unexpected challenge")
-		return
-	}
-
-	user := []byte(auth.Username)
-	pass := []byte(auth.Password)
-	payload := bytes.Join([][]byte{user, user, pass}, []byte{0})
-	res = base64.StdEncoding.EncodeToString(payload)
-
-	return
-}
-
 // SupportedCapabilities is the set of capabilities supported by this library.
 // Value is false if the cap is deferred (to work around some daemons agfressive rate pre-conn-reg backlog limiting)
 var SupportedCapabilities = map[string]bool{
@@ -67,6 +30,8 @@ var SupportedCapabilities = map[string]bool{
 	"standard-replies": true,
 
 	"draft/chathistory":               true,
+	"draft/message-redaction":         true,
+	"draft/multiline":                 true,
 	"draft/event-playback":            true,
 	"draft/metadata-2":                true,
 	"draft/read-marker":               true,
@@ -108,11 +73,24 @@ type Channel struct {
 	complete bool // whether this structure is fully initialized.
 }
 
+// multilineBatch is an incoming draft/multiline batch being processed.
+type multilineBatch struct {
+	start   Message // BATCH message that started the batch, holding its tags.
+	target  string
+	command string
+	content string
+	empty   bool // whether no message was received yet.
+}
+
 type Metadata struct {
 	Pinned bool
 	Muted  bool
+	Notify string // notification level, empty if unset
 }
 
+// metadataNotify is the metadata key of the notification level of a target.
+const metadataNotify = "senpai.delthas.fr/notify"
+
 // SessionParams defines how to connect to an IRC server.
 type SessionParams struct {
 	Nickname string
@@ -120,6 +98,11 @@ type SessionParams struct {
 	RealName string
 	NetID    string
 	Auth     SASLClient
+
+	// Standalone is whether the session is connected to an independent
+	// server rather than to a bouncer network. NetID then only identifies the
+	// session, and is not bound to.
+	Standalone bool
 }
 
 type Session struct {
@@ -129,15 +112,18 @@ type Session struct {
 	typings      *Typings               // incoming typing notifications.
 	typingStamps map[string]typingStamp // user typing instants.
 
-	nick     string
-	nickCf   string // casemapped nickname.
-	user     string
-	real     string
-	acct     string
-	host     string
-	netID    string
-	netAttrs map[string]string
-	auth     SASLClient
+	nick       string
+	nickCf     string // casemapped nickname.
+	user       string
+	real       string
+	acct       string
+	host       string
+	netID      string
+	bound      bool // whether the session is bound to the bouncer network netID.
+	standalone bool // whether the session is connected to an independent server.
+	netAttrs   map[string]string
+	auth       SASLClient
+	authBuf    string // AUTHENTICATE challenge chunks being received.
 
 	availableCaps map[string]string
 	enabledCaps   map[string]struct{}
@@ -163,19 +149,21 @@ type Session struct {
 	clientTagListIsAllow bool // whether clientTagList is an allowlist (true) or a blocklist (false)
 	clientTagList        map[string]struct{}
 
-	users                  map[string]*User        // known users.
-	channels               map[string]Channel      // joined channels.
-	metadata               map[string]Metadata     // known target metadata.
-	chBatches              map[string]HistoryEvent // channel history batches being processed.
-	chReqs                 map[string]struct{}     // set of targets for which history is currently requested.
-	targetsBatchID         string                  // ID of the channel history targets batch being processed.
-	targetsBatch           HistoryTargetsEvent     // channel history targets batch being processed.
-	searchBatchID          string                  // ID of the search targets batch being processed.
-	searchBatch            SearchEvent             // search batch being processed.
-	bouncerNetworksBatchID string                  // ID of the bouncer network batch being processed.
-	bouncerNetworksBatch   BouncerNetworkListEvent // bouncer network batch being processed.
-	monitors               map[string]struct{}     // set of users we want to monitor (and keep even if they are disconnected).
-	pendingList            ListEvent               // current list response being received (flushed on list end).
+	users                  map[string]*User          // known users.
+	channels               map[string]Channel        // joined channels.
+	metadata               map[string]Metadata       // known target metadata.
+	chBatches              map[string]HistoryEvent   // channel history batches being processed.
+	mlBatches              map[string]multilineBatch // multiline message batches being processed.
+	mlBatchCount           int                       // number of multiline batches sent, used for their IDs.
+	chReqs                 map[string]struct{}       // set of targets for which history is currently requested.
+	targetsBatchID         string                    // ID of the channel history targets batch being processed.
+	targetsBatch           HistoryTargetsEvent       // channel history targets batch being processed.
+	searchBatchID          string                    // ID of the search targets batch being processed.
+	searchBatch            SearchEvent               // search batch being processed.
+	bouncerNetworksBatchID string                    // ID of the bouncer network batch being processed.
+	bouncerNetworksBatch   BouncerNetworkListEvent   // bouncer network batch being processed.
+	monitors               map[string]struct{}       // set of users we want to monitor (and keep even if they are disconnected).
+	pendingList            ListEvent                 // current list response being received (flushed on list end).
 
 	pendingChannels map[string]time.Time // set of join requests stamps for channels.
 
@@ -193,6 +181,8 @@ func NewSession(out chan<- Message, params SessionParams) *Session {
 		user:            params.Username,
 		real:            params.RealName,
 		netID:           params.NetID,
+		bound:           params.NetID != "" && !params.Standalone,
+		standalone:      params.Standalone,
 		auth:            params.Auth,
 		availableCaps:   map[string]string{},
 		enabledCaps:     map[string]struct{}{},
@@ -208,6 +198,7 @@ func NewSession(out chan<- Message, params SessionParams) *Session {
 		channels:        map[string]Channel{},
 		metadata:        map[string]Metadata{},
 		chBatches:       map[string]HistoryEvent{},
+		mlBatches:       map[string]multilineBatch{},
 		chReqs:          map[string]struct{}{},
 		monitors:        map[string]struct{}{},
 		pendingChannels: map[string]time.Time{},
@@ -215,7 +206,7 @@ func NewSession(out chan<- Message, params SessionParams) *Session {
 
 	s.out <- NewMessage("CAP", "LS", "302")
 	for capability, immediate := range SupportedCapabilities {
-		if immediate || s.netID != "" {
+		if immediate || s.bound {
 			s.out <- NewMessage("CAP", "REQ", capability)
 		}
 	}
@@ -228,7 +219,7 @@ func NewSession(out chan<- Message, params SessionParams) *Session {
 		if err != nil {
 			s.out <- NewMessage("AUTHENTICATE", "*")
 		} else {
-			s.out <- NewMessage("AUTHENTICATE", res)
+			s.authenticate(res)
 		}
 		s.auth = nil
 	}
@@ -323,6 +314,29 @@ func (s *Session) Users() []string {
 	return users
 }
 
+// UserPrefix returns the nick, user and host of the given user, as far as
+// they are known.
+func (s *Session) UserPrefix(nick string) Prefix {
+	if u, ok := s.users[s.Casemap(nick)]; ok && u.Name != nil {
+		return *u.Name
+	}
+	return Prefix{Name: nick}
+}
+
+// IsMember reports whether nick is a member of channel.
+func (s *Session) IsMember(channel, nick string) bool {
+	c, ok := s.channels[s.Casemap(channel)]
+	if !ok {
+		return false
+	}
+	u, ok := s.users[s.Casemap(nick)]
+	if !ok {
+		return false
+	}
+	_, ok = c.Members[u]
+	return ok
+}
+
 // Names returns the list of users in the given target, or nil if the target
 // is not a known channel or nick in the session.
 // The list is sorted according to member name.
@@ -512,6 +526,117 @@ func splitChunks(s string, chunkLen int) (chunks []string) {
 }
 
 func (s *Session) PrivMsg(target, content string) {
+	s.privMsg(target, content, nil)
+}
+
+// CanReply reports whether replies to specific messages can be sent to the
+// server, with the +draft/reply client tag.
+func (s *Session) CanReply() bool {
+	return s.HasCapability("message-tags") && s.CanSendTag("draft/reply")
+}
+
+// PrivMsgReply sends content to target as a reply to the message of ID
+// replyTo. It falls back to a regular message if replies are not supported.
+func (s *Session) PrivMsgReply(target, content, replyTo string) {
+	if replyTo == "" || !s.CanReply() {
+		s.privMsg(target, content, nil)
+		return
+	}
+	s.privMsg(target, content, map[string]string{
+		"+draft/reply": replyTo,
+	})
+}
+
+// multilineLimits returns the maximum number of bytes and lines of
+// draft/multiline batches, or ok=false if they are not supported.
+func (s *Session) multilineLimits() (maxBytes, maxLines int, ok bool) {
+	if !s.HasCapability("draft/multiline") || !s.HasCapability("batch") {
+		return 0, 0, false
+	}
+	for _, kv := range strings.Split(s.availableCaps["draft/multiline"], ",") {
+		k, v, _ := strings.Cut(kv, "=")
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			continue
+		}
+		switch k {
+		case "max-bytes":
+			maxBytes = n
+		case "max-lines":
+			maxLines = n
+		}
+	}
+	return maxBytes, maxLines, maxBytes > 0
+}
+
+func (s *Session) privMsg(target, content string, tags map[string]string) {
+	if strings.Contains(content, "\n") {
+		s.privMsgMultiline(target, content, tags)
+		return
+	}
+
+	chunks := splitChunks(content, s.maxMessageLen(target))
+	for _, chunk := range chunks {
+		msg := NewMessage("PRIVMSG", target, chunk)
+		for k, v := range tags {
+			msg = msg.WithTag(k, v)
+		}
+		s.out <- msg
+	}
+	targetCf := s.Casemap(target)
+	delete(s.typingStamps, targetCf)
+}
+
+// privMsgMultiline sends content, made of several lines, in a single
+// draft/multiline batch if possible, or as one message per line otherwise.
+func (s *Session) privMsgMultiline(target, content string, tags map[string]string) {
+	lines := strings.Split(content, "\n")
+	maxLen := s.maxMessageLen(target)
+
+	maxBytes, maxLines, ok := s.multilineLimits()
+	if ok {
+		var n int
+		for _, line := range lines {
+			n += len(splitChunks(line, maxLen))
+		}
+		ok = len(content) <= maxBytes && (maxLines <= 0 || n <= maxLines)
+	}
+	if !ok {
+		for _, line := range lines {
+			if line == "" {
+				continue
+			}
+			s.privMsg(target, line, tags)
+			// Only the first message holds the tags (e.g. the reply).
+			tags = nil
+		}
+		return
+	}
+
+	s.mlBatchCount++
+	ref := fmt.Sprintf("ml%d", s.mlBatchCount)
+	start := NewMessage("BATCH", "+"+ref, "draft/multiline", target)
+	for k, v := range tags {
+		start = start.WithTag(k, v)
+	}
+	s.out <- start
+	for _, line := range lines {
+		for i, chunk := range splitChunks(line, maxLen) {
+			msg := NewMessage("PRIVMSG", target, chunk).WithTag("batch", ref)
+			if i > 0 {
+				msg = msg.WithTag("draft/multiline-concat", "")
+			}
+			s.out <- msg
+		}
+	}
+	s.out <- NewMessage("BATCH", "-"+ref)
+	targetCf := s.Casemap(target)
+	delete(s.typingStamps, targetCf)
+}
+
+// maxMessageLen returns the maximum length of the content of a PRIVMSG sent
+// to target, so that the message does not get truncated when relayed.
+func (s *Session) maxMessageLen(target string) int {
 	hostLen := len(s.host)
 	if hostLen == 0 {
 		hostLen = len("255.255.255.255")
@@ -522,12 +647,7 @@ func (s *Session) PrivMsg(target, content string) {
 		len(s.user) -
 		hostLen -
 		len(target)
-	chunks := splitChunks(content, maxMessageLen)
-	for _, chunk := range chunks {
-		s.out <- NewMessage("PRIVMSG", target, chunk)
-	}
-	targetCf := s.Casemap(target)
-	delete(s.typingStamps, targetCf)
+	return maxMessageLen
 }
 
 func (s *Session) Typing(target string) {
@@ -575,6 +695,39 @@ func (s *Session) TypingStop(target string) {
 	s.out <- NewMessage("TAGMSG", target).WithTag("+typing", "done")
 }
 
+// CanReact reports whether reactions to messages can be sent to the server,
+// with the +draft/react client tag.
+func (s *Session) CanReact() bool {
+	return s.HasCapability("message-tags") && s.CanSendTag("draft/react")
+}
+
+// React sends a reaction to the message of ID msgid sent to target.
+func (s *Session) React(target, msgid, reaction string) {
+	if !s.CanReact() {
+		return
+	}
+	s.out <- NewMessage("TAGMSG", target).
+		WithTag("+draft/reply", msgid).
+		WithTag("+draft/react", reaction)
+}
+
+// CanRedact reports whether messages can be deleted with REDACT.
+func (s *Session) CanRedact() bool {
+	return s.HasCapability("draft/message-redaction")
+}
+
+// Redact deletes the message of ID msgid sent to target.
+func (s *Session) Redact(target, msgid, reason string) {
+	if !s.CanRedact() {
+		return
+	}
+	if reason != "" {
+		s.out <- NewMessage("REDACT", target, msgid, reason)
+	} else {
+		s.out <- NewMessage("REDACT", target, msgid)
+	}
+}
+
 func (s *Session) ReadGet(target string) {
 	if _, ok := s.enabledCaps["draft/read-marker"]; ok {
 		s.out <- NewMessage("MARKREAD", target)
@@ -605,6 +758,31 @@ func (s *Session) MutedSet(target string, muted bool) (ok bool) {
 	return
 }
 
+// NotifyGet returns the notification level of target, or an empty string if
+// it is unset.
+func (s *Session) NotifyGet(target string) string {
+	return s.metadata[s.Casemap(target)].Notify
+}
+
+// NotifySet sets the notification level of target, or unsets it if level is
+// empty. It returns false if the server does not store it. Otherwise, the
+// level is effective at once, before the server echoes it back.
+func (s *Session) NotifySet(target string, level string) (ok bool) {
+	if _, ok = s.metadataSubs[metadataNotify]; !ok {
+		return
+	}
+	targetCf := s.Casemap(target)
+	m := s.metadata[targetCf]
+	m.Notify = level
+	s.metadata[targetCf] = m
+	if level == "" {
+		s.out <- NewMessage("METADATA", target, "SET", metadataNotify)
+	} else {
+		s.out <- NewMessage("METADATA", target, "SET", metadataNotify, level)
+	}
+	return
+}
+
 func (s *Session) PinnedGet(target string) bool {
 	return s.metadata[s.Casemap(target)].Pinned
 }
@@ -699,6 +877,28 @@ func (r *HistoryRequest) Before(t time.Time) {
 	r.doRequest()
 }
 
+// Around requests the messages around t.
+func (r *HistoryRequest) Around(t time.Time) {
+	r.command = "AROUND"
+	r.bounds = []string{formatTimestamp(t)}
+	r.doRequest()
+}
+
+// AroundID requests the messages around the message with the given ID.
+func (r *HistoryRequest) AroundID(id string) {
+	r.command = "AROUND"
+	r.bounds = []string{"msgid=" + id}
+	r.doRequest()
+}
+
+// Between requests the messages between start and end, starting from start:
+// if start is after end, the messages are the latest ones before start.
+func (r *HistoryRequest) Between(start, end time.Time) {
+	r.command = "BETWEEN"
+	r.bounds = []string{formatTimestamp(start), formatTimestamp(end)}
+	r.doRequest()
+}
+
 func (r *HistoryRequest) Latest() {
 	r.command = "LATEST"
 	r.bounds = []string{"*"}
@@ -712,6 +912,13 @@ func (r *HistoryRequest) Targets(start time.Time, end time.Time) {
 	r.doRequest()
 }
 
+// HistoryPending reports whether history is being requested for target, in
+// which case new history requests for it are ignored.
+func (s *Session) HistoryPending(target string) bool {
+	_, ok := s.chReqs[s.casemap(target)]
+	return ok
+}
+
 func (s *Session) NewHistoryRequest(target string) *HistoryRequest {
 	return &HistoryRequest{
 		s:      s,
@@ -778,7 +985,23 @@ func (s *Session) handleUnregistered(msg Message) (Event, error) {
 
 func (s *Session) handleRegistered(msg Message) (Event, error) {
 	if id, ok := msg.Tags["batch"]; ok {
-		if id == s.targetsBatchID {
+		if b, ok := s.mlBatches[id]; ok {
+			var content string
+			if err := msg.ParseParams(nil, &content); err != nil {
+				return nil, err
+			}
+			if b.empty {
+				b.command = msg.Command
+				b.content = content
+				b.empty = false
+			} else if _, ok := msg.Tags["draft/multiline-concat"]; ok {
+				b.content += content
+			} else {
+				b.content += "\n" + content
+			}
+			s.mlBatches[id] = b
+			return nil, nil
+		} else if id == s.targetsBatchID {
 			var target, timestamp string
 			if err := msg.ParseParams(nil, &target, &timestamp); err != nil {
 				return nil, err
@@ -834,12 +1057,25 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 		if err := msg.ParseParams(&payload); err != nil {
 			return nil, err
 		}
+		if len(payload) == authenticateChunkLen {
+			// More chunks follow.
+			s.authBuf += payload
+			break
+		}
+		if s.authBuf != "" {
+			if payload != "+" {
+				payload = s.authBuf + payload
+			} else {
+				payload = s.authBuf
+			}
+			s.authBuf = ""
+		}
 
 		res, err := s.auth.Respond(payload)
 		if err != nil {
 			s.out <- NewMessage("AUTHENTICATE", "*")
 		} else {
-			s.out <- NewMessage("AUTHENTICATE", res)
+			s.authenticate(res)
 		}
 	case rplLoggedin:
 		var nuh string
@@ -968,7 +1204,7 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 				if !ok {
 					continue
 				}
-				if subcommand == "LS" && (immediate || s.netID != "") {
+				if subcommand == "LS" && (immediate || s.bound) {
 					// Already sent CAP, ignore
 					continue
 				}
@@ -1410,15 +1646,22 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 		}
 		return ev, nil
 	case "TAGMSG":
-		if playback {
-			return nil, nil
-		}
-
 		var target string
 		if err := msg.ParseParams(&target); err != nil {
 			return nil, err
 		}
 
+		if reaction, ok := msg.Tags["+draft/react"]; ok {
+			return s.newReactionEvent(msg, target, reaction, false), nil
+		}
+		if reaction, ok := msg.Tags["+draft/unreact"]; ok {
+			return s.newReactionEvent(msg, target, reaction, true), nil
+		}
+
+		if playback {
+			return nil, nil
+		}
+
 		targetCf := s.casemap(target)
 		nickCf := s.casemap(msg.Prefix.Name)
 
@@ -1470,9 +1713,30 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 			case "soju.im/bouncer-networks":
 				s.bouncerNetworksBatchID = id
 				s.bouncerNetworksBatch = BouncerNetworkListEvent{}
+			case "draft/multiline":
+				var target string
+				if err := msg.ParseParams(nil, nil, &target); err != nil {
+					return nil, err
+				}
+				s.mlBatches[id] = multilineBatch{
+					start:  msg,
+					target: target,
+					empty:  true,
+				}
 			}
 		} else {
-			if b, ok := s.chBatches[id]; ok {
+			if b, ok := s.mlBatches[id]; ok {
+				delete(s.mlBatches, id)
+				if b.empty {
+					return nil, nil
+				}
+				return s.handleMessageRegistered(Message{
+					Tags:    b.start.Tags,
+					Prefix:  b.start.Prefix,
+					Command: b.command,
+					Params:  []string{b.target, b.content},
+				}, playback)
+			} else if b, ok := s.chBatches[id]; ok {
 				delete(s.chBatches, id)
 				delete(s.chReqs, s.Casemap(b.Target))
 				return b, nil
@@ -1558,14 +1822,17 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 			Timestamp: t,
 		}, nil
 	case "METADATA":
-		// METADATA <Target> <Key> <Visibility> <Value>
-		if len(msg.Params) < 4 {
+		// METADATA <Target> <Key> <Visibility> [<Value>]
+		if len(msg.Params) < 3 {
 			break
 		}
 		var target, key, value string
-		if err := msg.ParseParams(&target, &key, nil, &value); err != nil {
+		if err := msg.ParseParams(&target, &key); err != nil {
 			return nil, err
 		}
+		if len(msg.Params) >= 4 {
+			value = msg.Params[3]
+		}
 		targetCf := s.Casemap(target)
 		m := s.metadata[targetCf]
 		switch key {
@@ -1573,12 +1840,15 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 			m.Pinned = value == "1"
 		case "soju.im/muted":
 			m.Muted = value == "1"
+		case metadataNotify:
+			m.Notify = value
 		}
 		s.metadata[targetCf] = m
 		ev := MetadataChangeEvent{
 			Target: target,
 			Pinned: m.Pinned,
 			Muted:  m.Muted,
+			Notify: m.Notify,
 		}
 		return ev, nil
 	case "BOUNCER":
@@ -1607,6 +1877,27 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 		s.out <- NewMessage("PONG", payload)
 	case "ERROR":
 		s.Close()
+	case "REDACT":
+		var target, id string
+		if err := msg.ParseParams(&target, &id); err != nil {
+			return nil, err
+		}
+		var reason string
+		if len(msg.Params) > 2 {
+			reason = msg.Params[2]
+		}
+		ev := RedactEvent{
+			User:   msg.Prefix.Name,
+			Target: target,
+			ID:     id,
+			Reason: reason,
+			Time:   msg.TimeOrNow(),
+		}
+		if c, ok := s.channels[s.Casemap(target)]; ok {
+			ev.Target = c.Name
+			ev.TargetIsChannel = true
+		}
+		return ev, nil
 	case "FAIL", "WARN", "NOTE":
 		var severity Severity
 		var code string
@@ -1618,6 +1909,11 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 		case "KEY_INVALID": // METADATA SUB failed: ignore
 			return nil, nil
 		}
+		if msg.Params[0] == "CHATHISTORY" && len(msg.Params) >= 5 {
+			// FAIL CHATHISTORY <code> <subcommand> <target> ...: no batch
+			// will end the request.
+			delete(s.chReqs, s.casemap(msg.Params[3]))
+		}
 
 		switch msg.Command {
 		case "FAIL":
@@ -2150,11 +2446,15 @@ func (s *Session) newMessageEvent(msg Message) (ev MessageEvent, err error) {
 
 	ev = MessageEvent{
 		User:         msg.Prefix.Name, // TODO correctly casemap
-		Target:       target,          // TODO correctly casemap
+		Prefix:       *msg.Prefix,
+		Target:       target, // TODO correctly casemap
 		TargetPrefix: prefix,
 		Command:      msg.Command,
 		Content:      content,
 		Time:         msg.TimeOrNow(),
+		ID:           msg.Tags["msgid"],
+		ReplyTo:      msg.Tags["+draft/reply"],
+		Raw:          msg.String(),
 	}
 
 	if s.IsMe(target) {
@@ -2172,6 +2472,26 @@ func (s *Session) newMessageEvent(msg Message) (ev MessageEvent, err error) {
 	return ev, nil
 }
 
+func (s *Session) newReactionEvent(msg Message, target, reaction string, remove bool) Event {
+	id := msg.Tags["+draft/reply"]
+	if id == "" || reaction == "" || msg.Prefix == nil {
+		return nil
+	}
+	ev := ReactionEvent{
+		User:     msg.Prefix.Name,
+		Target:   target,
+		ID:       id,
+		Reaction: reaction,
+		Remove:   remove,
+		Time:     msg.TimeOrNow(),
+	}
+	if c, ok := s.channels[s.Casemap(target)]; ok {
+		ev.Target = c.Name
+		ev.TargetIsChannel = true
+	}
+	return ev
+}
+
 func (s *Session) cleanUser(parted *User) {
 	nameCf := s.Casemap(parted.Name.Name)
 	if _, ok := s.monitors[nameCf]; ok {
@@ -2218,6 +2538,10 @@ func (s *Session) updateFeatures(features []string) {
 	Switch:
 		switch key {
 		case "BOUNCER_NETID":
+			if s.standalone {
+				// Keep the ID of the server in the configuration.
+				break
+			}
 			s.netID = value
 		case "CASEMAPPING":
 			oldNickCf := s.nickCf
@@ -2304,17 +2628,35 @@ func (s *Session) updateFeatures(features []string) {
 	}
 }
 
+// authenticateChunkLen is the maximum length of AUTHENTICATE payloads.
+const authenticateChunkLen = 400
+
+// authenticate sends a SASL response, split in several AUTHENTICATE messages
+// if needed.
+func (s *Session) authenticate(res string) {
+	for len(res) >= authenticateChunkLen {
+		s.out <- NewMessage("AUTHENTICATE", res[:authenticateChunkLen])
+		res = res[authenticateChunkLen:]
+	}
+	if res == "" {
+		res = "+"
+	}
+	s.out <- NewMessage("AUTHENTICATE", res)
+}
+
 func (s *Session) endRegistration() {
 	if s.registered {
 		return
 	}
 	if len(s.enabledCaps) == 0 || s.HasCapability("draft/metadata-2") {
 		// Best effort to avoid a round trip: subscribe to metadata if explicitly supported or if CAPs are not yet known
-		s.out <- NewMessage("METADATA", "*", "SUB", "soju.im/pinned", "soju.im/muted")
+		s.out <- NewMessage("METADATA", "*", "SUB", "soju.im/pinned", "soju.im/muted", metadataNotify)
 	}
-	if s.netID != "" {
+	if s.bound {
 		s.out <- NewMessage("BOUNCER", "BIND", s.netID)
 		s.out <- NewMessage("CAP", "END")
+	} else if s.standalone {
+		s.out <- NewMessage("CAP", "END")
 	} else {
 		s.out <- NewMessage("CAP", "END")
 		s.out <- NewMessage("BOUNCER", "LISTNETWORKS")
@@ -0,0 +1,309 @@
+package senpai
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"git.sr.ht/~delthas/senpai/irc"
+	"git.sr.ht/~delthas/senpai/ui"
+)
+
+const logDateFormat = "2006-01-02"
+const logTimeFormat = "15:04:05"
+
+// logStore is an on-disk log of messages, in one file per day, per target and
+// per network:
+//
+//	<root>/<network>/<target>/<YYYY-MM-DD>.log
+//
+// Each line is a message, in local time, in one of the following formats:
+//
+//	[hh:mm:ss] <nick> text
+//	[hh:mm:ss] * nick action
+//	[hh:mm:ss] -nick- notice
+type logStore struct {
+	root string
+}
+
+func newLogStore(root string) *logStore {
+	return &logStore{
+		root: root,
+	}
+}
+
+// escapeLogName escapes name so that it can be used as a single path
+// component.
+func escapeLogName(name string) string {
+	name = strings.NewReplacer("%", "%25", "/", "%2F", "\\", "%5C", "\x00", "%00").Replace(name)
+	if strings.HasPrefix(name, ".") {
+		name = "%2E" + name[1:]
+	}
+	return name
+}
+
+func unescapeLogName(name string) string {
+	return strings.NewReplacer("%2E", ".", "%2F", "/", "%5C", "\\", "%00", "\x00", "%25", "%").Replace(name)
+}
+
+func (ls *logStore) dir(network, target string) string {
+	return filepath.Join(ls.root, escapeLogName(network), escapeLogName(target))
+}
+
+// Append writes the message ev to the log of target.
+func (ls *logStore) Append(network, target string, ev irc.MessageEvent) error {
+	lines := formatLogLines(ev)
+	if len(lines) == 0 {
+		return nil
+	}
+	t := ev.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	t = t.Local()
+
+	dir := ls.dir(network, target)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, t.Format(logDateFormat)+".log"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, line := range lines {
+		fmt.Fprintf(w, "[%s] %s\n", t.Format(logTimeFormat), line)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// formatLogLines returns the log lines of ev, without their time.
+func formatLogLines(ev irc.MessageEvent) []string {
+	content := ev.Content
+	format := "<%s> %s"
+	if ev.Command == "NOTICE" {
+		format = "-%s- %s"
+	} else if strings.HasPrefix(content, "\x01") {
+		action, ok := strings.CutPrefix(content, "\x01ACTION ")
+		if !ok {
+			// Other CTCP messages are not shown, and not logged.
+			return nil
+		}
+		content = strings.TrimSuffix(action, "\x01")
+		format = "* %s %s"
+	}
+	var lines []string
+	for _, text := range strings.Split(content, "\n") {
+		lines = append(lines, fmt.Sprintf(format, ev.User, strings.TrimRight(text, "\r")))
+	}
+	return lines
+}
+
+// parseLogLine parses a log line of the given day. The target of the
+// returned message is left empty.
+func parseLogLine(day time.Time, line string) (ev irc.MessageEvent, ok bool) {
+	if len(line) < len("[hh:mm:ss] ") || line[0] != '[' || line[9] != ']' || line[10] != ' ' {
+		return ev, false
+	}
+	hms, err := time.ParseInLocation(logTimeFormat, line[1:9], time.Local)
+	if err != nil {
+		return ev, false
+	}
+	ev.Time = time.Date(day.Year(), day.Month(), day.Day(), hms.Hour(), hms.Minute(), hms.Second(), 0, time.Local)
+	line = line[11:]
+
+	var rest string
+	switch {
+	case strings.HasPrefix(line, "<"):
+		ev.User, rest, ok = strings.Cut(line[1:], "> ")
+		ev.Command = "PRIVMSG"
+		ev.Content = rest
+	case strings.HasPrefix(line, "-"):
+		ev.User, rest, ok = strings.Cut(line[1:], "- ")
+		ev.Command = "NOTICE"
+		ev.Content = rest
+	case strings.HasPrefix(line, "* "):
+		ev.User, rest, ok = strings.Cut(line[2:], " ")
+		ev.Command = "PRIVMSG"
+		ev.Content = "\x01ACTION " + rest + "\x01"
+	}
+	return ev, ok && ev.User != ""
+}
+
+// days returns the days of the log files of target, sorted from newest to
+// oldest.
+func (ls *logStore) days(network, target string) ([]time.Time, error) {
+	es, err := os.ReadDir(ls.dir(network, target))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var days []time.Time
+	for _, e := range es {
+		name, ok := strings.CutSuffix(e.Name(), ".log")
+		if !ok {
+			continue
+		}
+		day, err := time.ParseInLocation(logDateFormat, name, time.Local)
+		if err != nil {
+			continue
+		}
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool {
+		return days[i].After(days[j])
+	})
+	return days, nil
+}
+
+// readDay returns the messages of target logged on day, from oldest to newest.
+func (ls *logStore) readDay(network, target string, day time.Time) ([]irc.MessageEvent, error) {
+	f, err := os.Open(filepath.Join(ls.dir(network, target), day.Format(logDateFormat)+".log"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var evs []irc.MessageEvent
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		ev, ok := parseLogLine(day, sc.Text())
+		if !ok {
+			continue
+		}
+		ev.Target = target
+		evs = append(evs, ev)
+	}
+	return evs, sc.Err()
+}
+
+// Before returns at most limit messages of target sent before or at t, from
+// oldest to newest. Messages sent at t are included since times are logged
+// with a precision of a second: some messages of that second may not have
+// been read yet.
+func (ls *logStore) Before(network, target string, t time.Time, limit int) ([]irc.MessageEvent, error) {
+	days, err := ls.days(network, target)
+	if err != nil {
+		return nil, err
+	}
+	t = t.Local()
+	var evs []irc.MessageEvent
+	for _, day := range days {
+		if len(evs) >= limit {
+			break
+		}
+		if day.After(t) {
+			continue
+		}
+		dayEvs, err := ls.readDay(network, target, day)
+		if err != nil {
+			return nil, err
+		}
+		n := len(dayEvs)
+		for n > 0 && dayEvs[n-1].Time.After(t) {
+			n--
+		}
+		evs = append(dayEvs[:n], evs...)
+	}
+	if len(evs) > limit {
+		evs = evs[len(evs)-limit:]
+	}
+	return evs, nil
+}
+
+// Search returns at most limit messages containing text, case-insensitively,
+// from oldest to newest. If target is empty, all targets of the network are
+// searched.
+func (ls *logStore) Search(network, target, text string, limit int) ([]irc.MessageEvent, error) {
+	targets := []string{target}
+	if target == "" {
+		es, err := os.ReadDir(filepath.Join(ls.root, escapeLogName(network)))
+		if os.IsNotExist(err) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		targets = targets[:0]
+		for _, e := range es {
+			if e.IsDir() {
+				targets = append(targets, unescapeLogName(e.Name()))
+			}
+		}
+	}
+
+	text = strings.ToLower(text)
+	var evs []irc.MessageEvent
+	for _, target := range targets {
+		days, err := ls.days(network, target)
+		if err != nil {
+			return nil, err
+		}
+		for _, day := range days {
+			dayEvs, err := ls.readDay(network, target, day)
+			if err != nil {
+				return nil, err
+			}
+			for _, ev := range dayEvs {
+				if strings.Contains(strings.ToLower(ev.Content), text) {
+					evs = append(evs, ev)
+				}
+			}
+		}
+	}
+	sort.SliceStable(evs, func(i, j int) bool {
+		return evs[i].Time.Before(evs[j].Time)
+	})
+	if len(evs) > limit {
+		evs = evs[len(evs)-limit:]
+	}
+	return evs, nil
+}
+
+// logHistoryLoaded is posted when the messages requested by
+// App.requestLogHistory are read from the message log.
+type logHistoryLoaded struct {
+	key    boundKey
+	netID  string
+	buffer string
+	before time.Time
+	evs    []irc.MessageEvent
+	err    error
+}
+
+// logSearchDone is posted when a search of the message log started by /search
+// is done.
+type logSearchDone struct {
+	netID string
+	evs   []irc.MessageEvent
+	err   error
+}
+
+func (app *App) handleLogSearchDone(ev *logSearchDone) {
+	if ev.err != nil {
+		netID, buffer := app.win.CurrentBuffer()
+		app.win.AddLine(netID, buffer, ui.Line{
+			At:     time.Now(),
+			Head:   ui.ColorString("!!", ui.ColorRed),
+			Notify: ui.NotifyUnread,
+			Body:   ui.PlainSprintf("SEARCH: searching the message log: %v", ev.err),
+		})
+		return
+	}
+	s := app.sessions[ev.netID]
+	if s == nil {
+		return
+	}
+	for i := range ev.evs {
+		ev.evs[i] = fromLog(s, ev.evs[i])
+	}
+	app.showSearchResults(s, ev.evs)
+}
@@ -0,0 +1,188 @@
+package senpai
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"git.sr.ht/~delthas/senpai/irc"
+)
+
+func TestEscapeLogName(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		escaped string
+	}{
+		{"#senpai", "#senpai"},
+		{"a/b\\c", "a%2Fb%5Cc"},
+		{"..", "%2E."},
+		{"100%", "100%25"},
+		{"%2F", "%252F"},
+	} {
+		if escaped := escapeLogName(tc.name); escaped != tc.escaped {
+			t.Errorf("%q: got %q, expected %q", tc.name, escaped, tc.escaped)
+		}
+		if name := unescapeLogName(tc.escaped); name != tc.name {
+			t.Errorf("%q: got %q back, expected %q", tc.escaped, name, tc.name)
+		}
+	}
+}
+
+func TestLogLines(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)
+	at := time.Date(2024, 3, 1, 12, 34, 56, 0, time.Local)
+	for _, tc := range []struct {
+		ev       irc.MessageEvent
+		lines    []string
+		contents []string // parsed back, if different from the content of ev
+	}{{
+		ev:    irc.MessageEvent{User: "alice", Command: "PRIVMSG", Content: "hello <world>"},
+		lines: []string{"<alice> hello <world>"},
+	}, {
+		ev:    irc.MessageEvent{User: "bob", Command: "PRIVMSG", Content: "\x01ACTION waves\x01"},
+		lines: []string{"* bob waves"},
+	}, {
+		ev:    irc.MessageEvent{User: "NickServ", Command: "NOTICE", Content: "you are - now identified"},
+		lines: []string{"-NickServ- you are - now identified"},
+	}, {
+		ev:       irc.MessageEvent{User: "carol", Command: "PRIVMSG", Content: "first\r\nsecond"},
+		lines:    []string{"<carol> first", "<carol> second"},
+		contents: []string{"first", "second"},
+	}, {
+		ev: irc.MessageEvent{User: "dave", Command: "PRIVMSG", Content: "\x01VERSION\x01"},
+	}} {
+		lines := formatLogLines(tc.ev)
+		if fmt.Sprint(lines) != fmt.Sprint(tc.lines) {
+			t.Errorf("%q: got lines %q, expected %q", tc.ev.Content, lines, tc.lines)
+			continue
+		}
+		contents := tc.contents
+		if contents == nil && lines != nil {
+			contents = []string{tc.ev.Content}
+		}
+		for i, line := range lines {
+			ev, ok := parseLogLine(day, "["+at.Format(logTimeFormat)+"] "+line)
+			if !ok {
+				t.Errorf("%q: failed to parse", line)
+				continue
+			}
+			if ev.User != tc.ev.User || ev.Command != tc.ev.Command || ev.Content != contents[i] || !ev.Time.Equal(at) {
+				t.Errorf("%q: got %+v", line, ev)
+			}
+		}
+	}
+	for _, line := range []string{"", "[12:34:56]", "[12:34:56] hello", "[1:2:3]    <a> b", "[12:34:56] <> empty"} {
+		if _, ok := parseLogLine(day, line); ok {
+			t.Errorf("%q: expected a parse failure", line)
+		}
+	}
+}
+
+func TestLogBefore(t *testing.T) {
+	ls := newLogStore(t.TempDir())
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)
+	var times []time.Time
+	for i := 0; i < 6; i++ {
+		// Messages 2 to 5 are sent during the same second.
+		at := day.Add(time.Duration(min(i, 2)) * time.Second).Add(time.Duration(i) * time.Millisecond)
+		ev := irc.MessageEvent{
+			User:    "alice",
+			Command: "PRIVMSG",
+			Content: fmt.Sprintf("message %d", i),
+			Time:    at,
+		}
+		if err := ls.Append("net", "#chan", ev); err != nil {
+			t.Fatal(err)
+		}
+		times = append(times, at.Truncate(time.Second))
+	}
+	// A message of the next day.
+	if err := ls.Append("net", "#chan", irc.MessageEvent{
+		User:    "alice",
+		Command: "PRIVMSG",
+		Content: "tomorrow",
+		Time:    day.Add(24 * time.Hour),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	contents := func(evs []irc.MessageEvent) string {
+		var s []string
+		for _, ev := range evs {
+			s = append(s, ev.Content)
+		}
+		return fmt.Sprint(s)
+	}
+
+	evs, err := ls.Before("net", "#chan", day.Add(48*time.Hour), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := contents(evs); c != "[message 4 message 5 tomorrow]" {
+		t.Errorf("first page: got %v", c)
+	}
+	// The next page includes the messages of the same second, to be skipped
+	// by the caller if already shown.
+	evs, err = ls.Before("net", "#chan", times[4], 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := contents(evs); c != "[message 3 message 4 message 5]" {
+		t.Errorf("second page: got %v", c)
+	}
+	evs, err = ls.Before("net", "#chan", times[1], 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := contents(evs); c != "[message 0 message 1]" {
+		t.Errorf("last page: got %v", c)
+	}
+	if evs, err := ls.Before("net", "#unknown", times[5], 10); err != nil || len(evs) != 0 {
+		t.Errorf("unknown target: got %v, %v", evs, err)
+	}
+}
+
+func TestLogSearch(t *testing.T) {
+	ls := newLogStore(t.TempDir())
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+	for i, m := range []struct {
+		target  string
+		content string
+	}{
+		{"#a", "Hello there"},
+		{"#b", "hello again"},
+		{"#a", "goodbye"},
+		{"bob", "HELLO bob"},
+	} {
+		ev := irc.MessageEvent{
+			User:    "alice",
+			Command: "PRIVMSG",
+			Content: m.content,
+			Time:    at.Add(time.Duration(i) * time.Hour),
+		}
+		if err := ls.Append("net", m.target, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range []struct {
+		target   string
+		limit    int
+		expected []string
+	}{
+		{"#a", 10, []string{"Hello there"}},
+		{"", 10, []string{"Hello there", "hello again", "HELLO bob"}},
+		{"", 2, []string{"hello again", "HELLO bob"}},
+	} {
+		evs, err := ls.Search("net", tc.target, "hello", tc.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var contents []string
+		for _, ev := range evs {
+			contents = append(contents, ev.Content)
+		}
+		if fmt.Sprint(contents) != fmt.Sprint(tc.expected) {
+			t.Errorf("%q, %d: got %q, expected %q", tc.target, tc.limit, contents, tc.expected)
+		}
+	}
+}
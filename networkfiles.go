@@ -0,0 +1,117 @@
+package senpai
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// networkFiles keeps a file of lines for each network, as used by the stores
+// of ignore masks and of settings of buffers:
+//
+//	<root>/<network>
+type networkFiles struct {
+	root string // empty if transient
+}
+
+// read returns the non-empty lines of the file of network, without
+// surrounding whitespace.
+func (nf networkFiles) read(network string) []string {
+	if nf.root == "" {
+		return nil
+	}
+	f, err := os.Open(filepath.Join(nf.root, escapeLogName(network)))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// write replaces the file of network with lines.
+func (nf networkFiles) write(network string, lines []string) error {
+	if nf.root == "" {
+		return nil
+	}
+	if err := os.MkdirAll(nf.root, 0700); err != nil {
+		return err
+	}
+	var sb strings.Builder
+	for _, line := range lines {
+		sb.WriteString(line)
+		sb.WriteByte('\n')
+	}
+	return os.WriteFile(filepath.Join(nf.root, escapeLogName(network)), []byte(sb.String()), 0600)
+}
+
+// bufferSettings keeps a setting of buffers, such as their notification
+// level. Unless it is transient, values are saved in one file per network,
+// with one "<target> <value>" pair per line, sorted by target.
+type bufferSettings struct {
+	files  networkFiles
+	valid  func(value string) bool
+	values map[string]map[string]string
+}
+
+func newBufferSettings(root string, valid func(value string) bool) *bufferSettings {
+	return &bufferSettings{
+		files:  networkFiles{root: root},
+		valid:  valid,
+		values: make(map[string]map[string]string),
+	}
+}
+
+func (bs *bufferSettings) load(network string) map[string]string {
+	if values, ok := bs.values[network]; ok {
+		return values
+	}
+	values := make(map[string]string)
+	for _, line := range bs.files.read(network) {
+		target, value, ok := strings.Cut(line, " ")
+		if ok && bs.valid(value) {
+			values[target] = value
+		}
+	}
+	bs.values[network] = values
+	return values
+}
+
+// Get returns the value of target, which must be casemapped, or an empty
+// string if it is unset.
+func (bs *bufferSettings) Get(network, target string) string {
+	return bs.load(network)[target]
+}
+
+// Set sets the value of target, which must be casemapped, or unsets it if
+// value is empty.
+func (bs *bufferSettings) Set(network, target, value string) error {
+	values := bs.load(network)
+	if values[target] == value {
+		return nil
+	}
+	if value == "" {
+		delete(values, target)
+	} else {
+		values[target] = value
+	}
+	// Sort the targets to keep the file stable across saves.
+	targets := make([]string, 0, len(values))
+	for target := range values {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	lines := make([]string, 0, len(targets))
+	for _, target := range targets {
+		lines = append(lines, target+" "+values[target])
+	}
+	return bs.files.write(network, lines)
+}
@@ -0,0 +1,24 @@
+package senpai
+
+// Notification levels of a buffer. The default level, when unset, is
+// notifyHighlights.
+const (
+	notifyAll        = "all"        // notify on all messages
+	notifyHighlights = "highlights" // notify on highlights, mark other messages as unread
+	notifyNone       = "none"       // never notify, nor mark messages as unread
+)
+
+func isNotifyLevel(level string) bool {
+	switch level {
+	case notifyAll, notifyHighlights, notifyNone:
+		return true
+	default:
+		return false
+	}
+}
+
+// newNotifyStore returns the store of the notification levels of buffers,
+// for servers which cannot store them.
+func newNotifyStore(root string) *bufferSettings {
+	return newBufferSettings(root, isNotifyLevel)
+}
@@ -0,0 +1,87 @@
+package senpai
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"git.sr.ht/~delthas/senpai/irc"
+)
+
+// TestNotifyFallback checks that notification levels are stored locally for
+// servers without metadata.
+func TestNotifyFallback(t *testing.T) {
+	dir := t.TempDir()
+	app := &App{
+		cfg: Config{
+			ServerConfig: ServerConfig{Addr: "irc.example.org:6697"},
+		},
+		notifications: newNotifyStore(dir),
+	}
+	s := irc.NewSession(make(chan irc.Message, 64), irc.SessionParams{
+		Nickname: "me",
+	})
+
+	for _, buffer := range []string{"#Zeta", "#alpha", "[Bob]"} {
+		if err := app.setNotifyLevel(s, buffer, notifyNone); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := app.setNotifyLevel(s, "#alpha", notifyAll); err != nil {
+		t.Fatal(err)
+	}
+	if level := app.notifyLevel(s, "#ALPHA"); level != notifyAll {
+		t.Errorf("#ALPHA: got level %q, expected %q", level, notifyAll)
+	}
+	if level := app.notifyLevel(s, "{bob}"); level != notifyNone {
+		t.Errorf("{bob}: got level %q, expected %q", level, notifyNone)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "irc.example.org"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "#alpha all\n#zeta none\n{bob} none\n"
+	if string(b) != expected {
+		t.Errorf("got file %q, expected %q", b, expected)
+	}
+
+	if err := app.setNotifyLevel(s, "#zeta", ""); err != nil {
+		t.Fatal(err)
+	}
+	ns := newNotifyStore(dir)
+	if level := ns.Get("irc.example.org", "#zeta"); level != "" {
+		t.Errorf("#zeta: got level %q after unsetting it", level)
+	}
+	if level := ns.Get("irc.example.org", "#alpha"); level != notifyAll {
+		t.Errorf("#alpha: got level %q after loading, expected %q", level, notifyAll)
+	}
+}
+
+// TestNetworkKey checks that server blocks on the same host as each other or
+// as the main server do not share their files.
+func TestNetworkKey(t *testing.T) {
+	app := &App{
+		cfg: Config{
+			ServerConfig: ServerConfig{Addr: "irc.libera.chat:6697"},
+		},
+		servers: map[string]*ServerConfig{
+			serverNetID("work"): {Name: "work", Addr: "irc.libera.chat:6697"},
+			serverNetID("home"): {Name: "home", Addr: "irc.libera.chat:6697"},
+		},
+	}
+	keys := make(map[string]string)
+	for _, netID := range []string{"", "42", serverNetID("work"), serverNetID("home")} {
+		key := app.networkKey(netID)
+		if other, ok := keys[key]; ok {
+			t.Errorf("networks %q and %q share the key %q", other, netID, key)
+		}
+		keys[key] = netID
+	}
+	if key := app.networkKey(""); key != "irc.libera.chat" {
+		t.Errorf("main server: got key %q, expected %q", key, "irc.libera.chat")
+	}
+	if key := app.networkKey("42"); key != "irc.libera.chat~42" {
+		t.Errorf("bouncer network: got key %q, expected %q", key, "irc.libera.chat~42")
+	}
+}
@@ -0,0 +1,205 @@
+package senpai
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// previewCache is an on-disk cache of link previews. Previews are stored by
+// the hash of their contents, so that links with the same preview share it,
+// and links point to the hash of their preview. The least recently used
+// previews are removed when the cache grows past its maximum size.
+type previewCache struct {
+	dir     string
+	maxSize int64
+
+	lock sync.Mutex
+}
+
+func newPreviewCache(dir string, maxSize int64) *previewCache {
+	return &previewCache{
+		dir:     dir,
+		maxSize: maxSize,
+	}
+}
+
+func hashHex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func (c *previewCache) linkPath(link string) string {
+	return filepath.Join(c.dir, "links", hashHex([]byte(link)))
+}
+
+func (c *previewCache) blobPath(hash string) string {
+	return filepath.Join(c.dir, "blobs", hash)
+}
+
+// Get returns the cached preview of link, if any.
+func (c *previewCache) Get(link string) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	hash, err := os.ReadFile(c.linkPath(link))
+	if err != nil {
+		return nil, false
+	}
+	path := c.blobPath(string(hash))
+	b, err := os.ReadFile(path)
+	if err != nil || hashHex(b) != string(hash) {
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return b, true
+}
+
+// Put stores the preview of link, then removes the least recently used
+// previews past the maximum size of the cache.
+func (c *previewCache) Put(link string, b []byte) error {
+	// The preview is stored with a link to it, of the size of its hash.
+	if int64(len(b))+sha256.Size*2 > c.maxSize {
+		return errors.New("preview too large for the cache")
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	hash := hashHex(b)
+	path := c.blobPath(hash)
+	if _, err := os.Stat(path); err != nil {
+		if err := writeFileAtomic(path, b); err != nil {
+			return err
+		}
+	} else {
+		now := time.Now()
+		os.Chtimes(path, now, now)
+	}
+	if err := writeFileAtomic(c.linkPath(link), []byte(hash)); err != nil {
+		return err
+	}
+	return c.prune()
+}
+
+// cacheFile is a file of the preview cache.
+type cacheFile struct {
+	name  string
+	size  int64
+	mtime time.Time
+}
+
+// listCacheFiles returns the regular files of dir, if it exists.
+func listCacheFiles(dir string) ([]cacheFile, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var files []cacheFile
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		files = append(files, cacheFile{
+			name:  e.Name(),
+			size:  info.Size(),
+			mtime: info.ModTime(),
+		})
+	}
+	return files, nil
+}
+
+// prune removes the least recently used previews, with the links to them,
+// until the previews and links fit in the maximum size of the cache. Links
+// to missing previews are removed as well.
+func (c *previewCache) prune() error {
+	blobs, err := listCacheFiles(filepath.Join(c.dir, "blobs"))
+	if err != nil {
+		return err
+	}
+	links, err := listCacheFiles(filepath.Join(c.dir, "links"))
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, f := range blobs {
+		size += f.size
+	}
+	for _, f := range links {
+		size += f.size
+	}
+	if size <= c.maxSize {
+		return nil
+	}
+
+	exists := make(map[string]bool, len(blobs))
+	for _, b := range blobs {
+		exists[b.name] = true
+	}
+	linksTo := make(map[string][]cacheFile)
+	for _, l := range links {
+		path := filepath.Join(c.dir, "links", l.name)
+		hash, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if !exists[string(hash)] {
+			if os.Remove(path) == nil {
+				size -= l.size
+			}
+			continue
+		}
+		linksTo[string(hash)] = append(linksTo[string(hash)], l)
+	}
+
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].mtime.Before(blobs[j].mtime)
+	})
+	for _, b := range blobs {
+		if size <= c.maxSize {
+			break
+		}
+		if err := os.Remove(c.blobPath(b.name)); err != nil {
+			continue
+		}
+		size -= b.size
+		for _, l := range linksTo[b.name] {
+			if os.Remove(filepath.Join(c.dir, "links", l.name)) == nil {
+				size -= l.size
+			}
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic writes b to path, creating its directory if needed, so that
+// readers never see a partial file.
+func writeFileAtomic(path string, b []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,134 @@
+package senpai
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"codeberg.org/emersion/go-scfg"
+	"git.sr.ht/~rockorager/vaxis"
+
+	"git.sr.ht/~delthas/senpai/ui"
+)
+
+// themePath returns the path of the theme file name. Plain names refer to
+// files in the themes directory next to the configuration file; other
+// relative paths are relative to the configuration file.
+func themePath(configFilename, name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	dir := filepath.Dir(configFilename)
+	if filepath.Base(name) == name {
+		return filepath.Join(dir, "themes", name)
+	}
+	return filepath.Join(dir, name)
+}
+
+// parseStyle parses a style from a list of attributes, such as:
+//
+//	fg red bg #202020 bold underline curly
+func parseStyle(params []string) (vaxis.Style, error) {
+	var st vaxis.Style
+	for i := 0; i < len(params); i++ {
+		color := func(c *vaxis.Color) error {
+			if i+1 >= len(params) {
+				return fmt.Errorf("%q requires a color", params[i])
+			}
+			i++
+			return parseColor(params[i], c)
+		}
+		var err error
+		switch params[i] {
+		case "fg":
+			err = color(&st.Foreground)
+		case "bg":
+			err = color(&st.Background)
+		case "underline-color":
+			err = color(&st.UnderlineColor)
+		case "bold":
+			st.Attribute |= vaxis.AttrBold
+		case "dim":
+			st.Attribute |= vaxis.AttrDim
+		case "italic":
+			st.Attribute |= vaxis.AttrItalic
+		case "blink":
+			st.Attribute |= vaxis.AttrBlink
+		case "reverse":
+			st.Attribute |= vaxis.AttrReverse
+		case "strikethrough":
+			st.Attribute |= vaxis.AttrStrikethrough
+		case "underline":
+			st.UnderlineStyle = vaxis.UnderlineSingle
+			if i+1 < len(params) {
+				switch params[i+1] {
+				case "single":
+				case "double":
+					st.UnderlineStyle = vaxis.UnderlineDouble
+				case "curly":
+					st.UnderlineStyle = vaxis.UnderlineCurly
+				case "dotted":
+					st.UnderlineStyle = vaxis.UnderlineDotted
+				case "dashed":
+					st.UnderlineStyle = vaxis.UnderlineDashed
+				default:
+					continue
+				}
+				i++
+			}
+		case "none":
+		default:
+			err = fmt.Errorf("unknown style attribute %q", params[i])
+		}
+		if err != nil {
+			return vaxis.Style{}, err
+		}
+	}
+	return st, nil
+}
+
+// loadTheme loads a theme file.
+func loadTheme(filename string) (*ui.Theme, error) {
+	directives, err := scfg.Load(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing scfg: %w", err)
+	}
+
+	var theme ui.Theme
+	for _, d := range directives {
+		var field **vaxis.Style
+		switch d.Name {
+		case "timestamp":
+			field = &theme.Timestamp
+		case "date-separator":
+			field = &theme.DateSeparator
+		case "server":
+			field = &theme.Server
+		case "card":
+			field = &theme.Card
+		case "buffer-unread":
+			field = &theme.BufferUnread
+		case "buffer-pinned":
+			field = &theme.BufferPinned
+		case "buffer-muted":
+			field = &theme.BufferMuted
+		case "buffer-highlight":
+			field = &theme.BufferHighlight
+		case "topic":
+			field = &theme.Topic
+		case "member-prefix":
+			field = &theme.MemberPrefix
+		case "link":
+			field = &theme.Link
+		case "typo":
+			field = &theme.Typo
+		default:
+			return nil, fmt.Errorf("unknown theme element %q", d.Name)
+		}
+		st, err := parseStyle(d.Params)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", d.Name, err)
+		}
+		*field = &st
+	}
+	return &theme, nil
+}
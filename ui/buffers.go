@@ -3,6 +3,7 @@ package ui
 import (
 	"fmt"
 	"math"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -30,6 +31,7 @@ const (
 	NotifyNone NotifyType = iota
 	NotifyUnread
 	NotifyHighlight
+	NotifyMessage // unread and notified, but not a highlight
 )
 
 type optional int
@@ -48,17 +50,107 @@ type Line struct {
 	Highlight bool
 	Readable  bool
 	Mergeable bool
+	Redacted  bool // the message was deleted; Body is its tombstone
 	Data      interface{}
 
+	ID        string         // IRC msgid of the message, if any
+	ReplyTo   string         // IRC msgid of the message this line replies to, if any
+	Reply     StyledString   // header shown above the body, for replies
+	Reactions []Reaction     // shown below the body
+	Card      []StyledString // link preview, shown below the reactions
+
 	splitPoints []point
 	width       int
 	newLines    []int
 }
 
+// Reaction is a reaction to a line, along with the users who sent it.
+type Reaction struct {
+	Text  string
+	Users []string // casemapped nicks
+}
+
 func (l *Line) IsZero() bool {
 	return l.Body.string == ""
 }
 
+// height returns the number of rows taken by the line.
+func (l *Line) height(vx *Vaxis, width int) int {
+	h := len(l.NewLines(vx, width)) + 1
+	if l.Reply.string != "" {
+		h++
+	}
+	if len(l.Reactions) > 0 {
+		h++
+	}
+	return h + len(l.Card)
+}
+
+// react adds or removes the reaction of user to the line.
+func (l *Line) react(user, text string, remove bool) {
+	for i := range l.Reactions {
+		r := &l.Reactions[i]
+		if r.Text != text {
+			continue
+		}
+		for j, u := range r.Users {
+			if u != user {
+				continue
+			}
+			if remove {
+				r.Users = append(r.Users[:j], r.Users[j+1:]...)
+				if len(r.Users) == 0 {
+					l.Reactions = append(l.Reactions[:i], l.Reactions[i+1:]...)
+				}
+			}
+			return
+		}
+		if !remove {
+			r.Users = append(r.Users, user)
+		}
+		return
+	}
+	if !remove {
+		l.Reactions = append(l.Reactions, Reaction{
+			Text:  text,
+			Users: []string{user},
+		})
+	}
+}
+
+// reactionsString returns the aggregated reactions of the line, e.g. "👍 3  🎉 1".
+func (l *Line) reactionsString() string {
+	var sb strings.Builder
+	for i, r := range l.Reactions {
+		if i > 0 {
+			sb.WriteString("  ")
+		}
+		fmt.Fprintf(&sb, "%s %d", r.Text, len(r.Users))
+	}
+	return sb.String()
+}
+
+// newLinesMultiline computes the new lines of a line containing line breaks,
+// by splitting each of its rows separately.
+func (l *Line) newLinesMultiline(vx *Vaxis, width int) {
+	offset := 0
+	for i, row := range strings.Split(l.Body.string, "\n") {
+		if i > 0 {
+			l.newLines = append(l.newLines, offset)
+		}
+		sub := Line{Body: PlainString(row)}
+		sub.computeSplitPoints(vx)
+		for _, nl := range sub.NewLines(vx, width) {
+			if nl >= len(row) {
+				// Do not wrap right before the line break.
+				continue
+			}
+			l.newLines = append(l.newLines, offset+nl)
+		}
+		offset += len(row) + 1
+	}
+}
+
 func (l *Line) computeSplitPoints(vx *Vaxis) {
 	if l.splitPoints == nil {
 		l.splitPoints = []point{}
@@ -109,6 +201,11 @@ func (l *Line) NewLines(vx *Vaxis, width int) []int {
 	l.newLines = l.newLines[:0]
 	l.width = width
 
+	if strings.ContainsRune(l.Body.string, '\n') {
+		l.newLinesMultiline(vx, width)
+		return l.newLines
+	}
+
 	x := 0
 	for i := 1; i < len(l.splitPoints); i++ {
 		// Iterate through the split points 2 by 2.  Split points are placed at
@@ -221,9 +318,49 @@ type buffer struct {
 	lines []Line
 	topic StyledString
 
+	// detached is whether lines holds a window of history which is not
+	// contiguous with the live lines, which are then kept in present.
+	detached bool
+	present  []Line
+
+	// selected is the index in lines of the selected line, plus one.
+	// It is 0 if no line is selected.
+	selected int
+
 	scrollAmt   int // offset in lines from the bottom
 	topicOffset int // offset in clusters that are skipped when rendering topic text
 	isAtTop     bool
+
+	// draft is the input that was being written when the buffer was left,
+	// and draftCursor the position of the cursor in it, in runes.
+	draft       []rune
+	draftCursor int
+}
+
+// BufferGroup is a user-defined group of buffers, shown under its own header
+// in the vertical buffer list.
+type BufferGroup struct {
+	Name     string
+	Networks []string // names of the networks of the buffers, or all if empty
+	Channels []string // names of the buffers, or all if empty
+}
+
+func (g *BufferGroup) match(b *buffer) bool {
+	if len(g.Networks) > 0 && !slices.Contains(g.Networks, b.netName) {
+		return false
+	}
+	if len(g.Channels) > 0 && !slices.ContainsFunc(g.Channels, func(c string) bool {
+		return strings.EqualFold(c, b.title)
+	}) {
+		return false
+	}
+	return true
+}
+
+// listRow is a row of the vertical buffer list.
+type listRow struct {
+	buffer int // index of the buffer in the list, or -1 for a group header
+	group  int // index of the user-defined group, for group headers
 }
 
 type BufferList struct {
@@ -235,6 +372,11 @@ type BufferList struct {
 	clicked int
 	focused bool
 
+	// collapsedNetworks and collapsedGroups are the sets of collapsed
+	// network IDs and user-defined group names.
+	collapsedNetworks map[string]bool
+	collapsedGroups   map[string]bool
+
 	tlInnerWidth int
 	tlHeight     int
 	textWidth    int
@@ -247,10 +389,12 @@ type BufferList struct {
 // Call Resize() once before using it.
 func NewBufferList(ui *UI) BufferList {
 	return BufferList{
-		ui:      ui,
-		list:    []buffer{},
-		clicked: -1,
-		focused: true,
+		ui:                ui,
+		list:              []buffer{},
+		clicked:           -1,
+		focused:           true,
+		collapsedNetworks: make(map[string]bool),
+		collapsedGroups:   make(map[string]bool),
 	}
 }
 
@@ -282,6 +426,9 @@ func (bs *BufferList) To(i int) bool {
 		return false
 	}
 	if 0 <= i {
+		if 0 <= bs.current && bs.current < len(bs.list) {
+			bs.list[bs.current].selected = 0
+		}
 		bs.current = i
 		if len(bs.list) <= bs.current {
 			bs.current = len(bs.list) - 1
@@ -353,33 +500,19 @@ func (bs *BufferList) Add(netID, netName, title string) (i int, added bool) {
 		}
 	}
 
-	i = 0
-	lTitle := strings.ToLower(title)
-	for bi, b := range bs.list {
-		if b.pinned || b.netName < netName {
-			i = bi + 1
-			continue
-		}
-		if b.muted || b.netName > netName {
-			break
-		}
-		lbTitle := strings.ToLower(b.title)
-		if lbTitle < lTitle {
-			i = bi + 1
-			continue
-		}
-		break
+	b := buffer{
+		netID:   netID,
+		netName: netName,
+		title:   title,
 	}
+	i = sort.Search(len(bs.list), func(i int) bool {
+		return bs.less(&b, &bs.list[i])
+	})
 
 	if i <= bs.current && bs.current < len(bs.list) {
 		bs.current++
 	}
 
-	b := buffer{
-		netID:   netID,
-		netName: netName,
-		title:   title,
-	}
 	if i == len(bs.list) {
 		bs.list = append(bs.list, b)
 	} else {
@@ -439,48 +572,228 @@ func (bs *BufferList) RemoveNetwork(netID string) {
 	}
 }
 
+// group returns the index of the user-defined group of b, or -1 if it is not
+// in any. Network buffers and pinned buffers are never in a group.
+func (bs *BufferList) group(b *buffer) int {
+	if b.title == "" || b.pinned {
+		return -1
+	}
+	for i := range bs.ui.config.BufferGroups {
+		if bs.ui.config.BufferGroups[i].match(b) {
+			return i
+		}
+	}
+	return -1
+}
+
+// section returns the rank of the section of the buffer list b is in: the
+// main network, pinned buffers, user-defined groups, then other networks.
+func (bs *BufferList) section(b *buffer) (rank, group int) {
+	if group := bs.group(b); group >= 0 {
+		return 2, group
+	}
+	if b.netID == "" {
+		return 0, -1
+	}
+	if b.pinned {
+		return 1, -1
+	}
+	return 3, -1
+}
+
+func (bs *BufferList) less(bi, bj *buffer) bool {
+	ri, gi := bs.section(bi)
+	rj, gj := bs.section(bj)
+	if ri != rj {
+		return ri < rj
+	}
+	if gi != gj {
+		return gi < gj
+	}
+	if bi.pinned && !bj.pinned {
+		return true
+	}
+	if !bi.pinned && bj.pinned {
+		return false
+	}
+	if gi < 0 {
+		if c := strings.Compare(bi.netName, bj.netName); c != 0 {
+			return c == -1
+		}
+	}
+	if bi.title == "" && bj.title != "" {
+		return true
+	}
+	if bi.title != "" && bj.title == "" {
+		return false
+	}
+	if bi.muted && !bj.muted {
+		return false
+	}
+	if !bi.muted && bj.muted {
+		return true
+	}
+	bti := strings.ToLower(bi.title)
+	btj := strings.ToLower(bj.title)
+	if c := strings.Compare(bti, btj); c != 0 {
+		return c == -1
+	}
+	return strings.Compare(bi.netName, bj.netName) == -1
+}
+
 func (bs *BufferList) reorder() {
 	netID, title := bs.Current()
 	sort.Slice(bs.list, func(i, j int) bool {
-		bi := &bs.list[i]
-		bj := &bs.list[j]
-		if bi.netID == "" && bj.netID != "" {
-			return true
-		}
-		if bi.netID != "" && bj.netID == "" {
-			return false
-		}
-		if bi.pinned && !bj.pinned {
-			return true
+		return bs.less(&bs.list[i], &bs.list[j])
+	})
+	i, _ := bs.at(netID, title)
+	if i >= 0 {
+		bs.current = i
+	}
+}
+
+// collapsed reports whether b is hidden in a collapsed group of the vertical
+// buffer list. group is the index of the user-defined group of b, or -1.
+func (bs *BufferList) collapsed(b *buffer, group int) bool {
+	if group >= 0 {
+		return bs.collapsedGroups[bs.ui.config.BufferGroups[group].Name]
+	}
+	return b.title != "" && !(b.pinned && b.netID != "") && bs.collapsedNetworks[b.netID]
+}
+
+// groupStatus returns whether any buffer of a group is unread, and the total
+// number of highlights of its buffers. The group is either the user-defined
+// group of index group, or the network netID if group is -1.
+func (bs *BufferList) groupStatus(netID string, group int) (unread bool, highlights int) {
+	for i := range bs.list {
+		b := &bs.list[i]
+		if g := bs.group(b); g != group {
+			continue
 		}
-		if !bi.pinned && bj.pinned {
-			return false
+		if group < 0 && (b.netID != netID || (b.pinned && b.netID != "")) {
+			continue
 		}
-		if c := strings.Compare(bi.netName, bj.netName); c != 0 {
-			return c == -1
+		unread = unread || (b.unread && !b.muted)
+		highlights += b.highlights
+	}
+	return unread, highlights
+}
+
+// rows returns the rows of the vertical buffer list. Buffers of collapsed
+// groups are hidden, except the current buffer.
+func (bs *BufferList) rows() []listRow {
+	rows := make([]listRow, 0, len(bs.list))
+	last := -1
+	for i := range bs.list {
+		b := &bs.list[i]
+		if bs.filterBuffers {
+			title := b.title
+			if title == "" {
+				title = b.netName
+			}
+			if strings.Contains(strings.ToLower(title), bs.filterBuffersQuery) {
+				rows = append(rows, listRow{buffer: i})
+			}
+			continue
 		}
-		if bi.title == "" && bj.title != "" {
-			return true
+		group := bs.group(b)
+		if group >= 0 && group != last {
+			rows = append(rows, listRow{buffer: -1, group: group})
 		}
-		if bi.title != "" && bj.title == "" {
-			return false
+		last = group
+		if i != bs.current && bs.collapsed(b, group) {
+			continue
 		}
-		if bi.muted && !bj.muted {
-			return false
+		rows = append(rows, listRow{buffer: i})
+	}
+	return rows
+}
+
+// Row returns the row of the buffer of index i in the vertical buffer list,
+// or -1 if it is hidden.
+func (bs *BufferList) Row(i int) int {
+	for y, row := range bs.rows() {
+		if row.buffer == i {
+			return y
 		}
-		if !bi.muted && bj.muted {
-			return true
+	}
+	return -1
+}
+
+// currentGroup returns the group of the current buffer: either a user-defined
+// group of index group, or the network netID if group is -1. ok is false if
+// the current buffer is not in a collapsible group.
+func (bs *BufferList) currentGroup() (netID string, group int, ok bool) {
+	if bs.current < 0 || bs.current >= len(bs.list) {
+		return "", -1, false
+	}
+	b := &bs.list[bs.current]
+	if group := bs.group(b); group >= 0 {
+		return "", group, true
+	}
+	if b.pinned && b.netID != "" {
+		return "", -1, false
+	}
+	return b.netID, -1, true
+}
+
+func (bs *BufferList) setCollapsed(netID string, group int, collapsed bool) {
+	if group >= 0 {
+		bs.collapsedGroups[bs.ui.config.BufferGroups[group].Name] = collapsed
+	} else {
+		bs.collapsedNetworks[netID] = collapsed
+	}
+}
+
+// SetCollapsed collapses or expands the group of the current buffer.
+func (bs *BufferList) SetCollapsed(collapsed bool) {
+	if netID, group, ok := bs.currentGroup(); ok {
+		bs.setCollapsed(netID, group, collapsed)
+	}
+}
+
+// ToggleCollapsed collapses the group of the current buffer if it is
+// expanded, and expands it otherwise.
+func (bs *BufferList) ToggleCollapsed() {
+	netID, group, ok := bs.currentGroup()
+	if !ok {
+		return
+	}
+	var collapsed bool
+	if group >= 0 {
+		collapsed = bs.collapsedGroups[bs.ui.config.BufferGroups[group].Name]
+	} else {
+		collapsed = bs.collapsedNetworks[netID]
+	}
+	bs.setCollapsed(netID, group, !collapsed)
+}
+
+// SetAllCollapsed collapses or expands all groups.
+func (bs *BufferList) SetAllCollapsed(collapsed bool) {
+	for i := range bs.list {
+		b := &bs.list[i]
+		if b.title == "" {
+			bs.collapsedNetworks[b.netID] = collapsed
 		}
-		bti := strings.ToLower(bi.title)
-		btj := strings.ToLower(bj.title)
-		return strings.Compare(bti, btj) == -1
-	})
-	i, _ := bs.at(netID, title)
-	if i >= 0 {
-		bs.current = i
+	}
+	for _, g := range bs.ui.config.BufferGroups {
+		bs.collapsedGroups[g.Name] = collapsed
 	}
 }
 
+// ToggleCollapsedAt toggles the user-defined group whose header is at row y
+// of the vertical buffer list. It returns false if there is no group header
+// at that row.
+func (bs *BufferList) ToggleCollapsedAt(y int, offset int) bool {
+	rows := bs.rows()
+	if y+offset < 0 || y+offset >= len(rows) || rows[y+offset].buffer >= 0 {
+		return false
+	}
+	name := bs.ui.config.BufferGroups[rows[y+offset].group].Name
+	bs.collapsedGroups[name] = !bs.collapsedGroups[name]
+	return true
+}
+
 func (bs *BufferList) mergeLine(former *Line, addition Line) (keepLine bool) {
 	bs.ui.config.MergeLine(former, addition)
 	if former.Body.string == "" {
@@ -491,42 +804,67 @@ func (bs *BufferList) mergeLine(former *Line, addition Line) (keepLine bool) {
 	return true
 }
 
+// liveLines returns the live lines of b, which are shown unless it is
+// detached.
+func (b *buffer) liveLines() *[]Line {
+	if b.detached {
+		return &b.present
+	}
+	return &b.lines
+}
+
+// findLine returns the most recent line of b for which f returns true, either
+// in its shown lines or in its live lines.
+func (b *buffer) findLine(f func(line *Line) bool) *Line {
+	for _, lines := range []*[]Line{&b.lines, &b.present} {
+		for i := len(*lines) - 1; i >= 0; i-- {
+			if f(&(*lines)[i]) {
+				return &(*lines)[i]
+			}
+		}
+	}
+	return nil
+}
+
 func (bs *BufferList) AddLine(netID, title string, line Line) {
 	_, b := bs.at(netID, title)
 	if b == nil {
 		return
 	}
 	current := bs.cur()
+	lines := b.liveLines()
 
-	n := len(b.lines)
+	n := len(*lines)
 	line.At = line.At.UTC()
 
 	if !line.Mergeable && b.openedOnce {
 		line.Body = line.Body.ParseURLs()
 	}
 
-	if line.Mergeable && n != 0 && b.lines[n-1].Mergeable {
-		l := &b.lines[n-1]
+	if line.Mergeable && n != 0 && (*lines)[n-1].Mergeable {
+		l := &(*lines)[n-1]
 		if !bs.mergeLine(l, line) {
-			b.lines = b.lines[:n-1]
+			*lines = (*lines)[:n-1]
 		}
 		// TODO change b.scrollAmt if it's not 0 and bs.current is idx.
 	} else {
 		line.computeSplitPoints(bs.ui.vx)
-		b.lines = append(b.lines, line)
-		if b == current && 0 < b.scrollAmt {
-			b.scrollAmt += len(line.NewLines(bs.ui.vx, bs.textWidth)) + 1
+		*lines = append(*lines, line)
+		if b == current && 0 < b.scrollAmt && !b.detached {
+			b.scrollAmt += line.height(bs.ui.vx, bs.textWidth)
 		}
 	}
 
-	if line.Notify != NotifyNone && (!bs.focused || b != current) {
+	// Live lines are not seen while a detached window of history is shown.
+	seen := bs.focused && b == current && !b.detached
+	if line.Notify != NotifyNone && !seen {
 		b.unread = true
 	}
-	if line.Notify == NotifyHighlight && (!bs.focused || b != current) {
+	if line.Notify == NotifyHighlight && !seen {
 		b.highlights++
 	}
-	if b == current && b.unreadSkip == optionalUnset && len(b.lines) > 0 {
-		if b.unreadRuler.IsZero() || !b.lines[len(b.lines)-1].At.After(b.unreadRuler) {
+	if b == current && b.unreadSkip == optionalUnset && len(*lines) > 0 {
+		if b.unreadRuler.IsZero() || !(*lines)[len(*lines)-1].At.After(b.unreadRuler) {
 			b.unreadSkip = optionalTrue
 		} else {
 			b.unreadSkip = optionalFalse
@@ -540,17 +878,34 @@ func (bs *BufferList) AddLines(netID, title string, before, after []Line) {
 		return
 	}
 	updateRead := (!bs.focused || b != bs.cur()) && !b.read.IsZero()
+	lines := b.liveLines()
+	bs.addLines(b, lines, before, after, updateRead)
+	if b == bs.cur() && b.unreadSkip == optionalUnset && len(*lines) > 0 {
+		if b.unreadRuler.IsZero() || !(*lines)[len(*lines)-1].At.After(b.unreadRuler) {
+			b.unreadSkip = optionalTrue
+		} else {
+			b.unreadSkip = optionalFalse
+		}
+	}
+}
 
-	lines := make([]Line, 0, len(before)+len(b.lines)+len(after))
-	for _, buf := range []*[]Line{&before, &b.lines, &after} {
-		for _, line := range *buf {
+// addLines adds lines before and after the lines of dst, which are either the
+// shown lines or the live lines of b.
+func (bs *BufferList) addLines(b *buffer, dst *[]Line, before, after []Line, updateRead bool) {
+	selected := 0
+	lines := make([]Line, 0, len(before)+len(*dst)+len(after))
+	for _, buf := range []*[]Line{&before, dst, &after} {
+		for j, line := range *buf {
+			if buf == dst && j == b.selected-1 {
+				selected = len(lines) + 1
+			}
 			if line.Mergeable && len(lines) > 0 && lines[len(lines)-1].Mergeable {
 				l := &lines[len(lines)-1]
 				if !bs.mergeLine(l, line) {
 					lines = lines[:len(lines)-1]
 				}
 			} else {
-				if buf != &b.lines {
+				if buf != dst {
 					if b.openedOnce {
 						line.Body = line.Body.ParseURLs()
 					}
@@ -569,16 +924,96 @@ func (bs *BufferList) AddLines(netID, title string, before, after []Line) {
 			}
 		}
 	}
-	b.lines = lines
-	if b == bs.cur() && b.unreadSkip == optionalUnset && len(b.lines) > 0 {
-		if b.unreadRuler.IsZero() || !b.lines[len(b.lines)-1].At.After(b.unreadRuler) {
-			b.unreadSkip = optionalTrue
-		} else {
-			b.unreadSkip = optionalFalse
+	*dst = lines
+	if dst == &b.lines {
+		b.selected = selected
+	}
+}
+
+// Detach shows lines, a window of history which is not contiguous with the
+// live lines of a buffer, instead of its live lines, until Attach is called.
+func (bs *BufferList) Detach(netID, title string, lines []Line) {
+	_, b := bs.at(netID, title)
+	if b == nil {
+		return
+	}
+	if !b.detached {
+		b.present = b.lines
+		b.detached = true
+	}
+	b.lines = nil
+	b.selected = 0
+	b.scrollAmt = 0
+	b.isAtTop = false
+	bs.addLines(b, &b.lines, lines, nil, false)
+}
+
+// AddDetachedLines adds lines before and after the window of history of a
+// detached buffer, keeping the same lines on screen.
+func (bs *BufferList) AddDetachedLines(netID, title string, before, after []Line) {
+	_, b := bs.at(netID, title)
+	if b == nil || !b.detached {
+		return
+	}
+	n := len(b.lines)
+	bs.addLines(b, &b.lines, before, after, false)
+	if b == bs.cur() && len(after) > 0 {
+		for i := n + len(before); i < len(b.lines); i++ {
+			b.scrollAmt += b.lines[i].height(bs.ui.vx, bs.textWidth)
 		}
 	}
 }
 
+// Attach shows the live lines of a detached buffer again, scrolled to the
+// bottom. It returns false if the buffer is not detached.
+func (bs *BufferList) Attach(netID, title string) bool {
+	_, b := bs.at(netID, title)
+	if b == nil || !b.detached {
+		return false
+	}
+	b.lines = b.present
+	b.present = nil
+	b.detached = false
+	b.selected = 0
+	b.scrollAmt = 0
+	b.isAtTop = false
+	return true
+}
+
+func (bs *BufferList) IsDetached(netID, title string) bool {
+	_, b := bs.at(netID, title)
+	return b != nil && b.detached
+}
+
+// ScrollToTime scrolls the given buffer so that its first line at or after t
+// is at the top of the timeline.
+func (bs *BufferList) ScrollToTime(netID, title string, t time.Time) {
+	_, b := bs.at(netID, title)
+	if b == nil {
+		return
+	}
+	var target *Line
+	for i := range b.lines {
+		if !b.lines[i].At.Before(t) {
+			target = &b.lines[i]
+			break
+		}
+	}
+	if target == nil {
+		return
+	}
+	bs.forEachLine(b, func(line *Line, y int) bool {
+		if line != target {
+			return false
+		}
+		b.scrollAmt = y + line.height(bs.ui.vx, bs.textWidth) - bs.tlHeight
+		if b.scrollAmt < 0 {
+			b.scrollAmt = 0
+		}
+		return true
+	})
+}
+
 func (bs *BufferList) Focused() bool {
 	return bs.focused
 }
@@ -654,8 +1089,9 @@ func (bs *BufferList) SetRead(netID, title string, timestamp time.Time) {
 		return
 	}
 	clearRead := true
-	for i := len(b.lines) - 1; i >= 0; i-- {
-		line := &b.lines[i]
+	lines := *b.liveLines()
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := &lines[i]
 		if !line.At.After(timestamp) {
 			break
 		}
@@ -781,6 +1217,268 @@ func (bs *BufferList) Highlights() int {
 	return n
 }
 
+// SelectPrevious selects the message above the selected one, or the last
+// message if none is selected.
+func (bs *BufferList) SelectPrevious() bool {
+	b := bs.cur()
+	i := len(b.lines)
+	if b.selected > 0 {
+		i = b.selected - 1
+	}
+	for i--; i >= 0; i-- {
+		if isSelectable(&b.lines[i]) {
+			b.selected = i + 1
+			bs.scrollToLine(b, i)
+			return true
+		}
+	}
+	return false
+}
+
+// SelectNext selects the message below the selected one. Past the last
+// message, the selection is cleared.
+func (bs *BufferList) SelectNext() bool {
+	b := bs.cur()
+	if b.selected == 0 {
+		return false
+	}
+	for i := b.selected; i < len(b.lines); i++ {
+		if isSelectable(&b.lines[i]) {
+			b.selected = i + 1
+			bs.scrollToLine(b, i)
+			return true
+		}
+	}
+	b.selected = 0
+	return true
+}
+
+// SelectID selects the message with the given ID and scrolls to it.
+func (bs *BufferList) SelectID(netID, title, id string) bool {
+	_, b := bs.at(netID, title)
+	if b == nil || b != bs.cur() || id == "" {
+		return false
+	}
+	for i := len(b.lines) - 1; i >= 0; i-- {
+		if b.lines[i].ID == id {
+			b.selected = i + 1
+			bs.scrollToLine(b, i)
+			return true
+		}
+	}
+	return false
+}
+
+func (bs *BufferList) ClearSelection() bool {
+	b := bs.cur()
+	if b.selected == 0 {
+		return false
+	}
+	b.selected = 0
+	return true
+}
+
+// Selection returns the selected line of the current buffer, if any.
+func (bs *BufferList) Selection() (Line, bool) {
+	b := bs.cur()
+	if b.selected == 0 {
+		return Line{}, false
+	}
+	return b.lines[b.selected-1], true
+}
+
+// AddReaction adds or removes the reaction of user to the line with the given
+// ID. It returns false if no such line exists.
+func (bs *BufferList) AddReaction(netID, title, id, user, text string, remove bool) bool {
+	_, b := bs.at(netID, title)
+	if b == nil || id == "" {
+		return false
+	}
+	line := b.findLine(func(line *Line) bool {
+		return line.ID == id
+	})
+	if line == nil || line.Redacted {
+		return false
+	}
+	h := line.height(bs.ui.vx, bs.textWidth)
+	line.react(user, text, remove)
+	if b == bs.cur() && 0 < b.scrollAmt {
+		// Keep the same lines on screen if the line grew or shrunk.
+		b.scrollAmt += line.height(bs.ui.vx, bs.textWidth) - h
+	}
+	return true
+}
+
+// RedactLine replaces the body of the line with the given ID with tombstone,
+// and drops its data, reactions and link preview. It returns false if no
+// such line exists.
+func (bs *BufferList) RedactLine(netID, title, id string, tombstone StyledString) bool {
+	_, b := bs.at(netID, title)
+	if b == nil || id == "" {
+		return false
+	}
+	line := b.findLine(func(line *Line) bool {
+		return line.ID == id
+	})
+	if line == nil {
+		return false
+	}
+	h := line.height(bs.ui.vx, bs.textWidth)
+	line.Body = tombstone
+	line.Redacted = true
+	line.Data = nil // the deleted message
+	line.Reactions = nil
+	line.Card = nil
+	line.width = 0
+	line.computeSplitPoints(bs.ui.vx)
+	if b == bs.cur() && 0 < b.scrollAmt {
+		b.scrollAmt += line.height(bs.ui.vx, bs.textWidth) - h
+	}
+	return true
+}
+
+// SetCard sets the link preview of the last line of a buffer matching f,
+// keeping the same lines on screen. It returns false if there is no such line.
+func (bs *BufferList) SetCard(netID, title string, f func(line *Line) bool, card []StyledString) bool {
+	_, b := bs.at(netID, title)
+	if b == nil {
+		return false
+	}
+	line := b.findLine(f)
+	if line == nil {
+		return false
+	}
+	h := line.height(bs.ui.vx, bs.textWidth)
+	line.Card = card
+	if b == bs.cur() && 0 < b.scrollAmt {
+		b.scrollAmt += line.height(bs.ui.vx, bs.textWidth) - h
+	}
+	return true
+}
+
+// SetReplies sets the reply headers of the lines of a buffer replying to the
+// IDs of replies, such as replies to messages fetched later from history.
+func (bs *BufferList) SetReplies(netID, title string, replies map[string]StyledString) {
+	_, b := bs.at(netID, title)
+	if b == nil || len(replies) == 0 {
+		return
+	}
+	for _, lines := range [][]Line{b.lines, b.present} {
+		for i := range lines {
+			if reply, ok := replies[lines[i].ReplyTo]; ok {
+				// The header is a single row: the line height is unchanged.
+				lines[i].Reply = reply
+			}
+		}
+	}
+}
+
+// LineByID returns the most recent line with the given ID.
+func (bs *BufferList) LineByID(netID, title, id string) (Line, bool) {
+	if id == "" {
+		return Line{}, false
+	}
+	return bs.FindLine(netID, title, func(line *Line) bool {
+		return line.ID == id
+	})
+}
+
+// FindLine returns the most recent line for which f returns true.
+func (bs *BufferList) FindLine(netID, title string, f func(line *Line) bool) (Line, bool) {
+	_, b := bs.at(netID, title)
+	if b == nil {
+		return Line{}, false
+	}
+	if line := b.findLine(f); line != nil {
+		return *line, true
+	}
+	return Line{}, false
+}
+
+// Draft returns the input saved for a buffer when it was left.
+func (bs *BufferList) Draft(netID, title string) (text []rune, cursor int) {
+	_, b := bs.at(netID, title)
+	if b == nil {
+		return nil, 0
+	}
+	return b.draft, b.draftCursor
+}
+
+func (bs *BufferList) SetDraft(netID, title string, text []rune, cursor int) {
+	_, b := bs.at(netID, title)
+	if b == nil {
+		return
+	}
+	if len(text) == 0 {
+		text = nil
+	}
+	b.draft = text
+	b.draftCursor = cursor
+}
+
+// Lines returns the last n lines of a buffer, from oldest to newest.
+func (bs *BufferList) Lines(netID, title string, n int) ([]Line, bool) {
+	_, b := bs.at(netID, title)
+	if b == nil {
+		return nil, false
+	}
+	lines := *b.liveLines()
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return append([]Line(nil), lines...), true
+}
+
+// BufferInfo describes a buffer of the buffer list.
+type BufferInfo struct {
+	NetID      string
+	NetName    string
+	Title      string
+	Unread     bool
+	Highlights int
+	Pinned     bool
+	Muted      bool
+}
+
+// Buffers returns the buffers of the list, in order.
+func (bs *BufferList) Buffers() []BufferInfo {
+	infos := make([]BufferInfo, 0, len(bs.list))
+	for _, b := range bs.list {
+		infos = append(infos, BufferInfo{
+			NetID:      b.netID,
+			NetName:    b.netName,
+			Title:      b.title,
+			Unread:     b.unread,
+			Highlights: b.highlights,
+			Pinned:     b.pinned,
+			Muted:      b.muted,
+		})
+	}
+	return infos
+}
+
+func isSelectable(line *Line) bool {
+	return line.Readable && !line.Mergeable
+}
+
+// scrollToLine scrolls the timeline the least possible so that the line of
+// index i is shown.
+func (bs *BufferList) scrollToLine(b *buffer, i int) {
+	target := &b.lines[i]
+	bs.forEachLine(b, func(line *Line, y int) bool {
+		if line != target {
+			return false
+		}
+		h := line.height(bs.ui.vx, bs.textWidth)
+		if y < b.scrollAmt {
+			b.scrollAmt = y
+		} else if b.scrollAmt+bs.tlHeight < y+h {
+			b.scrollAmt = y + h - bs.tlHeight
+		}
+		return true
+	})
+}
+
 func (bs *BufferList) at(netID, title string) (int, *buffer) {
 	if netID == "" && title == Overlay {
 		return -1, bs.overlay
@@ -813,21 +1511,22 @@ func (bs *BufferList) forEachLine(b *buffer, f func(line *Line, y int) bool) boo
 		if f(line, y) {
 			return true
 		}
-		y += len(line.NewLines(bs.ui.vx, bs.textWidth)) + 1
+		y += line.height(bs.ui.vx, bs.textWidth)
 	}
 	return false
 }
 
 func (bs *BufferList) DrawVerticalBufferList(vx *Vaxis, x0, y0, width, height int, offset *int) {
-	if y0+len(bs.list)-*offset < height {
-		*offset = y0 + len(bs.list) - height
+	rows := bs.rows()
+	if y0+len(rows)-*offset < height {
+		*offset = y0 + len(rows) - height
 		if *offset < 0 {
 			*offset = 0
 		}
 	}
-	off := bs.VerticalBufferOffset(0, *offset)
-	if off < 0 {
-		off = len(bs.list)
+	off := *offset
+	if off > len(rows) {
+		off = len(rows)
 	}
 
 	width--
@@ -836,18 +1535,30 @@ func (bs *BufferList) DrawVerticalBufferList(vx *Vaxis, x0, y0, width, height in
 
 	indexPadding := 1 + int(math.Ceil(math.Log10(float64(len(bs.list)))))
 	y := y0
-	for i, b := range bs.list[off:] {
-		bi := off + i
+	for _, row := range rows[off:] {
 		x := x0
+		if row.buffer < 0 {
+			g := &bs.ui.config.BufferGroups[row.group]
+			unread, highlights := bs.groupStatus("", row.group)
+			bs.drawGroupHeader(vx, x, y, width, g.Name, bs.collapsedGroups[g.Name], unread, highlights)
+			y++
+			continue
+		}
+		bi := row.buffer
+		b := &bs.list[bi]
+		unread, highlights := b.unread, b.highlights
+		collapsed := b.title == "" && bs.collapsedNetworks[b.netID]
+		if collapsed {
+			unread, highlights = bs.groupStatus(b.netID, -1)
+		}
 		var st vaxis.Style
-		if b.unread && !b.muted {
-			st.Attribute |= vaxis.AttrBold
-			st.Foreground = bs.ui.config.Colors.Unread
+		if unread && !b.muted {
+			st = bs.ui.bufferUnreadStyle()
 		}
 		if bi == bs.current || bi == bs.clicked {
 			st.Attribute |= vaxis.AttrReverse
 		} else if b.muted {
-			st.Foreground = bs.ui.config.Colors.Gray
+			st = bs.ui.bufferMutedStyle()
 		}
 
 		var title string
@@ -858,9 +1569,6 @@ func (bs *BufferList) DrawVerticalBufferList(vx *Vaxis, x0, y0, width, height in
 		}
 
 		if bs.filterBuffers {
-			if !strings.Contains(strings.ToLower(title), bs.filterBuffersQuery) {
-				continue
-			}
 			indexSt := st
 			indexSt.Foreground = bs.ui.config.Colors.Gray
 			indexText := fmt.Sprintf("%d:", bi+1)
@@ -874,7 +1582,7 @@ func (bs *BufferList) DrawVerticalBufferList(vx *Vaxis, x0, y0, width, height in
 				st.Attribute |= vaxis.AttrReverse
 			}
 			if b.pinned {
-				st.Attribute |= vaxis.AttrBold
+				st = overlayStyle(st, bs.ui.bufferPinnedStyle())
 				setCell(vx, x, y, '⚲', st)
 				setCell(vx, x+1, y, ' ', st)
 			} else {
@@ -883,8 +1591,22 @@ func (bs *BufferList) DrawVerticalBufferList(vx *Vaxis, x0, y0, width, height in
 			}
 			x += 2
 		}
-		title = truncate(vx, title, width-(x-x0), "\u2026")
-		printString(vx, &x, y, Styled(title, st))
+		var suffix string
+		if collapsed {
+			suffix = " ▸"
+		} else if b.draft != nil {
+			suffix = " ✎"
+		}
+		if suffix != "" {
+			title = truncate(vx, title, width-(x-x0)-2, "\u2026")
+			printString(vx, &x, y, Styled(title, st))
+			suffixSt := st
+			suffixSt.Foreground = bs.ui.config.Colors.Gray
+			printString(vx, &x, y, Styled(suffix, suffixSt))
+		} else {
+			title = truncate(vx, title, width-(x-x0), "\u2026")
+			printString(vx, &x, y, Styled(title, st))
+		}
 
 		if bi == bs.current || bi == bs.clicked {
 			st := vaxis.Style{
@@ -897,11 +1619,9 @@ func (bs *BufferList) DrawVerticalBufferList(vx *Vaxis, x0, y0, width, height in
 			setCell(vx, x, y, '▐', st)
 		}
 
-		if b.highlights != 0 {
-			highlightSt := st
-			highlightSt.Foreground = ColorRed
-			highlightSt.Attribute |= vaxis.AttrReverse
-			highlightText := fmt.Sprintf(" %d ", b.highlights)
+		if highlights != 0 {
+			highlightSt := overlayStyle(st, bs.ui.bufferHighlightStyle())
+			highlightText := fmt.Sprintf(" %d ", highlights)
 			x = x0 + width - len(highlightText)
 			printString(vx, &x, y, Styled(highlightText, highlightSt))
 		}
@@ -910,6 +1630,33 @@ func (bs *BufferList) DrawVerticalBufferList(vx *Vaxis, x0, y0, width, height in
 	}
 }
 
+// drawGroupHeader draws the header of a user-defined group of the vertical
+// buffer list.
+func (bs *BufferList) drawGroupHeader(vx *Vaxis, x, y, width int, name string, collapsed, unread bool, highlights int) {
+	x0 := x
+	var st vaxis.Style
+	if unread {
+		st = bs.ui.bufferUnreadStyle()
+	}
+	st.Attribute |= vaxis.AttrItalic
+	if collapsed {
+		name = truncate(vx, name, width-2, "\u2026")
+		printString(vx, &x, y, Styled(name, st))
+		suffixSt := st
+		suffixSt.Foreground = bs.ui.config.Colors.Gray
+		printString(vx, &x, y, Styled(" ▸", suffixSt))
+	} else {
+		name = truncate(vx, name, width, "\u2026")
+		printString(vx, &x, y, Styled(name, st))
+	}
+	if highlights != 0 {
+		highlightSt := overlayStyle(st, bs.ui.bufferHighlightStyle())
+		highlightText := fmt.Sprintf(" %d ", highlights)
+		x = x0 + width - len(highlightText)
+		printString(vx, &x, y, Styled(highlightText, highlightSt))
+	}
+}
+
 func (bs *BufferList) HorizontalBufferOffset(x int, offset int) int {
 	if bs.filterBuffers {
 		offset = 0
@@ -943,29 +1690,18 @@ func (bs *BufferList) HorizontalBufferOffset(x int, offset int) int {
 }
 
 func (bs *BufferList) VerticalBufferOffset(y int, offset int) int {
-	if !bs.filterBuffers {
-		return offset + y
+	rows := bs.rows()
+	if y+offset < 0 {
+		return -1
 	}
-
-	for i, b := range bs.list {
-		var title string
-		if b.title == "" {
-			title = b.netName
-		} else {
-			title = b.title
-		}
-
+	if y+offset >= len(rows) {
 		if bs.filterBuffers {
-			if !strings.Contains(strings.ToLower(title), bs.filterBuffersQuery) {
-				continue
-			}
+			return -1
 		}
-		if y == 0 {
-			return i
-		}
-		y--
+		// Past the end of the list: the last buffer.
+		return len(bs.list)
 	}
-	return -1
+	return rows[y+offset].buffer
 }
 
 func (bs *BufferList) GetLeftMost(screenWidth int) int {
@@ -1038,15 +1774,14 @@ func (bs *BufferList) DrawHorizontalBufferList(vx *Vaxis, x0, y0, width int, off
 		}
 		var st vaxis.Style
 		if b.unread && !b.muted {
-			st.Attribute |= vaxis.AttrBold
-			st.Foreground = bs.ui.config.Colors.Unread
+			st = bs.ui.bufferUnreadStyle()
 		} else if i == bs.current {
 			st.UnderlineStyle = vaxis.UnderlineSingle
 		}
 		if i == bs.clicked {
 			st.Attribute |= vaxis.AttrReverse
 		} else if b.muted {
-			st.Foreground = bs.ui.config.Colors.Gray
+			st = overlayStyle(vaxis.Style{UnderlineStyle: st.UnderlineStyle}, bs.ui.bufferMutedStyle())
 		}
 
 		var title string
@@ -1067,8 +1802,7 @@ func (bs *BufferList) DrawHorizontalBufferList(vx *Vaxis, x0, y0, width int, off
 		printString(vx, &x, y0, Styled(title, st))
 
 		if 0 < b.highlights {
-			st.Foreground = ColorRed
-			st.Attribute |= vaxis.AttrReverse
+			st = overlayStyle(st, bs.ui.bufferHighlightStyle())
 			setCell(vx, x, y0, ' ', st)
 			x++
 			printNumber(vx, &x, y0, st, b.highlights)
@@ -1118,7 +1852,8 @@ func (bs *BufferList) DrawTimeline(ui *UI, x0, y0, nickColWidth int) {
 	{
 		// TODO: factorize this (same code for drawing timeline)
 
-		var st vaxis.Style
+		topicSt := bs.ui.topicStyle()
+		st := topicSt
 		nextStyles := b.topic.styles
 
 		sr := []rune(b.topic.string)
@@ -1131,11 +1866,11 @@ func (bs *BufferList) DrawTimeline(ui *UI, x0, y0, nickColWidth int) {
 		sr = sr[ri:]
 		for len(sr) > 0 {
 			if 0 < len(nextStyles) && nextStyles[0].Start == i {
-				st = nextStyles[0].Style
+				st = overlayStyle(topicSt, nextStyles[0].Style)
 				nextStyles = nextStyles[1:]
 
 				if (bs.ui.mouseLinks || st.Hyperlink == "") && st.HyperlinkParams != "" && st.UnderlineStyle == 0 {
-					st.UnderlineStyle = vaxis.UnderlineDotted
+					st = overlayStyle(st, bs.ui.linkStyle())
 				}
 			}
 			dx, di := printCluster(vx, xTopic, y0, -1, sr, st)
@@ -1172,6 +1907,9 @@ func (bs *BufferList) DrawTimeline(ui *UI, x0, y0, nickColWidth int) {
 				})
 			}
 		}
+		for ; xTopic < x0+bs.tlInnerWidth+nickColWidth+9; xTopic++ {
+			setCell(vx, xTopic, y0, ' ', topicSt)
+		}
 	}
 	y0++
 	bs.ui.drawHorizontalLine(vx, x0, y0, bs.tlInnerWidth+nickColWidth+9)
@@ -1206,50 +1944,69 @@ func (bs *BufferList) DrawTimeline(ui *UI, x0, y0, nickColWidth int) {
 			}
 		}
 
-		yi -= len(nls) + 1
+		yi -= line.height(bs.ui.vx, bs.textWidth)
 		if y0+bs.tlHeight <= yi {
 			continue
 		}
 
-		showDate := bs.shouldShowDate(b, i, yi, y0)
-		if showDate {
-			st := vaxis.Style{
-				Attribute: vaxis.AttrBold,
+		yh := yi
+		if line.Reply.string != "" {
+			if yi >= y0 {
+				x := x1
+				reply := line.Reply
+				reply.string = truncate(vx, reply.string, bs.textWidth, "\u2026")
+				printString(vx, &x, yi, reply)
+				ui.clickEvents = append(ui.clickEvents, clickEvent{
+					xb: x1,
+					xe: x,
+					y:  yi,
+					event: &events.EventClickReply{
+						EventClick: events.EventClick{
+							NetID:  b.netID,
+							Buffer: b.title,
+						},
+						ID: line.ReplyTo,
+					},
+				})
 			}
+			yh++
+		}
+
+		showDate := bs.shouldShowDate(b, i, yh, y0)
+		if showDate {
+			st := bs.ui.dateSeparatorStyle()
 			// as a special case, always draw the first visible message date, even if it is a continuation line
-			yd := yi
+			yd := yh
 			if yd < y0 {
 				yd = y0
 			}
 			printDate(vx, x0, yd, st, line.At.Local())
 		} else {
-			showTime := b.lines[i-1].At.Truncate(time.Minute) != line.At.Truncate(time.Minute) && yi >= y0
+			showTime := b.lines[i-1].At.Truncate(time.Minute) != line.At.Truncate(time.Minute) && yh >= y0
 			if !showTime {
 				// also try to show the time if we previously drew the date
-				yp := yi - (len(b.lines[i-1].NewLines(bs.ui.vx, bs.textWidth)) + 1)
+				yp := yi - b.lines[i-1].height(bs.ui.vx, bs.textWidth)
 				showTime = i == 0 || bs.shouldShowDate(b, i-1, yp, y0)
 			}
 			if showTime {
-				st := vaxis.Style{
-					Foreground: bs.ui.config.Colors.Gray,
-				}
-				printTime(vx, x0, yi, st, line.At.Local())
+				st := bs.ui.timestampStyle()
+				printTime(vx, x0, yh, st, line.At.Local())
 			}
 		}
 
-		if yi >= y0 {
-			head := line.Head
-			if line.Highlight && len(line.Head.styles) > 0 {
+		if yh >= y0 {
+			head := bs.ui.resolveStyles(line.Head)
+			if line.Highlight && len(head.styles) > 0 {
 				var sb StyledStringBuilder
 				sb.WriteString(head.string)
-				for _, st := range line.Head.styles {
+				for _, st := range head.styles {
 					s := st.Style
 					s.Attribute |= vaxis.AttrReverse
 					sb.AddStyle(st.Start, s)
 				}
 				head = sb.StyledString()
 			}
-			xb, xe := printIdent(vx, x0+7, yi, nickColWidth, head)
+			xb, xe := printIdent(vx, x0+7, yh, nickColWidth, head)
 
 			lastHead := line.Head.string
 			if len(line.Head.styles) > 0 {
@@ -1260,7 +2017,7 @@ func (bs *BufferList) DrawTimeline(ui *UI, x0, y0, nickColWidth int) {
 				ui.clickEvents = append(ui.clickEvents, clickEvent{
 					xb: xb,
 					xe: xe,
-					y:  yi,
+					y:  yh,
 					event: &events.EventClickNick{
 						EventClick: events.EventClick{
 							NetID:  b.netID,
@@ -1273,19 +2030,21 @@ func (bs *BufferList) DrawTimeline(ui *UI, x0, y0, nickColWidth int) {
 		}
 
 		x := x1
-		y := yi
+		y := yh
 		var style vaxis.Style
-		nextStyles := line.Body.styles
+		nextStyles := bs.ui.resolveStyles(line.Body).styles
+		selected := b.selected == i+1
 
 		lbi := 0
 		l := []rune(line.Body.string)
+		afterBreak := false // whether the row starts after a line break, keeping its indentation
 		for len(l) > 0 {
 			if 0 < len(nextStyles) && nextStyles[0].Start == lbi {
 				style = nextStyles[0].Style
 				nextStyles = nextStyles[1:]
 
 				if (bs.ui.mouseLinks || style.Hyperlink == "") && style.HyperlinkParams != "" && style.UnderlineStyle == 0 {
-					style.UnderlineStyle = vaxis.UnderlineDotted
+					style = overlayStyle(style, bs.ui.linkStyle())
 				}
 			}
 			if 0 < len(nls) && lbi == nls[0] {
@@ -1297,15 +2056,26 @@ func (bs *BufferList) DrawTimeline(ui *UI, x0, y0, nickColWidth int) {
 				}
 			}
 
-			if y != yi && x == x1 && IsSplitRune(l[0]) {
+			if l[0] == '\n' {
+				lbi++
+				l = l[1:]
+				afterBreak = true
+				continue
+			}
+			if y != yh && x == x1 && IsSplitRune(l[0]) && !afterBreak {
 				lbi += len(string(l[0]))
 				l = l[1:]
 				continue
 			}
+			afterBreak = false
 
 			xb := x
 			if y >= y0 {
-				dx, di := printCluster(vx, x, y, -1, l, style)
+				st := style
+				if selected {
+					st.Attribute |= vaxis.AttrReverse
+				}
+				dx, di := printCluster(vx, x, y, -1, l, st)
 				x += dx
 				lbi += len(string(l[:di]))
 				l = l[di:]
@@ -1328,6 +2098,7 @@ func (bs *BufferList) DrawTimeline(ui *UI, x0, y0, nickColWidth int) {
 						},
 						Link:  style.Hyperlink,
 						Mouse: ui.mouseLinks,
+						Data:  line.Data,
 					},
 				})
 			} else if _, channel, ok := strings.Cut(style.HyperlinkParams, "="); ok {
@@ -1345,6 +2116,32 @@ func (bs *BufferList) DrawTimeline(ui *UI, x0, y0, nickColWidth int) {
 				})
 			}
 		}
+
+		if len(line.Reactions) > 0 {
+			y := yh + len(line.NewLines(bs.ui.vx, bs.textWidth)) + 1
+			if y0 <= y && y < y0+bs.tlHeight {
+				x := x1 + 2
+				st := vaxis.Style{
+					Foreground: bs.ui.config.Colors.Gray,
+				}
+				reactions := truncate(vx, line.reactionsString(), bs.textWidth-2, "\u2026")
+				printString(vx, &x, y, Styled(reactions, st))
+			}
+		}
+
+		y = yh + len(line.NewLines(bs.ui.vx, bs.textWidth)) + 1
+		if len(line.Reactions) > 0 {
+			y++
+		}
+		for _, row := range line.Card {
+			if y0 <= y && y < y0+bs.tlHeight {
+				x := x1
+				printString(vx, &x, y, Styled("\u2502 ", bs.ui.cardStyle()))
+				row.string = truncate(vx, row.string, bs.textWidth-2, "\u2026")
+				printString(vx, &x, y, bs.ui.cardRow(row))
+			}
+			y++
+		}
 	}
 
 	b.isAtTop = y0 <= yi
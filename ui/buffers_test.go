@@ -1,6 +1,7 @@
 package ui
 
 import (
+	"slices"
 	"strings"
 	"testing"
 )
@@ -144,4 +145,257 @@ func TestRenderedHeight(t *testing.T) {
 
 	assertNewLines(t, "take cares", 5, 2) // |take |cares|
 	assertNewLines(t, "tak cares", 5, 2)  // |tak  |cares|
+
+	// Multiline messages
+	assertNewLines(t, "hello\nworld", 20, 2)      // |hello|world|
+	assertNewLines(t, "hello\n\nworld", 20, 3)    // |hello||world|
+	assertNewLines(t, "take care\nbye", 5, 3)     // |take |care|bye|
+	assertNewLines(t, "hello\n  indented", 20, 2) // |hello|  indented|
+}
+
+func TestSelection(t *testing.T) {
+	bs := NewBufferList(&UI{})
+	bs.ResizeTimeline(80, 24, 80)
+	bs.Add("net", "net", "")
+	bs.Add("net", "", "#chan")
+	bs.To(1)
+	bs.AddLine("net", "#chan", Line{Body: PlainString("first"), Readable: true, ID: "1"})
+	bs.AddLine("net", "#chan", Line{Body: PlainString("status")})
+	bs.AddLine("net", "#chan", Line{Body: PlainString("second"), Readable: true, ID: "2"})
+
+	if _, ok := bs.Selection(); ok {
+		t.Fatalf("expected no selection")
+	}
+	bs.SelectPrevious()
+	if l, ok := bs.Selection(); !ok || l.ID != "2" {
+		t.Fatalf("expected selection of message 2, got %q", l.ID)
+	}
+	bs.SelectPrevious()
+	if l, _ := bs.Selection(); l.ID != "1" {
+		t.Fatalf("expected selection of message 1, got %q", l.ID)
+	}
+
+	bs.AddLines("net", "#chan", []Line{{Body: PlainString("zeroth"), Readable: true, ID: "0"}}, nil)
+	if l, _ := bs.Selection(); l.ID != "1" {
+		t.Fatalf("expected selection of message 1 after adding history, got %q", l.ID)
+	}
+
+	bs.SelectNext()
+	if l, _ := bs.Selection(); l.ID != "2" {
+		t.Fatalf("expected selection of message 2, got %q", l.ID)
+	}
+	bs.SelectNext()
+	if _, ok := bs.Selection(); ok {
+		t.Fatalf("expected no selection past the last message")
+	}
+
+	if !bs.SelectID("net", "#chan", "0") {
+		t.Fatalf("expected message 0 to be found")
+	}
+	bs.To(0)
+	bs.To(1)
+	if _, ok := bs.Selection(); ok {
+		t.Fatalf("expected selection to be cleared when switching buffers")
+	}
+}
+
+func TestDetach(t *testing.T) {
+	bs := NewBufferList(&UI{})
+	bs.ResizeTimeline(80, 24, 80)
+	bs.Add("net", "net", "")
+	bs.Add("net", "", "#chan")
+	bs.To(1)
+	bs.AddLine("net", "#chan", Line{Body: PlainString("live"), ID: "live"})
+
+	bs.Detach("net", "#chan", []Line{{Body: PlainString("old"), ID: "old"}})
+	bs.AddLine("net", "#chan", Line{Body: PlainString("new"), ID: "new"})
+	bs.AddDetachedLines("net", "#chan", []Line{{Body: PlainString("older"), ID: "older"}}, nil)
+	if ids := lineIDs(bs.cur().lines); !slices.Equal(ids, []string{"older", "old"}) {
+		t.Errorf("detached: got lines %q", ids)
+	}
+	if l, ok := bs.FindLine("net", "#chan", func(l *Line) bool { return l.ID == "new" }); !ok || l.ID != "new" {
+		t.Errorf("detached: expected live lines to be searched")
+	}
+
+	if !bs.Attach("net", "#chan") {
+		t.Fatalf("expected buffer to be detached")
+	}
+	if ids := lineIDs(bs.cur().lines); !slices.Equal(ids, []string{"live", "new"}) {
+		t.Errorf("attached: got lines %q", ids)
+	}
+	if bs.Attach("net", "#chan") {
+		t.Errorf("expected buffer to be attached")
+	}
+}
+
+func TestSetReplies(t *testing.T) {
+	bs := NewBufferList(&UI{})
+	bs.ResizeTimeline(80, 24, 80)
+	bs.Add("net", "net", "")
+	bs.Add("net", "", "#chan")
+	bs.To(1)
+	bs.AddLine("net", "#chan", Line{Body: PlainString("live"), ID: "live", ReplyTo: "old", Reply: PlainString("not loaded")})
+	bs.Detach("net", "#chan", []Line{{Body: PlainString("reply"), ID: "reply", ReplyTo: "old", Reply: PlainString("not loaded")}})
+
+	bs.SetReplies("net", "#chan", map[string]StyledString{"old": PlainString("old")})
+	for _, id := range []string{"live", "reply"} {
+		if l, _ := bs.LineByID("net", "#chan", id); l.Reply.String() != "old" {
+			t.Errorf("line %q: expected reply header %q, got %q", id, "old", l.Reply.String())
+		}
+	}
+}
+
+func lineIDs(lines []Line) []string {
+	var ids []string
+	for _, l := range lines {
+		ids = append(ids, l.ID)
+	}
+	return ids
+}
+
+func TestReactions(t *testing.T) {
+	var l Line
+	l.react("alice", "👍", false)
+	l.react("bob", "👍", false)
+	l.react("bob", "👍", false)
+	l.react("alice", "🎉", false)
+	if s := l.reactionsString(); s != "👍 2  🎉 1" {
+		t.Errorf("expected reactions %q, got %q", "👍 2  🎉 1", s)
+	}
+	l.react("alice", "🎉", true)
+	l.react("carol", "👀", true)
+	if s := l.reactionsString(); s != "👍 2" {
+		t.Errorf("expected reactions %q, got %q", "👍 2", s)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	bs := NewBufferList(&UI{})
+	bs.ResizeTimeline(80, 24, 80)
+	bs.Add("net", "net", "")
+	bs.Add("net", "", "#chan")
+	bs.To(1)
+	bs.AddLine("net", "#chan", Line{Body: PlainString("first"), ID: "0", Data: "first"})
+	bs.AddLine("net", "#chan", Line{
+		Body: PlainString("second"),
+		ID:   "1",
+		Data: "second",
+		Card: []StyledString{PlainString("title")},
+	})
+	bs.AddReaction("net", "#chan", "1", "alice", "👍", false)
+	bs.cur().scrollAmt = 5
+
+	if bs.RedactLine("net", "#chan", "unknown", PlainString("deleted")) {
+		t.Errorf("expected unknown message not to be found")
+	}
+	if !bs.RedactLine("net", "#chan", "1", PlainString("deleted")) {
+		t.Fatalf("expected message 1 to be found")
+	}
+	l, _ := bs.LineByID("net", "#chan", "1")
+	if !l.Redacted || l.Body.String() != "deleted" {
+		t.Errorf("expected tombstone, got %q", l.Body.String())
+	}
+	if l.Data != nil || l.Reactions != nil || l.Card != nil {
+		t.Errorf("expected data, reactions and card to be dropped")
+	}
+	// The reactions and card rows are gone.
+	if amt := bs.cur().scrollAmt; amt != 3 {
+		t.Errorf("expected scroll amount 3, got %d", amt)
+	}
+	if bs.AddReaction("net", "#chan", "1", "bob", "👍", false) {
+		t.Errorf("expected reactions to deleted messages to be ignored")
+	}
+	// Deleting again targets the previous message.
+	l, ok := bs.FindLine("net", "#chan", func(l *Line) bool {
+		return l.ID != "" && !l.Redacted
+	})
+	if !ok || l.ID != "0" {
+		t.Errorf("expected message 0 to be found, got %q", l.ID)
+	}
+}
+
+func TestBufferGroups(t *testing.T) {
+	bs := NewBufferList(&UI{
+		config: Config{
+			BufferGroups: []BufferGroup{{
+				Name:     "work",
+				Channels: []string{"#team", "#ops"},
+			}},
+		},
+	})
+	bs.Add("a", "alpha", "")
+	bs.Add("a", "", "#zoo")
+	bs.Add("a", "", "#team")
+	bs.Add("b", "beta", "")
+	bs.Add("b", "", "#ops")
+	bs.Add("b", "", "#bar")
+	i, _ := bs.at("a", "")
+	bs.To(i)
+
+	titles := func() []string {
+		var titles []string
+		for _, row := range bs.rows() {
+			if row.buffer < 0 {
+				titles = append(titles, "["+bs.ui.config.BufferGroups[row.group].Name+"]")
+				continue
+			}
+			b := bs.list[row.buffer]
+			titles = append(titles, b.netName+"/"+b.title)
+		}
+		return titles
+	}
+	assertRows := func(expected ...string) {
+		t.Helper()
+		if got := titles(); !slices.Equal(got, expected) {
+			t.Errorf("got rows %q, expected %q", got, expected)
+		}
+	}
+
+	assertRows("[work]", "beta/#ops", "alpha/#team", "alpha/", "alpha/#zoo", "beta/", "beta/#bar")
+
+	i, _ = bs.at("b", "#bar")
+	bs.list[i].unread = true
+	bs.list[i].highlights = 2
+	bs.SetAllCollapsed(true)
+	// The current buffer is always visible.
+	assertRows("[work]", "alpha/", "beta/")
+	if unread, highlights := bs.groupStatus("b", -1); !unread || highlights != 2 {
+		t.Errorf("got network status %v, %v, expected true, 2", unread, highlights)
+	}
+
+	i, _ = bs.at("a", "#team")
+	bs.To(i)
+	assertRows("[work]", "alpha/#team", "alpha/", "beta/")
+	bs.ToggleCollapsed()
+	assertRows("[work]", "beta/#ops", "alpha/#team", "alpha/", "beta/")
+
+	if !bs.ToggleCollapsedAt(0, 0) {
+		t.Errorf("expected a group header at row 0")
+	}
+	if bs.ToggleCollapsedAt(1, 0) {
+		t.Errorf("expected no group header at row 1")
+	}
+	assertRows("[work]", "alpha/#team", "alpha/", "beta/")
+}
+
+// TestSwitchDraft checks that the text being written, rather than the history
+// being browsed, is kept as the draft of a buffer, without a main network.
+func TestSwitchDraft(t *testing.T) {
+	ui := NewHeadless(Config{})
+	ui.AddBuffer("n", "net", "")
+	ui.AddBuffer("n", "net", "#a")
+
+	putString(&ui.e, "sent")
+	ui.e.Flush()
+	putString(&ui.e, "typing")
+	ui.e.Up()
+
+	ui.NextBuffer()
+	if content := string(ui.InputContent()); content != "" {
+		t.Errorf("#a: got draft %q, expected none", content)
+	}
+	ui.PreviousBuffer()
+	if content := string(ui.InputContent()); content != "typing" {
+		t.Errorf("net: got draft %q, expected %q", content, "typing")
+	}
 }
@@ -5,26 +5,51 @@ package ui
 import (
 	"fmt"
 	"net/url"
+	"slices"
 	"sync"
 
 	"github.com/godbus/dbus/v5"
 )
 
-var dbusConn *dbus.Conn
+// dbusBus is the part of *dbus.Conn used by senpai, so that the session bus
+// can be replaced in tests.
+type dbusBus interface {
+	Object(dest string, path dbus.ObjectPath) dbus.BusObject
+	AddMatchSignal(options ...dbus.MatchOption) error
+	Signal(ch chan<- *dbus.Signal)
+	Close() error
+}
+
+var sessionBus = func() (dbusBus, error) {
+	return dbus.SessionBus()
+}
+
+var dbusConn dbusBus
 var dbusLock sync.Mutex
 
 var notifications = make(map[int]*NotifyEvent)
 
+// dbusInlineReply is whether the notification server supports replying to
+// notifications.
+var dbusInlineReply bool
+
 func notifyDBus(title, content string) int {
-	conn, err := dbus.SessionBus()
+	conn, err := sessionBus()
 	if err != nil {
 		return -1
 	}
+	actions := []string{
+		"default", "Open",
+		"mark-read", "Mark as read",
+	}
+	dbusLock.Lock()
+	if dbusInlineReply {
+		actions = append(actions, "inline-reply", "Reply")
+	}
+	dbusLock.Unlock()
 	var r uint32
 	obj := conn.Object("org.freedesktop.Notifications", "/org/freedesktop/Notifications")
-	err = obj.Call("org.freedesktop.Notifications.Notify", 0, "senpai", uint32(0), "senpai", title, content, []string{
-		"default", "Open",
-	}, map[string]dbus.Variant{
+	err = obj.Call("org.freedesktop.Notifications.Notify", 0, "senpai", uint32(0), "senpai", title, content, actions, map[string]dbus.Variant{
 		"category":      dbus.MakeVariant("im.received"),
 		"desktop-entry": dbus.MakeVariant("senpai"),
 		"image-path":    dbus.MakeVariant("senpai"),
@@ -52,7 +77,7 @@ func (ui *UI) notify(target NotifyEvent, title, content string) int {
 }
 
 func notifyClose(id int) {
-	conn, err := dbus.SessionBus()
+	conn, err := sessionBus()
 	if err != nil {
 		return
 	}
@@ -61,7 +86,7 @@ func notifyClose(id int) {
 }
 
 func Screenshot() error {
-	conn, err := dbus.SessionBus()
+	conn, err := sessionBus()
 	if err != nil {
 		return fmt.Errorf("failed to connect to D-Bus: %v", err)
 	}
@@ -77,14 +102,13 @@ func Screenshot() error {
 }
 
 func DBusStart(callback func(any)) {
-	conn, err := dbus.SessionBus()
+	conn, err := sessionBus()
 	if err != nil {
 		return
 	}
 	if err := conn.AddMatchSignal(
 		dbus.WithMatchObjectPath("/org/freedesktop/Notifications"),
 		dbus.WithMatchInterface("org.freedesktop.Notifications"),
-		dbus.WithMatchSender("org.freedesktop.Notifications.ActionInvoked"),
 	); err != nil {
 		return
 	}
@@ -95,6 +119,13 @@ func DBusStart(callback func(any)) {
 	); err != nil {
 		return
 	}
+	var caps []string
+	obj := conn.Object("org.freedesktop.Notifications", "/org/freedesktop/Notifications")
+	if err := obj.Call("org.freedesktop.Notifications.GetCapabilities", 0).Store(&caps); err == nil {
+		dbusLock.Lock()
+		dbusInlineReply = slices.Contains(caps, "inline-reply")
+		dbusLock.Unlock()
+	}
 	c := make(chan *dbus.Signal, 64)
 	conn.Signal(c)
 	dbusLock.Lock()
@@ -102,36 +133,63 @@ func DBusStart(callback func(any)) {
 	dbusLock.Unlock()
 	go func() {
 		for v := range c {
-			switch v.Name {
-			case "org.freedesktop.Notifications.NotificationClosed":
-				id := int(v.Body[0].(uint32))
-				dbusLock.Lock()
-				delete(notifications, id)
-				dbusLock.Unlock()
-			case "org.freedesktop.Notifications.ActionInvoked":
-				id := int(v.Body[0].(uint32))
-				dbusLock.Lock()
-				target, ok := notifications[id]
-				dbusLock.Unlock()
-				if ok {
-					callback(target)
-				}
-			case "org.freedesktop.portal.Request.Response":
-				status := v.Body[0].(uint32)
-				results := v.Body[1].(map[string]dbus.Variant)
-				if status == 0 /* success */ {
-					uri := results["uri"].Value().(string)
-					if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
-						callback(&ScreenshotEvent{
-							Path: u.Path,
-						})
-					}
-				}
-			}
+			handleDBusSignal(v, callback)
 		}
 	}()
 }
 
+func handleDBusSignal(v *dbus.Signal, callback func(any)) {
+	switch v.Name {
+	case "org.freedesktop.Notifications.NotificationClosed":
+		id := int(v.Body[0].(uint32))
+		dbusLock.Lock()
+		delete(notifications, id)
+		dbusLock.Unlock()
+	case "org.freedesktop.Notifications.ActionInvoked":
+		id := int(v.Body[0].(uint32))
+		action := v.Body[1].(string)
+		dbusLock.Lock()
+		target, ok := notifications[id]
+		dbusLock.Unlock()
+		if !ok {
+			break
+		}
+		if action == "mark-read" {
+			callback(&NotifyReadEvent{
+				NetID:  target.NetID,
+				Buffer: target.Buffer,
+				At:     target.At,
+			})
+		} else {
+			callback(target)
+		}
+	case "org.freedesktop.Notifications.NotificationReplied":
+		id := int(v.Body[0].(uint32))
+		text := v.Body[1].(string)
+		dbusLock.Lock()
+		target, ok := notifications[id]
+		dbusLock.Unlock()
+		if ok && text != "" {
+			callback(&NotifyReplyEvent{
+				NetID:  target.NetID,
+				Buffer: target.Buffer,
+				Text:   text,
+			})
+		}
+	case "org.freedesktop.portal.Request.Response":
+		status := v.Body[0].(uint32)
+		results := v.Body[1].(map[string]dbus.Variant)
+		if status == 0 /* success */ {
+			uri := results["uri"].Value().(string)
+			if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+				callback(&ScreenshotEvent{
+					Path: u.Path,
+				})
+			}
+		}
+	}
+}
+
 func DBusStop() {
 	dbusLock.Lock()
 	c := dbusConn
@@ -0,0 +1,181 @@
+//go:build linux
+
+package ui
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+type fakeCall struct {
+	method string
+	args   []interface{}
+}
+
+// fakeBus is an in-memory session bus with a notification server.
+type fakeBus struct {
+	lock    sync.Mutex
+	caps    []string
+	calls   []fakeCall
+	signals chan<- *dbus.Signal
+}
+
+func (b *fakeBus) Object(dest string, path dbus.ObjectPath) dbus.BusObject {
+	return &fakeObject{bus: b}
+}
+
+func (b *fakeBus) AddMatchSignal(options ...dbus.MatchOption) error {
+	return nil
+}
+
+func (b *fakeBus) Signal(ch chan<- *dbus.Signal) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.signals = ch
+}
+
+func (b *fakeBus) Close() error {
+	return nil
+}
+
+func (b *fakeBus) emit(name string, body ...interface{}) {
+	b.lock.Lock()
+	ch := b.signals
+	b.lock.Unlock()
+	ch <- &dbus.Signal{
+		Path: "/org/freedesktop/Notifications",
+		Name: name,
+		Body: body,
+	}
+}
+
+type fakeObject struct {
+	dbus.BusObject
+	bus *fakeBus
+}
+
+func (o *fakeObject) Call(method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
+	o.bus.lock.Lock()
+	defer o.bus.lock.Unlock()
+	o.bus.calls = append(o.bus.calls, fakeCall{method: method, args: args})
+	switch method {
+	case "org.freedesktop.Notifications.GetCapabilities":
+		return &dbus.Call{Body: []interface{}{o.bus.caps}}
+	case "org.freedesktop.Notifications.Notify":
+		return &dbus.Call{Body: []interface{}{uint32(42)}}
+	default:
+		return &dbus.Call{}
+	}
+}
+
+func startFakeBus(t *testing.T, caps []string) (*fakeBus, chan any) {
+	bus := &fakeBus{caps: caps}
+	sessionBus = func() (dbusBus, error) {
+		return bus, nil
+	}
+	t.Cleanup(func() {
+		sessionBus = func() (dbusBus, error) {
+			return dbus.SessionBus()
+		}
+		dbusLock.Lock()
+		dbusConn = nil
+		dbusInlineReply = false
+		notifications = make(map[int]*NotifyEvent)
+		dbusLock.Unlock()
+	})
+	events := make(chan any, 8)
+	DBusStart(func(ev any) {
+		events <- ev
+	})
+	return bus, events
+}
+
+func receiveEvent(t *testing.T, events chan any) any {
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+		return nil
+	}
+}
+
+func TestNotifyActions(t *testing.T) {
+	for _, tc := range []struct {
+		caps  []string
+		reply bool
+	}{
+		{caps: []string{"body", "actions"}, reply: false},
+		{caps: []string{"body", "actions", "inline-reply"}, reply: true},
+	} {
+		bus, _ := startFakeBus(t, tc.caps)
+		if id := notifyDBus("#senpai", "hi"); id != 42 {
+			t.Fatalf("got notification id %d, expected 42", id)
+		}
+		var actions []string
+		for _, c := range bus.calls {
+			if c.method == "org.freedesktop.Notifications.Notify" {
+				actions = c.args[5].([]string)
+			}
+		}
+		if !slices.Contains(actions, "mark-read") {
+			t.Errorf("caps %v: actions %q: missing mark-read", tc.caps, actions)
+		}
+		if slices.Contains(actions, "inline-reply") != tc.reply {
+			t.Errorf("caps %v: actions %q: inline-reply expected: %v", tc.caps, actions, tc.reply)
+		}
+	}
+}
+
+func TestNotifySignals(t *testing.T) {
+	bus, events := startFakeBus(t, []string{"actions", "inline-reply"})
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	target := NotifyEvent{
+		NetID:  "libera",
+		Buffer: "#senpai",
+		At:     at,
+	}
+	dbusLock.Lock()
+	notifications[42] = &target
+	dbusLock.Unlock()
+
+	bus.emit("org.freedesktop.Notifications.ActionInvoked", uint32(42), "default")
+	if ev, ok := receiveEvent(t, events).(*NotifyEvent); !ok || *ev != target {
+		t.Errorf("default action: got %#v, expected %#v", ev, &target)
+	}
+
+	bus.emit("org.freedesktop.Notifications.ActionInvoked", uint32(42), "mark-read")
+	expectedRead := NotifyReadEvent{
+		NetID:  "libera",
+		Buffer: "#senpai",
+		At:     at,
+	}
+	if ev, ok := receiveEvent(t, events).(*NotifyReadEvent); !ok || *ev != expectedRead {
+		t.Errorf("mark-read action: got %#v, expected %#v", ev, &expectedRead)
+	}
+
+	bus.emit("org.freedesktop.Notifications.NotificationReplied", uint32(42), "on my way")
+	expectedReply := NotifyReplyEvent{
+		NetID:  "libera",
+		Buffer: "#senpai",
+		Text:   "on my way",
+	}
+	if ev, ok := receiveEvent(t, events).(*NotifyReplyEvent); !ok || *ev != expectedReply {
+		t.Errorf("reply: got %#v, expected %#v", ev, &expectedReply)
+	}
+
+	// Signals about unknown notifications, such as those from other
+	// applications, are ignored.
+	bus.emit("org.freedesktop.Notifications.NotificationClosed", uint32(42), uint32(2))
+	bus.emit("org.freedesktop.Notifications.ActionInvoked", uint32(42), "default")
+	bus.emit("org.freedesktop.Notifications.ActionInvoked", uint32(7), "mark-read")
+	select {
+	case ev := <-events:
+		t.Errorf("got unexpected event %#v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
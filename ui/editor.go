@@ -2,6 +2,7 @@ package ui
 
 import (
 	"strings"
+	"unicode"
 
 	"git.sr.ht/~delthas/senpai/events"
 	"git.sr.ht/~rockorager/vaxis"
@@ -21,6 +22,46 @@ type Completion struct {
 type editorLine struct {
 	runes    []rune
 	clusters []int
+
+	// buffer the line was sent in, for lines of the history.
+	netID  string
+	buffer string
+}
+
+// HistoryEntry is a message or command sent from the editor.
+type HistoryEntry struct {
+	NetID  string
+	Buffer string
+	Text   string
+}
+
+// editorState is a snapshot of the text being written, to undo changes.
+type editorState struct {
+	runes  []rune
+	cursor int // in runes
+}
+
+// editKind is the kind of a change of the text. Consecutive changes of the
+// same kind, such as typing a word, are undone at once.
+type editKind int
+
+const (
+	editNone editKind = iota
+	editInsert
+	editDelete
+	editOther // never merged with other changes
+)
+
+const (
+	maxKillRing = 16
+	maxUndo     = 100
+)
+
+// editorYank is the text last inserted by Yank, which YankPop replaces.
+type editorYank struct {
+	start int // in runes
+	end   int // in runes
+	idx   int // index in the kill ring of the inserted text
 }
 
 func newEditorLine() editorLine {
@@ -34,6 +75,8 @@ func (l *editorLine) copy() editorLine {
 	return editorLine{
 		runes:    append([]rune{}, l.runes...),
 		clusters: append([]int{}, l.clusters...),
+		netID:    l.netID,
+		buffer:   l.buffer,
 	}
 }
 
@@ -70,12 +113,30 @@ type Editor struct {
 
 	backsearch        bool
 	backsearchPattern []rune // pre-lowercased
+	backsearchBuffer  bool   // only search lines sent in the current buffer
+
+	// buffer the text is written in.
+	netID  string
+	buffer string
 
 	// oldest (lowest) index in text of lines that were changed.
 	// used as an optimization to reduce copying when flushing lines.
 	oldestTextChange int
 
 	typos []events.TypoRange
+
+	// killRing contains the text last killed, most recent first.
+	killRing [][]rune
+	yank     *editorYank
+
+	undo     []editorState
+	redo     []editorState
+	lastEdit editKind
+
+	// viNormal is true in the normal state of the vi editing mode.
+	viNormal  bool
+	viPending []rune // keys of the vi command being typed
+	viFind    viFind // last f, F, t or T motion, repeated by ; and ,
 }
 
 // NewEditor returns a new Editor.
@@ -156,6 +217,7 @@ func (e *Editor) PutRune(r rune) {
 			return
 		}
 	}
+	e.saveUndo(editInsert)
 	e.putRune(r)
 	if e.backsearch {
 		wasEmpty := len(e.backsearchPattern) == 0
@@ -189,6 +251,7 @@ func (e *Editor) RemCluster() (ok bool) {
 	if !ok {
 		return
 	}
+	e.saveUndo(editDelete)
 	e.remClusterAt(e.cursorIdx - 1)
 	e.left()
 	e.autoCache = nil
@@ -208,6 +271,7 @@ func (e *Editor) RemClusterForward() (ok bool) {
 	if !ok {
 		return
 	}
+	e.saveUndo(editDelete)
 	e.remClusterAt(e.cursorIdx)
 	e.autoCache = nil
 	e.backsearchEnd()
@@ -219,6 +283,8 @@ func (e *Editor) RemBefore() (ok bool) {
 	if !ok {
 		return
 	}
+	e.saveUndo(editOther)
+	e.kill(e.text[e.lineIdx].runes[:e.text[e.lineIdx].clusters[e.cursorIdx]])
 	e.text[e.lineIdx].runes = e.text[e.lineIdx].runes[e.text[e.lineIdx].clusters[e.cursorIdx]:]
 	e.cursorIdx = 0
 	e.offsetIdx = 0
@@ -235,6 +301,8 @@ func (e *Editor) RemAfter() (ok bool) {
 	if !ok {
 		return
 	}
+	e.saveUndo(editOther)
+	e.kill(e.text[e.lineIdx].runes[e.text[e.lineIdx].clusters[e.cursorIdx]:])
 	e.text[e.lineIdx].runes = e.text[e.lineIdx].runes[:e.text[e.lineIdx].clusters[e.cursorIdx]]
 
 	e.recompute()
@@ -260,7 +328,10 @@ func (e *Editor) RemWord() (ok bool) {
 		return
 	}
 
+	e.saveUndo(editOther)
 	line := e.text[e.lineIdx]
+	old := append([]rune{}, line.runes...)
+	end := line.clusters[e.cursorIdx]
 
 	// To allow doing something like this (| is the cursor):
 	// Hello world|
@@ -278,6 +349,7 @@ func (e *Editor) RemWord() (ok bool) {
 		e.remClusterAt(i)
 		e.left()
 	}
+	e.kill(old[e.text[e.lineIdx].clusters[e.cursorIdx]:end])
 
 	e.autoCache = nil
 	e.backsearchEnd()
@@ -290,6 +362,10 @@ func (e *Editor) RemWordForward() (ok bool) {
 		return
 	}
 
+	e.saveUndo(editOther)
+	old := append([]rune{}, e.text[e.lineIdx].runes...)
+	start := e.text[e.lineIdx].clusters[e.cursorIdx]
+
 	for e.cursorIdx < len(e.text[e.lineIdx].clusters)-1 && e.text[e.lineIdx].runes[e.text[e.lineIdx].clusters[e.cursorIdx]] == ' ' {
 		e.remClusterAt(e.cursorIdx)
 	}
@@ -300,6 +376,7 @@ func (e *Editor) RemWordForward() (ok bool) {
 		}
 		e.remClusterAt(e.cursorIdx)
 	}
+	e.kill(old[start : start+len(old)-len(e.text[e.lineIdx].runes)])
 
 	e.autoCache = nil
 	e.backsearchEnd()
@@ -310,7 +387,10 @@ func (e *Editor) Flush() string {
 	l := e.text[e.lineIdx]
 	content := string(l.runes)
 	if len(content) > 0 {
-		e.history = append(e.history, l.copy())
+		entry := l.copy()
+		entry.netID = e.netID
+		entry.buffer = e.buffer
+		e.history = append(e.history, entry)
 	}
 	for i, line := range e.history[e.oldestTextChange:] {
 		i := i + e.oldestTextChange
@@ -328,14 +408,33 @@ func (e *Editor) Flush() string {
 	e.autoCache = nil
 	e.typos = nil
 	e.backsearchEnd()
+	e.resetUndo()
+	e.viNormal = false
+	e.viPending = nil
 	e.oldestTextChange = len(e.text) - 1
 	return content
 }
 
+// DropLastHistory removes the last entry of the history, such as the one
+// added by Flush for input that failed to be sent.
+func (e *Editor) DropLastHistory() {
+	if len(e.history) == 0 {
+		return
+	}
+	e.history = e.history[:len(e.history)-1]
+	// The current line is kept, after the lines of the history.
+	i := len(e.text) - 2
+	e.text = append(e.text[:i], e.text[i+1:]...)
+	e.lineIdx = len(e.text) - 1
+	e.oldestTextChange = min(e.oldestTextChange, len(e.text)-1)
+	e.backsearchEnd()
+}
+
 func (e *Editor) Clear() bool {
 	if e.Empty() {
 		return false
 	}
+	e.saveUndo(editOther)
 	e.text[e.lineIdx] = newEditorLine()
 	e.bumpOldestChange()
 	e.textWidth = e.textWidth[:1]
@@ -346,6 +445,7 @@ func (e *Editor) Clear() bool {
 }
 
 func (e *Editor) Set(text string) {
+	e.saveUndo(editOther)
 	r := []rune(text)
 	e.text[e.lineIdx].runes = r
 	e.recompute()
@@ -359,6 +459,41 @@ func (e *Editor) Set(text string) {
 	e.backsearchEnd()
 }
 
+// Draft returns a copy of the text being written, and the position of the
+// cursor in runes. While browsing the history, this is the line left to
+// browse it, with the cursor at its end.
+func (e *Editor) Draft() (text []rune, cursor int) {
+	l := e.text[len(e.text)-1]
+	if e.lineIdx != len(e.text)-1 {
+		return append([]rune{}, l.runes...), len(l.runes)
+	}
+	return append([]rune{}, l.runes...), l.clusters[e.cursorIdx]
+}
+
+// SetDraft replaces the text being written with text, as returned by Draft,
+// and moves the cursor to the given rune position.
+func (e *Editor) SetDraft(text []rune, cursor int) {
+	e.lineIdx = len(e.text) - 1
+	e.text[e.lineIdx].runes = append([]rune{}, text...)
+	e.recompute()
+	e.bumpOldestChange()
+	e.cursorIdx = len(e.text[e.lineIdx].clusters) - 1
+	for i, o := range e.text[e.lineIdx].clusters {
+		if o >= cursor {
+			e.cursorIdx = i
+			break
+		}
+	}
+	e.offsetIdx = 0
+	for e.offsetIdx < e.cursorIdx && e.width <= e.textWidth[e.cursorIdx]-e.textWidth[e.offsetIdx] {
+		e.offsetIdx++
+	}
+	e.autoCache = nil
+	e.typos = nil
+	e.backsearchEnd()
+	e.resetUndo()
+}
+
 func (e *Editor) Enter() bool {
 	if e.autoCache != nil {
 		return e.AutoComplete()
@@ -367,6 +502,7 @@ func (e *Editor) Enter() bool {
 }
 
 func (e *Editor) Right() {
+	e.lastEdit = editNone
 	e.right()
 	e.autoCache = nil
 	e.backsearchEnd()
@@ -403,6 +539,7 @@ func (e *Editor) RightWord() {
 }
 
 func (e *Editor) Left() {
+	e.lastEdit = editNone
 	e.left()
 	e.backsearchEnd()
 }
@@ -424,6 +561,7 @@ func (e *Editor) LeftWord() {
 	if e.cursorIdx == 0 {
 		return
 	}
+	e.lastEdit = editNone
 
 	line := e.text[e.lineIdx]
 
@@ -442,6 +580,7 @@ func (e *Editor) Home() {
 	if e.cursorIdx == 0 {
 		return
 	}
+	e.lastEdit = editNone
 	e.cursorIdx = 0
 	e.offsetIdx = 0
 	e.autoCache = nil
@@ -452,6 +591,7 @@ func (e *Editor) End() {
 	if e.cursorIdx == len(e.text[e.lineIdx].clusters)-1 {
 		return
 	}
+	e.lastEdit = editNone
 	e.cursorIdx = len(e.text[e.lineIdx].clusters) - 1
 	for e.offsetIdx < len(e.textWidth)-1 && e.width < e.textWidth[e.cursorIdx]-e.textWidth[e.offsetIdx]+16 {
 		e.offsetIdx++
@@ -469,6 +609,7 @@ func (e *Editor) Up() {
 		return
 	}
 	e.lineIdx--
+	e.resetUndo()
 	e.recompute()
 	e.cursorIdx = 0
 	e.offsetIdx = 0
@@ -487,6 +628,7 @@ func (e *Editor) Down() {
 		return
 	}
 	e.lineIdx++
+	e.resetUndo()
 	e.recompute()
 	e.cursorIdx = 0
 	e.offsetIdx = 0
@@ -511,6 +653,7 @@ func (e *Editor) AutoComplete() (ok bool) {
 		return false
 	}
 
+	e.saveUndo(editOther)
 	e.text[e.lineIdx].runes = e.autoCache[e.autoCacheIdx].Text
 	e.recompute()
 	e.bumpOldestChange()
@@ -547,21 +690,89 @@ func (e *Editor) AsyncCompletions(id int, cs []Completion) {
 	}
 }
 
-func (e *Editor) BackSearch() {
+// BackSearch searches the history for the text being written, or for the
+// previous match if already searching. If buffer is true, only the lines sent
+// in the current buffer are searched.
+func (e *Editor) BackSearch(buffer bool) {
 	if !e.backsearch {
 		e.backsearch = true
 		e.backsearchPattern = []rune(strings.ToLower(string(e.text[e.lineIdx].runes)))
+	} else if e.backsearchBuffer != buffer {
+		// Search again from the current match, in the other scope.
+		e.backsearchBuffer = buffer
+		e.backsearchUpdate(e.lineIdx)
+		return
 	}
+	e.backsearchBuffer = buffer
 	e.backsearchUpdate(e.lineIdx - 1)
 }
 
+// SetBuffer sets the buffer the text is written in.
+func (e *Editor) SetBuffer(netID, buffer string) {
+	e.netID = netID
+	e.buffer = buffer
+}
+
+// inBuffer reports whether the line i was sent in the current buffer.
+func (e *Editor) inBuffer(i int) bool {
+	if i == len(e.text)-1 {
+		// The line being written.
+		return true
+	}
+	l := e.text[i]
+	return l.netID == e.netID && strings.ToLower(l.buffer) == strings.ToLower(e.buffer)
+}
+
+// History returns the lines sent, oldest first.
+func (e *Editor) History() []HistoryEntry {
+	entries := make([]HistoryEntry, 0, len(e.history))
+	for _, l := range e.history {
+		entries = append(entries, HistoryEntry{
+			NetID:  l.netID,
+			Buffer: l.buffer,
+			Text:   string(l.runes),
+		})
+	}
+	return entries
+}
+
+// AddHistory adds entries, oldest first, before the lines sent so far, for
+// example to restore the history of a previous session.
+func (e *Editor) AddHistory(entries []HistoryEntry) {
+	lines := make([]editorLine, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Text == "" {
+			continue
+		}
+		l := newEditorLine()
+		l.runes = []rune(entry.Text)
+		l.netID = entry.NetID
+		l.buffer = entry.Buffer
+		lines = append(lines, l)
+	}
+	e.history = append(lines, e.history...)
+	text := make([]editorLine, 0, len(lines)+len(e.text))
+	for _, l := range lines {
+		text = append(text, l.copy())
+	}
+	e.text = append(text, e.text...)
+	e.lineIdx += len(lines)
+	e.oldestTextChange += len(lines)
+}
+
 func (e *Editor) backsearchUpdate(start int) {
 	if len(e.backsearchPattern) == 0 {
 		return
 	}
 	pattern := string(e.backsearchPattern)
 	for i := start; i >= 0; i-- {
+		if e.backsearchBuffer && !e.inBuffer(i) {
+			continue
+		}
 		if match := strings.Index(strings.ToLower(string(e.text[i].runes)), pattern); match >= 0 {
+			if e.lineIdx != i {
+				e.resetUndo()
+			}
 			e.lineIdx = i
 			e.recompute()
 			e.setCursor(runeOffset(string(e.text[i].runes), match) + len(e.backsearchPattern))
@@ -586,6 +797,232 @@ func (e *Editor) bumpOldestChange() {
 	}
 }
 
+// kill adds text to the kill ring.
+func (e *Editor) kill(text []rune) {
+	if len(text) == 0 {
+		return
+	}
+	e.killRing = append([][]rune{append([]rune{}, text...)}, e.killRing...)
+	if len(e.killRing) > maxKillRing {
+		e.killRing = e.killRing[:maxKillRing]
+	}
+}
+
+// Yank inserts the text last killed at the cursor.
+func (e *Editor) Yank() (ok bool) {
+	ok = len(e.killRing) > 0
+	if !ok {
+		return
+	}
+	e.saveUndo(editOther)
+	start := e.text[e.lineIdx].clusters[e.cursorIdx]
+	end := start + len(e.killRing[0])
+	e.splice(start, start, e.killRing[0], end)
+	e.yank = &editorYank{
+		start: start,
+		end:   end,
+	}
+	return
+}
+
+// YankPop replaces the text inserted by Yank, or by the previous YankPop,
+// with the text killed before it.
+func (e *Editor) YankPop() (ok bool) {
+	y := e.yank
+	runes := e.text[e.lineIdx].runes
+	ok = y != nil && len(e.killRing) > 1 && e.text[e.lineIdx].clusters[e.cursorIdx] == y.end &&
+		y.end <= len(runes) && string(runes[y.start:y.end]) == string(e.killRing[y.idx%len(e.killRing)])
+	if !ok {
+		e.yank = nil
+		return
+	}
+	y.idx = (y.idx + 1) % len(e.killRing)
+	text := e.killRing[y.idx]
+	end := y.start + len(text)
+	e.splice(y.start, y.end, text, end)
+	y.end = end
+	return
+}
+
+// TransposeChars swaps the grapheme clusters before and after the cursor,
+// or the two clusters before the cursor at the end of the text, and moves
+// the cursor forward.
+func (e *Editor) TransposeChars() (ok bool) {
+	line := e.text[e.lineIdx]
+	n := len(line.clusters) - 1
+	i := e.cursorIdx
+	if i == n {
+		i--
+	}
+	ok = 0 < i && i < n
+	if !ok {
+		return
+	}
+	var r []rune
+	r = append(r, line.runes[:line.clusters[i-1]]...)
+	r = append(r, line.runes[line.clusters[i]:line.clusters[i+1]]...)
+	r = append(r, line.runes[line.clusters[i-1]:line.clusters[i]]...)
+	r = append(r, line.runes[line.clusters[i+1]:]...)
+	e.saveUndo(editOther)
+	e.replaceText(r, line.clusters[i+1])
+	return
+}
+
+// TransposeWords swaps the word before the cursor with the word at or after
+// the cursor, or the two last words at the end of the text, and moves the
+// cursor after them.
+func (e *Editor) TransposeWords() (ok bool) {
+	line := e.text[e.lineIdx]
+	n := len(line.clusters) - 1
+	space := func(i int) bool {
+		return unicode.IsSpace(line.runes[line.clusters[i]])
+	}
+
+	// Find the second word: the word at or after the cursor, or the last one.
+	s2 := e.cursorIdx
+	for s2 < n && space(s2) {
+		s2++
+	}
+	if s2 == n {
+		s2 = e.cursorIdx
+		for s2 > 0 && space(s2-1) {
+			s2--
+		}
+	}
+	for s2 > 0 && !space(s2-1) {
+		s2--
+	}
+	e2 := s2
+	for e2 < n && !space(e2) {
+		e2++
+	}
+
+	// Find the first word, before the second one.
+	e1 := s2
+	for e1 > 0 && space(e1-1) {
+		e1--
+	}
+	s1 := e1
+	for s1 > 0 && !space(s1-1) {
+		s1--
+	}
+	ok = s1 < e1 && s2 < e2
+	if !ok {
+		return
+	}
+
+	var r []rune
+	r = append(r, line.runes[:line.clusters[s1]]...)
+	r = append(r, line.runes[line.clusters[s2]:line.clusters[e2]]...)
+	r = append(r, line.runes[line.clusters[e1]:line.clusters[s2]]...)
+	r = append(r, line.runes[line.clusters[s1]:line.clusters[e1]]...)
+	r = append(r, line.runes[line.clusters[e2]:]...)
+	e.saveUndo(editOther)
+	e.replaceText(r, line.clusters[e2])
+	return
+}
+
+func (e *Editor) state() editorState {
+	line := e.text[e.lineIdx]
+	return editorState{
+		runes:  append([]rune{}, line.runes...),
+		cursor: line.clusters[e.cursorIdx],
+	}
+}
+
+// saveUndo must be called before changing the text, to be able to undo the
+// change.
+func (e *Editor) saveUndo(kind editKind) {
+	if kind != editOther && kind == e.lastEdit {
+		return
+	}
+	e.undo = append(e.undo, e.state())
+	if len(e.undo) > maxUndo {
+		e.undo = e.undo[1:]
+	}
+	e.redo = nil
+	e.lastEdit = kind
+}
+
+func (e *Editor) resetUndo() {
+	e.undo = nil
+	e.redo = nil
+	e.lastEdit = editNone
+}
+
+// Undo reverts the last change of the text.
+func (e *Editor) Undo() (ok bool) {
+	ok = len(e.undo) > 0
+	if !ok {
+		return
+	}
+	e.redo = append(e.redo, e.state())
+	s := e.undo[len(e.undo)-1]
+	e.undo = e.undo[:len(e.undo)-1]
+	e.replaceText(s.runes, s.cursor)
+	e.lastEdit = editNone
+	return
+}
+
+// Redo applies again the last change reverted by Undo.
+func (e *Editor) Redo() (ok bool) {
+	ok = len(e.redo) > 0
+	if !ok {
+		return
+	}
+	e.undo = append(e.undo, e.state())
+	s := e.redo[len(e.redo)-1]
+	e.redo = e.redo[:len(e.redo)-1]
+	e.replaceText(s.runes, s.cursor)
+	e.lastEdit = editNone
+	return
+}
+
+// splice replaces the runes between start and end with text, then moves
+// the cursor to the rune offset cursor.
+func (e *Editor) splice(start, end int, text []rune, cursor int) {
+	runes := e.text[e.lineIdx].runes
+	r := make([]rune, 0, len(runes)-(end-start)+len(text))
+	r = append(r, runes[:start]...)
+	r = append(r, text...)
+	r = append(r, runes[end:]...)
+	e.replaceText(r, cursor)
+}
+
+// replaceText replaces the text with runes, then moves the cursor to the
+// rune offset cursor, rounding up to the next grapheme cluster as needed.
+func (e *Editor) replaceText(runes []rune, cursor int) {
+	e.text[e.lineIdx].runes = runes
+	e.recompute()
+	e.bumpOldestChange()
+	clusters := e.text[e.lineIdx].clusters
+	idx := len(clusters) - 1
+	for i, o := range clusters {
+		if o >= cursor {
+			idx = i
+			break
+		}
+	}
+	e.moveCursor(idx)
+	e.autoCache = nil
+	e.backsearchEnd()
+}
+
+// moveCursor moves the cursor to the grapheme cluster idx, scrolling the
+// text so that it is shown.
+func (e *Editor) moveCursor(idx int) {
+	e.cursorIdx = idx
+	if max := len(e.text[e.lineIdx].clusters) - 1; e.offsetIdx > max {
+		e.offsetIdx = max
+	}
+	if e.cursorIdx < e.offsetIdx {
+		e.offsetIdx = e.cursorIdx
+	}
+	for e.offsetIdx < e.cursorIdx && e.width <= e.textWidth[e.cursorIdx]-e.textWidth[e.offsetIdx] {
+		e.offsetIdx++
+	}
+}
+
 func (e *Editor) SetTypos(typos []events.TypoRange) {
 	e.typos = typos
 }
@@ -626,8 +1063,7 @@ func (e *Editor) Draw(vx *Vaxis, x0, y int, hint string) {
 		if s.UnderlineStyle == vaxis.UnderlineOff {
 			for _, t := range e.typos {
 				if i >= t.Start && i < t.End {
-					s.UnderlineStyle = vaxis.UnderlineCurly
-					s.UnderlineColor = e.ui.config.Colors.Gray
+					s = overlayStyle(s, e.ui.typoStyle())
 					break
 				}
 			}
@@ -714,7 +1150,11 @@ func (e *Editor) Draw(vx *Vaxis, x0, y int, hint string) {
 
 	if showCursor {
 		cursorX := x0 + e.textWidth[e.cursorIdx] - e.textWidth[e.offsetIdx]
-		vx.ShowCursor(cursorX, y, vaxis.CursorBeam)
+		var shape vaxis.CursorStyle = vaxis.CursorBeam
+		if e.viNormal {
+			shape = vaxis.CursorBlock
+		}
+		vx.ShowCursor(cursorX, y, shape)
 	} else {
 		vx.HideCursor()
 	}
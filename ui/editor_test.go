@@ -1,6 +1,10 @@
 package ui
 
-import "testing"
+import (
+	"testing"
+
+	"git.sr.ht/~rockorager/vaxis"
+)
 
 var hell = Editor{
 	text: []editorLine{{
@@ -124,3 +128,195 @@ func TestLeftAndRem(t *testing.T) {
 	e.PutRune('l')
 	assertEditorEq(t, e, hell)
 }
+
+func TestDraft(t *testing.T) {
+	e := NewEditor(&UI{})
+	e.Resize(5)
+	e.PutRune('h')
+	e.PutRune('e')
+	e.PutRune('l')
+	e.PutRune('l')
+	e.Left()
+	text, cursor := e.Draft()
+	e.Clear()
+	e.PutRune('x')
+	e.SetDraft(text, cursor)
+	e.Right()
+	assertEditorEq(t, e, hell)
+}
+
+func putString(e *Editor, s string) {
+	for _, r := range s {
+		e.PutRune(r)
+	}
+}
+
+// assertInputEq checks the line shown in e, which is the draft or a line of
+// the history being browsed.
+func assertInputEq(t *testing.T, e *Editor, text string, cursor int) {
+	t.Helper()
+	actual, actualCursor := e.Content(), e.text[e.lineIdx].clusters[e.cursorIdx]
+	if string(actual) != text || actualCursor != cursor {
+		t.Errorf("expected %q with cursor at %d, got %q with cursor at %d", text, cursor, string(actual), actualCursor)
+	}
+}
+
+func TestKillRing(t *testing.T) {
+	e := NewEditor(&UI{})
+	e.Resize(20)
+	putString(&e, "hello world")
+	e.RemWord()
+	e.RemWord()
+	assertInputEq(t, &e, "", 0)
+	e.Yank()
+	assertInputEq(t, &e, "hello ", 6)
+	e.YankPop()
+	assertInputEq(t, &e, "world", 5)
+	e.YankPop()
+	assertInputEq(t, &e, "hello ", 6)
+	e.Left()
+	if e.YankPop() {
+		t.Errorf("expected yank-pop to fail after moving the cursor")
+	}
+}
+
+func TestUndoRedo(t *testing.T) {
+	e := NewEditor(&UI{})
+	e.Resize(20)
+	putString(&e, "ab")
+	e.Left()
+	putString(&e, "cd")
+	assertInputEq(t, &e, "acdb", 3)
+	e.Undo()
+	assertInputEq(t, &e, "ab", 1)
+	e.Undo()
+	assertInputEq(t, &e, "", 0)
+	if e.Undo() {
+		t.Errorf("expected undo to fail without changes")
+	}
+	e.Redo()
+	e.Redo()
+	assertInputEq(t, &e, "acdb", 3)
+	e.Undo()
+	e.PutRune('x')
+	if e.Redo() {
+		t.Errorf("expected redo to fail after a change")
+	}
+}
+
+func TestTranspose(t *testing.T) {
+	e := NewEditor(&UI{})
+	e.Resize(20)
+	putString(&e, "abc")
+	e.TransposeChars()
+	assertInputEq(t, &e, "acb", 3)
+	e.Home()
+	if e.TransposeChars() {
+		t.Errorf("expected transpose-chars to fail at the start")
+	}
+	e.Right()
+	e.TransposeChars()
+	assertInputEq(t, &e, "cab", 2)
+
+	e.Set("foo bar  baz")
+	e.TransposeWords()
+	assertInputEq(t, &e, "foo baz  bar", 12)
+	e.Home()
+	e.RightWord()
+	e.TransposeWords()
+	assertInputEq(t, &e, "baz foo  bar", 7)
+}
+
+// viKeys types keys in the vi editing mode, with \x1b standing for Escape.
+func viKeys(e *Editor, keys string) {
+	for _, r := range keys {
+		k := vaxis.Key{
+			Keycode:   r,
+			Text:      string(r),
+			EventType: vaxis.EventPress,
+		}
+		if r == '\x1b' {
+			k = vaxis.Key{
+				Keycode:   vaxis.KeyEsc,
+				EventType: vaxis.EventPress,
+			}
+		}
+		if handled, _ := e.ViKey(k); !handled && k.Text != "" {
+			e.PutRune(r)
+		}
+	}
+}
+
+func TestViMode(t *testing.T) {
+	for _, tc := range []struct {
+		keys   string
+		text   string
+		cursor int
+	}{
+		{"foo bar\x1b", "foo bar", 6},
+		{"foo bar baz\x1b0dw", "bar baz", 0},
+		{"foo bar baz\x1b02dw", "baz", 0},
+		{"foo bar baz\x1bbcwqux\x1b", "foo bar qux", 10},
+		{"foo bar baz\x1b0wciwx\x1b", "foo x baz", 4},
+		{"foo bar baz\x1b0wdaw", "foo baz", 4},
+		{"say(foo, bar)\x1bFodi(", "say()", 4},
+		{"a \"quoted text\" b\x1b0fqda\"", "a b", 2},
+		{"foo bar\x1b0dwP", "foo bar", 3},
+		{"foo bar\x1b0yiw$p", "foo barfoo", 9},
+		{"abc\x1b0xp", "bac", 1},
+		{"abcdef\x1b0fdD", "abc", 2},
+		{"foo bar baz\x1b0dwdwuu", "foo bar baz", 0},
+		{"foo bar\x1b0dw\x1bu", "foo bar", 0},
+		{"abc\x1b02rx", "xxc", 1},
+		{"abc\x1b0~~", "ABc", 2},
+		{"foo\x1bIx\x1bAy\x1b", "xfooy", 4},
+		{"a,b,c\x1b0f,;x", "a,bc", 3},
+		{"foo bar\x1b0zx", "oo bar", 0},
+	} {
+		e := NewEditor(&UI{config: Config{ViMode: true}})
+		e.Resize(20)
+		viKeys(&e, tc.keys)
+		text, cursor := e.Draft()
+		if string(text) != tc.text || cursor != tc.cursor {
+			t.Errorf("%q: expected %q with cursor at %d, got %q with cursor at %d", tc.keys, tc.text, tc.cursor, string(text), cursor)
+		}
+	}
+}
+
+func TestBackSearchBuffer(t *testing.T) {
+	e := NewEditor(&UI{})
+	e.Resize(20)
+	e.AddHistory([]HistoryEntry{
+		{NetID: "n", Buffer: "#a", Text: "hello a"},
+		{NetID: "n", Buffer: "#b", Text: "hello b"},
+	})
+	e.SetBuffer("n", "#A")
+	putString(&e, "hello")
+	e.BackSearch(false)
+	assertInputEq(t, &e, "hello b", 5)
+	e.BackSearch(true)
+	assertInputEq(t, &e, "hello a", 5)
+	e.Flush()
+	history := e.History()
+	if len(history) != 3 || history[2] != (HistoryEntry{NetID: "n", Buffer: "#A", Text: "hello a"}) {
+		t.Errorf("unexpected history: %+v", history)
+	}
+}
+
+func TestDropLastHistory(t *testing.T) {
+	e := NewEditor(&UI{})
+	e.Resize(20)
+	putString(&e, "sent")
+	e.Flush()
+	putString(&e, "/failed")
+	e.Flush()
+	e.DropLastHistory()
+	e.Set("/failed")
+	if history := e.History(); len(history) != 1 || history[0].Text != "sent" {
+		t.Errorf("unexpected history: %+v", history)
+	}
+	e.Up()
+	assertInputEq(t, &e, "sent", 4)
+	e.Down()
+	assertInputEq(t, &e, "/failed", 7)
+}
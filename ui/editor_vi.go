@@ -0,0 +1,618 @@
+package ui
+
+import (
+	"unicode"
+
+	"git.sr.ht/~rockorager/vaxis"
+)
+
+// viFind is an f, F, t or T motion, with the character it looks for.
+type viFind struct {
+	key rune
+	r   rune
+}
+
+// viCommand is a command of the normal state of the vi editing mode, such as
+// "3w", "x", "d2e", "ci(" or "fa".
+type viCommand struct {
+	count int  // 0 if none
+	op    rune // d, c or y if the command is an operator, 0 otherwise
+	key   rune // the command, or the motion of the operator
+	arg   rune // the character of f, F, t, T and r, or the object of i and a after an operator
+}
+
+func isViMotion(k rune) bool {
+	switch k {
+	case 'h', 'l', ' ', '0', '^', '$', 'w', 'W', 'b', 'B', 'e', 'E', 'f', 'F', 't', 'T', ';', ',':
+		return true
+	}
+	return false
+}
+
+func isViAction(k rune) bool {
+	switch k {
+	case 'x', 'X', 's', 'S', 'D', 'C', 'Y', 'p', 'P', 'u', 'i', 'a', 'I', 'A', 'r', '~', 'j', 'k':
+		return true
+	}
+	return false
+}
+
+// parseViCommand parses the keys typed so far of a command. complete is false
+// if more keys are needed, ok is false if the keys are not a valid command.
+func parseViCommand(keys []rune) (cmd viCommand, complete, ok bool) {
+	i := 0
+	parseCount := func() int {
+		n := 0
+		for i < len(keys) && '0' <= keys[i] && keys[i] <= '9' && (n > 0 || keys[i] != '0') {
+			n = n*10 + int(keys[i]-'0')
+			i++
+		}
+		return n
+	}
+
+	cmd.count = parseCount()
+	if i == len(keys) {
+		return cmd, false, true
+	}
+	k := keys[i]
+	i++
+	if k == 'd' || k == 'c' || k == 'y' {
+		cmd.op = k
+		if n := parseCount(); n > 0 {
+			cmd.count = max(cmd.count, 1) * n
+		}
+		if i == len(keys) {
+			return cmd, false, true
+		}
+		k = keys[i]
+		i++
+		if k != cmd.op && k != 'i' && k != 'a' && !isViMotion(k) {
+			return cmd, false, false
+		}
+	} else if !isViMotion(k) && !isViAction(k) {
+		return cmd, false, false
+	}
+	cmd.key = k
+
+	switch {
+	case k == 'f', k == 'F', k == 't', k == 'T', k == 'r' && cmd.op == 0, (k == 'i' || k == 'a') && cmd.op != 0:
+		if i == len(keys) {
+			return cmd, false, true
+		}
+		cmd.arg = keys[i]
+		i++
+	}
+	return cmd, true, i == len(keys)
+}
+
+// Mode returns the state of the vi editing mode, or an empty string in the
+// emacs editing mode.
+func (e *Editor) Mode() string {
+	switch {
+	case !e.ui.config.ViMode:
+		return ""
+	case e.viNormal:
+		return "NORMAL"
+	default:
+		return "INSERT"
+	}
+}
+
+// ViKey handles a key in the vi editing mode. handled is false if the key
+// should be handled as usual, changed is true if the text was changed.
+func (e *Editor) ViKey(k vaxis.Key) (handled, changed bool) {
+	if !e.ui.config.ViMode || k.EventType == vaxis.EventPaste {
+		return false, false
+	}
+	mods := k.Modifiers &^ (vaxis.ModCapsLock | vaxis.ModNumLock)
+	if k.Keycode == vaxis.KeyEsc && mods == 0 {
+		if !e.viNormal {
+			e.viSetNormal()
+			return true, false
+		}
+		if len(e.viPending) > 0 {
+			e.viPending = nil
+			return true, false
+		}
+		return false, false
+	}
+	if !e.viNormal {
+		return false, false
+	}
+	if k.Keycode == 'r' && mods == vaxis.ModCtrl {
+		e.viPending = nil
+		return true, e.Redo()
+	}
+	if k.Text == "" || mods&^vaxis.ModShift != 0 {
+		return false, false
+	}
+	for _, r := range k.Text {
+		if e.viKey(r) {
+			changed = true
+		}
+	}
+	return true, changed
+}
+
+func (e *Editor) viKey(r rune) (changed bool) {
+	e.viPending = append(e.viPending, r)
+	cmd, complete, ok := parseViCommand(e.viPending)
+	if !ok {
+		e.viPending = nil
+		return false
+	}
+	if !complete {
+		return false
+	}
+	e.viPending = nil
+	changed = e.viExecute(cmd)
+	if e.viNormal {
+		e.viClamp()
+	}
+	return changed
+}
+
+func (e *Editor) viSetNormal() {
+	e.viNormal = true
+	e.viPending = nil
+	e.lastEdit = editNone
+	e.autoCache = nil
+	e.backsearchEnd()
+	// As in vi, the cursor goes back onto the last inserted character.
+	if e.cursorIdx > 0 {
+		e.moveCursor(e.cursorIdx - 1)
+	}
+}
+
+func (e *Editor) viSetInsert() {
+	e.viNormal = false
+	e.lastEdit = editNone
+}
+
+// viClamp moves the cursor onto the last character if it is after it, as
+// the cursor is always on a character in the normal state.
+func (e *Editor) viClamp() {
+	if n := len(e.text[e.lineIdx].clusters) - 1; n > 0 && e.cursorIdx > n-1 {
+		e.moveCursor(n - 1)
+	}
+}
+
+// viRune returns the first rune of the grapheme cluster i.
+func (e *Editor) viRune(i int) rune {
+	line := e.text[e.lineIdx]
+	return line.runes[line.clusters[i]]
+}
+
+// viClass returns the class of the grapheme cluster i: 0 for blanks, 1 for
+// word characters, 2 for punctuation. Punctuation is part of words if big.
+func (e *Editor) viClass(i int, big bool) int {
+	r := e.viRune(i)
+	switch {
+	case unicode.IsSpace(r):
+		return 0
+	case big, r == '_', unicode.IsLetter(r), unicode.IsDigit(r):
+		return 1
+	default:
+		return 2
+	}
+}
+
+func (e *Editor) viFirstNonBlank() int {
+	n := len(e.text[e.lineIdx].clusters) - 1
+	i := 0
+	for i < n-1 && e.viClass(i, true) == 0 {
+		i++
+	}
+	return i
+}
+
+func (e *Editor) viWordForward(i int, big bool) int {
+	n := len(e.text[e.lineIdx].clusters) - 1
+	if i >= n {
+		return n
+	}
+	c := e.viClass(i, big)
+	for i < n && c != 0 && e.viClass(i, big) == c {
+		i++
+	}
+	for i < n && e.viClass(i, big) == 0 {
+		i++
+	}
+	return i
+}
+
+func (e *Editor) viWordBackward(i int, big bool) int {
+	if i == 0 {
+		return 0
+	}
+	i--
+	for i > 0 && e.viClass(i, big) == 0 {
+		i--
+	}
+	c := e.viClass(i, big)
+	for i > 0 && e.viClass(i-1, big) == c {
+		i--
+	}
+	return i
+}
+
+func (e *Editor) viWordEnd(i int, big bool) int {
+	n := len(e.text[e.lineIdx].clusters) - 1
+	i++
+	for i < n && e.viClass(i, big) == 0 {
+		i++
+	}
+	if i >= n {
+		return max(n-1, 0)
+	}
+	c := e.viClass(i, big)
+	for i+1 < n && e.viClass(i+1, big) == c {
+		i++
+	}
+	return i
+}
+
+func (e *Editor) viFindChar(i int, f viFind, count int) (int, bool) {
+	n := len(e.text[e.lineIdx].clusters) - 1
+	switch f.key {
+	case 'f', 't':
+		for ; count > 0; count-- {
+			i++
+			for i < n && e.viRune(i) != f.r {
+				i++
+			}
+			if i >= n {
+				return 0, false
+			}
+		}
+		if f.key == 't' {
+			i--
+		}
+	case 'F', 'T':
+		for ; count > 0; count-- {
+			i--
+			for i >= 0 && e.viRune(i) != f.r {
+				i--
+			}
+			if i < 0 {
+				return 0, false
+			}
+		}
+		if f.key == 'T' {
+			i++
+		}
+	}
+	return i, true
+}
+
+// viMotion returns the grapheme cluster a motion moves the cursor to.
+// inclusive is true if an operator applies to the target cluster.
+func (e *Editor) viMotion(key, arg rune, count int) (to int, inclusive, ok bool) {
+	n := len(e.text[e.lineIdx].clusters) - 1
+	to = e.cursorIdx
+	switch key {
+	case 'h':
+		to = max(to-count, 0)
+	case 'l', ' ':
+		to = min(to+count, n)
+	case '0':
+		to = 0
+	case '^':
+		to = e.viFirstNonBlank()
+	case '$':
+		to = n
+	case 'w', 'W':
+		for ; count > 0; count-- {
+			to = e.viWordForward(to, key == 'W')
+		}
+	case 'b', 'B':
+		for ; count > 0; count-- {
+			to = e.viWordBackward(to, key == 'B')
+		}
+	case 'e', 'E':
+		for ; count > 0; count-- {
+			to = e.viWordEnd(to, key == 'E')
+		}
+		inclusive = true
+	case 'f', 'F', 't', 'T', ';', ',':
+		f := viFind{key: key, r: arg}
+		if key == ';' || key == ',' {
+			f = e.viFind
+			if key == ',' {
+				switch f.key {
+				case 'f':
+					f.key = 'F'
+				case 'F':
+					f.key = 'f'
+				case 't':
+					f.key = 'T'
+				case 'T':
+					f.key = 't'
+				}
+			}
+		} else {
+			e.viFind = f
+		}
+		if f.key == 0 {
+			return 0, false, false
+		}
+		to, ok = e.viFindChar(to, f, count)
+		return to, f.key == 'f' || f.key == 't', ok
+	}
+	return to, inclusive, true
+}
+
+// viObject returns the range of grapheme clusters of a text object, such as
+// "iw" or "a(".
+func (e *Editor) viObject(kind, obj rune) (start, end int, ok bool) {
+	n := len(e.text[e.lineIdx].clusters) - 1
+	if n == 0 {
+		return 0, 0, false
+	}
+	i := min(e.cursorIdx, n-1)
+	switch obj {
+	case 'w', 'W':
+		big := obj == 'W'
+		c := e.viClass(i, big)
+		start, end = i, i+1
+		for start > 0 && e.viClass(start-1, big) == c {
+			start--
+		}
+		for end < n && e.viClass(end, big) == c {
+			end++
+		}
+		if kind == 'a' {
+			if c == 0 {
+				// Blanks and the word after them.
+				if end < n {
+					c = e.viClass(end, big)
+				}
+				for end < n && e.viClass(end, big) == c {
+					end++
+				}
+			} else if end < n && e.viClass(end, big) == 0 {
+				for end < n && e.viClass(end, big) == 0 {
+					end++
+				}
+			} else {
+				for start > 0 && e.viClass(start-1, big) == 0 {
+					start--
+				}
+			}
+		}
+		return start, end, true
+	case '"', '\'', '`':
+		// Quotes are paired from the start of the text.
+		open := -1
+		for j := 0; j < n; j++ {
+			if e.viRune(j) != obj {
+				continue
+			}
+			if open < 0 {
+				open = j
+				continue
+			}
+			if j >= i {
+				start, end = open, j+1
+				if kind == 'i' {
+					start, end = start+1, end-1
+				} else {
+					for end < n && e.viClass(end, true) == 0 {
+						end++
+					}
+				}
+				return start, end, true
+			}
+			open = -1
+		}
+		return 0, 0, false
+	}
+
+	var open, close rune
+	switch obj {
+	case '(', ')', 'b':
+		open, close = '(', ')'
+	case '[', ']':
+		open, close = '[', ']'
+	case '{', '}', 'B':
+		open, close = '{', '}'
+	case '<', '>':
+		open, close = '<', '>'
+	default:
+		return 0, 0, false
+	}
+	start = i
+	for depth := 0; start >= 0; start-- {
+		r := e.viRune(start)
+		if r == close && start != i {
+			depth++
+		} else if r == open {
+			if depth == 0 {
+				break
+			}
+			depth--
+		}
+	}
+	if start < 0 {
+		return 0, 0, false
+	}
+	end = start + 1
+	for depth := 0; end < n; end++ {
+		r := e.viRune(end)
+		if r == open {
+			depth++
+		} else if r == close {
+			if depth == 0 {
+				break
+			}
+			depth--
+		}
+	}
+	if end == n {
+		return 0, 0, false
+	}
+	if kind == 'i' {
+		return start + 1, end, true
+	}
+	return start, end + 1, true
+}
+
+func (e *Editor) viExecute(cmd viCommand) (changed bool) {
+	count := max(cmd.count, 1)
+	if cmd.op == 0 {
+		switch cmd.key {
+		case 'x':
+			cmd.op, cmd.key = 'd', 'l'
+		case 'X':
+			cmd.op, cmd.key = 'd', 'h'
+		case 's':
+			cmd.op, cmd.key = 'c', 'l'
+		case 'S':
+			cmd.op, cmd.key = 'c', 'c'
+		case 'D':
+			cmd.op, cmd.key = 'd', '$'
+		case 'C':
+			cmd.op, cmd.key = 'c', '$'
+		case 'Y':
+			cmd.op, cmd.key = 'y', 'y'
+		}
+	}
+	if cmd.op != 0 {
+		return e.viOperate(cmd, count)
+	}
+
+	line := e.text[e.lineIdx]
+	n := len(line.clusters) - 1
+	switch cmd.key {
+	case 'i':
+		e.viSetInsert()
+	case 'a':
+		e.moveCursor(min(e.cursorIdx+1, n))
+		e.viSetInsert()
+	case 'I':
+		e.moveCursor(e.viFirstNonBlank())
+		e.viSetInsert()
+	case 'A':
+		e.moveCursor(n)
+		e.viSetInsert()
+	case 'p', 'P':
+		if len(e.killRing) == 0 {
+			return false
+		}
+		at := e.cursorIdx
+		if cmd.key == 'p' {
+			at = min(at+1, n)
+		}
+		var text []rune
+		for i := 0; i < count; i++ {
+			text = append(text, e.killRing[0]...)
+		}
+		start := line.clusters[at]
+		e.saveUndo(editOther)
+		e.splice(start, start, text, start+len(text)-1)
+		return true
+	case 'u':
+		for ; count > 0 && e.Undo(); count-- {
+			changed = true
+		}
+		return changed
+	case 'r':
+		if e.cursorIdx+count > n {
+			return false
+		}
+		text := make([]rune, count)
+		for i := range text {
+			text[i] = cmd.arg
+		}
+		start := line.clusters[e.cursorIdx]
+		e.saveUndo(editOther)
+		e.splice(start, line.clusters[e.cursorIdx+count], text, start+count-1)
+		return true
+	case '~':
+		if e.cursorIdx >= n {
+			return false
+		}
+		start := line.clusters[e.cursorIdx]
+		end := line.clusters[min(e.cursorIdx+count, n)]
+		text := append([]rune{}, line.runes[start:end]...)
+		for i, r := range text {
+			if unicode.IsUpper(r) {
+				text[i] = unicode.ToLower(r)
+			} else {
+				text[i] = unicode.ToUpper(r)
+			}
+		}
+		e.saveUndo(editOther)
+		e.splice(start, end, text, end)
+		return true
+	case 'j':
+		for ; count > 0 && e.lineIdx < len(e.text)-1; count-- {
+			e.Down()
+		}
+	case 'k':
+		for ; count > 0 && e.lineIdx > 0; count-- {
+			e.Up()
+		}
+	default:
+		if to, _, ok := e.viMotion(cmd.key, cmd.arg, count); ok {
+			e.moveCursor(to)
+			e.lastEdit = editNone
+		}
+	}
+	return false
+}
+
+// viOperate applies the d, c or y operator of cmd.
+func (e *Editor) viOperate(cmd viCommand, count int) (changed bool) {
+	line := e.text[e.lineIdx]
+	n := len(line.clusters) - 1
+	var start, end int
+	switch cmd.key {
+	case cmd.op:
+		start, end = 0, n
+	case 'i', 'a':
+		var ok bool
+		if start, end, ok = e.viObject(cmd.key, cmd.arg); !ok {
+			return false
+		}
+	default:
+		key := cmd.key
+		if cmd.op == 'c' && e.cursorIdx < n && e.viClass(e.cursorIdx, true) != 0 {
+			// As in vi, "cw" changes up to the end of the word.
+			switch key {
+			case 'w':
+				key = 'e'
+			case 'W':
+				key = 'E'
+			}
+		}
+		to, inclusive, ok := e.viMotion(key, cmd.arg, count)
+		if !ok {
+			return false
+		}
+		start, end = min(e.cursorIdx, to), max(e.cursorIdx, to)
+		if inclusive {
+			end = min(end+1, n)
+		}
+	}
+
+	rs, re := line.clusters[start], line.clusters[end]
+	e.kill(line.runes[rs:re])
+	if cmd.op == 'y' {
+		e.moveCursor(start)
+		return false
+	}
+	if rs < re {
+		e.saveUndo(editOther)
+		e.splice(rs, re, nil, rs)
+		changed = true
+	}
+	if cmd.op == 'c' {
+		e.viSetInsert()
+		if changed {
+			// Merge the text typed next with the change, to undo them at once.
+			e.lastEdit = editInsert
+		}
+	}
+	return changed
+}
@@ -5,6 +5,8 @@ import (
 	"math/rand"
 	"net/url"
 	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"unicode"
@@ -57,8 +59,9 @@ func colorFromCode(code int) (color vaxis.Color) {
 }
 
 type rangedStyle struct {
-	Start int // byte index at which Style is effective
-	Style vaxis.Style
+	Start  int // byte index at which Style is effective
+	Style  vaxis.Style
+	Server bool // Style is the style of server lines, set when drawn
 }
 
 type StyledString struct {
@@ -89,6 +92,18 @@ func Styled(s string, style vaxis.Style) StyledString {
 	}
 }
 
+// ServerString returns s in the style of server and status lines, which is
+// only resolved when drawn, to follow the current theme.
+func ServerString(s string) StyledString {
+	return StyledString{
+		string: s,
+		styles: []rangedStyle{{
+			Start:  0,
+			Server: true,
+		}},
+	}
+}
+
 func (s StyledString) String() string {
 	return s.string
 }
@@ -160,8 +175,9 @@ func (s StyledString) ParseURLs() StyledString {
 			st.Hyperlink = link
 			st.HyperlinkParams = params
 			styles = append(styles, rangedStyle{
-				Start: ub,
-				Style: st,
+				Start:  ub,
+				Style:  st,
+				Server: lastStyle.Server,
 			})
 		}
 		// find last style starting before or at url end
@@ -183,8 +199,9 @@ func (s StyledString) ParseURLs() StyledString {
 			st.Hyperlink = ""
 			st.HyperlinkParams = ""
 			styles = append(styles, rangedStyle{
-				Start: ue,
-				Style: st,
+				Start:  ue,
+				Style:  st,
+				Server: lastStyle.Server,
 			})
 		}
 	}
@@ -196,6 +213,99 @@ func (s StyledString) ParseURLs() StyledString {
 	}
 }
 
+// URLs returns the distinct links of s, in order, as found by ParseURLs.
+func (s StyledString) URLs() []string {
+	var urls []string
+	for _, st := range s.ParseURLs().styles {
+		if st.Style.Hyperlink != "" && !slices.Contains(urls, st.Style.Hyperlink) {
+			urls = append(urls, st.Style.Hyperlink)
+		}
+	}
+	return urls
+}
+
+// overlayStyle returns st with over applied on top of it: the colors and
+// underline of over replace those of st if set, and its attributes are added.
+func overlayStyle(st, over vaxis.Style) vaxis.Style {
+	if over.Foreground != 0 {
+		st.Foreground = over.Foreground
+	}
+	if over.Background != 0 {
+		st.Background = over.Background
+	}
+	if over.UnderlineStyle != vaxis.UnderlineOff {
+		st.UnderlineStyle = over.UnderlineStyle
+	}
+	if over.UnderlineColor != 0 {
+		st.UnderlineColor = over.UnderlineColor
+	}
+	if over.Hyperlink != "" {
+		st.Hyperlink = over.Hyperlink
+	}
+	if over.HyperlinkParams != "" {
+		st.HyperlinkParams = over.HyperlinkParams
+	}
+	st.Attribute |= over.Attribute
+	return st
+}
+
+// styleAt returns the style in effect at byte index i.
+func (s StyledString) styleAt(i int) vaxis.Style {
+	var st vaxis.Style
+	for _, r := range s.styles {
+		if r.Start > i {
+			break
+		}
+		st = r.Style
+	}
+	return st
+}
+
+// Emphasize returns s with style applied over its existing styles in the
+// given byte ranges, as returned by regexp.FindAllStringIndex. The colors of
+// style replace the existing ones if set, and its attributes are added.
+func (s StyledString) Emphasize(ranges [][]int, style vaxis.Style) StyledString {
+	if len(ranges) == 0 {
+		return s
+	}
+	points := make([]int, 0, 1+len(s.styles)+2*len(ranges))
+	points = append(points, 0)
+	for _, r := range s.styles {
+		points = append(points, r.Start)
+	}
+	for _, r := range ranges {
+		points = append(points, r[0], r[1])
+	}
+	sort.Ints(points)
+
+	styles := make([]rangedStyle, 0, len(points))
+	var last vaxis.Style
+	for i, p := range points {
+		if p >= len(s.string) || (i > 0 && p == points[i-1]) {
+			continue
+		}
+		st := s.styleAt(p)
+		for _, r := range ranges {
+			if r[0] <= p && p < r[1] {
+				st = overlayStyle(st, style)
+				break
+			}
+		}
+		if st == last {
+			continue
+		}
+		styles = append(styles, rangedStyle{
+			Start: p,
+			Style: st,
+		})
+		last = st
+	}
+	return StyledString{
+		string: s.string,
+		styles: styles,
+	}
+}
+
 func isDigit(c byte) bool {
 	return '0' <= c && c <= '9'
 }
@@ -398,6 +508,14 @@ func (sb *StyledStringBuilder) SetStyle(style vaxis.Style) {
 	})
 }
 
+// SetServerStyle is like SetStyle, with the style of server and status lines.
+func (sb *StyledStringBuilder) SetServerStyle() {
+	sb.styles = append(sb.styles, rangedStyle{
+		Start:  sb.Len(),
+		Server: true,
+	})
+}
+
 func (sb *StyledStringBuilder) StyledString() StyledString {
 	string := sb.String()
 	styles := make([]rangedStyle, 0, len(sb.styles))
@@ -94,3 +94,60 @@ func TestIRCString(t *testing.T) {
 		},
 	})
 }
+
+func assertStyledString(t *testing.T, actual, expected StyledString) {
+	if actual.string != expected.string {
+		t.Errorf("expected string %q, got %q", expected.string, actual.string)
+	}
+	if len(actual.styles) != len(expected.styles) {
+		t.Errorf("%q: expected %d styles, got %d", actual.string, len(expected.styles), len(actual.styles))
+		return
+	}
+	for i := range actual.styles {
+		if actual.styles[i] != expected.styles[i] {
+			t.Errorf("%q: style #%d expected to be %+v, got %+v", actual.string, i, expected.styles[i], actual.styles[i])
+		}
+	}
+}
+
+func TestEmphasize(t *testing.T) {
+	bold := vaxis.Style{Attribute: vaxis.AttrBold}
+	red := vaxis.Style{Foreground: vaxis.IndexColor(1)}
+	redBold := vaxis.Style{Foreground: vaxis.IndexColor(1), Attribute: vaxis.AttrBold}
+
+	assertStyledString(t, PlainString("hello").Emphasize(nil, bold), StyledString{
+		string: "hello",
+		styles: nil,
+	})
+	assertStyledString(t, PlainString("hey you").Emphasize([][]int{{4, 7}}, bold), StyledString{
+		string: "hey you",
+		styles: []rangedStyle{
+			{Start: 4, Style: bold},
+		},
+	})
+	assertStyledString(t, IRCString("\x035hey\x03 you there").Emphasize([][]int{{1, 2}, {4, 7}}, bold), StyledString{
+		string: "hey you there",
+		styles: []rangedStyle{
+			{Start: 0, Style: red},
+			{Start: 1, Style: redBold},
+			{Start: 2, Style: red},
+			{Start: 3, Style: vaxis.Style{}},
+			{Start: 4, Style: bold},
+			{Start: 7, Style: vaxis.Style{}},
+		},
+	})
+}
+
+func TestURLs(t *testing.T) {
+	s := IRCString("see https://example.org and \x02https://example.com/a\x02, not #chan, again https://example.org")
+	urls := s.URLs()
+	expected := []string{"https://example.org", "https://example.com/a"}
+	if len(urls) != len(expected) {
+		t.Fatalf("expected URLs %q, got %q", expected, urls)
+	}
+	for i := range urls {
+		if urls[i] != expected[i] {
+			t.Errorf("URL #%d: expected %q, got %q", i, expected[i], urls[i])
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package ui
+
+import (
+	"git.sr.ht/~rockorager/vaxis"
+)
+
+// Theme overrides the styles of UI elements. A nil style keeps the default
+// style of the element.
+type Theme struct {
+	Timestamp     *vaxis.Style // times of messages in the timeline
+	DateSeparator *vaxis.Style // dates of messages in the timeline
+	Server        *vaxis.Style // server and status lines
+	Card          *vaxis.Style // link summaries below messages
+
+	BufferUnread    *vaxis.Style // names of unread buffers
+	BufferPinned    *vaxis.Style // marker of pinned buffers
+	BufferMuted     *vaxis.Style // names of muted buffers
+	BufferHighlight *vaxis.Style // highlight counts of buffers
+
+	Topic        *vaxis.Style // base style of the topic bar
+	MemberPrefix *vaxis.Style // membership prefixes in the member list
+	Link         *vaxis.Style // applied over links
+	Typo         *vaxis.Style // applied over misspelled words in the input
+}
+
+// theme returns the theme for the current terminal color theme mode.
+func (ui *UI) theme() *Theme {
+	if ui.colorThemeMode == vaxis.LightMode && ui.config.LightTheme != nil {
+		return ui.config.LightTheme
+	}
+	if ui.config.Theme != nil {
+		return ui.config.Theme
+	}
+	return &Theme{}
+}
+
+func themeStyle(st *vaxis.Style, def vaxis.Style) vaxis.Style {
+	if st == nil {
+		return def
+	}
+	return *st
+}
+
+// serverStyle returns the style of server and status lines.
+func (ui *UI) serverStyle() vaxis.Style {
+	return themeStyle(ui.theme().Server, vaxis.Style{
+		Foreground: ui.config.Colors.Status,
+	})
+}
+
+// resolveStyles returns s with the style of server lines set from the
+// current theme.
+func (ui *UI) resolveStyles(s StyledString) StyledString {
+	var styles []rangedStyle
+	for i, st := range s.styles {
+		if !st.Server {
+			continue
+		}
+		if styles == nil {
+			styles = append([]rangedStyle(nil), s.styles...)
+		}
+		// Keep the links found by ParseURLs.
+		style := ui.serverStyle()
+		style.Hyperlink = st.Style.Hyperlink
+		style.HyperlinkParams = st.Style.HyperlinkParams
+		styles[i].Style = style
+	}
+	if styles != nil {
+		s.styles = styles
+	}
+	return s
+}
+
+// cardStyle returns the style of link summaries, under the styles of their
+// rows.
+func (ui *UI) cardStyle() vaxis.Style {
+	return themeStyle(ui.theme().Card, vaxis.Style{
+		Foreground: ui.config.Colors.Gray,
+	})
+}
+
+// cardRow returns a row of a link summary in the card style, with its own
+// styles applied over it.
+func (ui *UI) cardRow(s StyledString) StyledString {
+	base := ui.cardStyle()
+	styles := make([]rangedStyle, 0, len(s.styles)+1)
+	if len(s.styles) == 0 || s.styles[0].Start > 0 {
+		styles = append(styles, rangedStyle{Start: 0, Style: base})
+	}
+	for _, st := range s.styles {
+		st.Style = overlayStyle(base, st.Style)
+		styles = append(styles, st)
+	}
+	s.styles = styles
+	return s
+}
+
+func (ui *UI) timestampStyle() vaxis.Style {
+	return themeStyle(ui.theme().Timestamp, vaxis.Style{
+		Foreground: ui.config.Colors.Gray,
+	})
+}
+
+func (ui *UI) dateSeparatorStyle() vaxis.Style {
+	return themeStyle(ui.theme().DateSeparator, vaxis.Style{
+		Attribute: vaxis.AttrBold,
+	})
+}
+
+func (ui *UI) bufferUnreadStyle() vaxis.Style {
+	return themeStyle(ui.theme().BufferUnread, vaxis.Style{
+		Foreground: ui.config.Colors.Unread,
+		Attribute:  vaxis.AttrBold,
+	})
+}
+
+func (ui *UI) bufferPinnedStyle() vaxis.Style {
+	return themeStyle(ui.theme().BufferPinned, vaxis.Style{
+		Attribute: vaxis.AttrBold,
+	})
+}
+
+func (ui *UI) bufferMutedStyle() vaxis.Style {
+	return themeStyle(ui.theme().BufferMuted, vaxis.Style{
+		Foreground: ui.config.Colors.Gray,
+	})
+}
+
+func (ui *UI) bufferHighlightStyle() vaxis.Style {
+	return themeStyle(ui.theme().BufferHighlight, vaxis.Style{
+		Foreground: ColorRed,
+		Attribute:  vaxis.AttrReverse,
+	})
+}
+
+func (ui *UI) topicStyle() vaxis.Style {
+	return themeStyle(ui.theme().Topic, vaxis.Style{})
+}
+
+func (ui *UI) memberPrefixStyle() vaxis.Style {
+	return themeStyle(ui.theme().MemberPrefix, vaxis.Style{
+		Foreground: ColorGreen,
+	})
+}
+
+func (ui *UI) linkStyle() vaxis.Style {
+	return themeStyle(ui.theme().Link, vaxis.Style{
+		UnderlineStyle: vaxis.UnderlineDotted,
+	})
+}
+
+func (ui *UI) typoStyle() vaxis.Style {
+	return themeStyle(ui.theme().Typo, vaxis.Style{
+		UnderlineStyle: vaxis.UnderlineCurly,
+		UnderlineColor: ui.config.Colors.Gray,
+	})
+}
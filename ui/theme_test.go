@@ -0,0 +1,112 @@
+package ui
+
+import (
+	"testing"
+
+	"git.sr.ht/~rockorager/vaxis"
+)
+
+func TestThemeMode(t *testing.T) {
+	dark := vaxis.Style{Foreground: vaxis.IndexColor(1)}
+	light := vaxis.Style{Foreground: vaxis.IndexColor(2)}
+	ui := &UI{
+		config: Config{
+			Colors: ConfigColors{
+				Gray: vaxis.IndexColor(8),
+			},
+			Theme: &Theme{
+				Timestamp: &dark,
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		mode       vaxis.ColorThemeMode
+		lightTheme *Theme
+		expected   vaxis.Style
+	}{
+		{mode: vaxis.DarkMode, expected: dark},
+		{mode: vaxis.LightMode, expected: dark},
+		{mode: vaxis.DarkMode, lightTheme: &Theme{Timestamp: &light}, expected: dark},
+		{mode: vaxis.LightMode, lightTheme: &Theme{Timestamp: &light}, expected: light},
+		{mode: vaxis.LightMode, lightTheme: &Theme{}, expected: vaxis.Style{Foreground: vaxis.IndexColor(8)}},
+	} {
+		ui.config.LightTheme = tc.lightTheme
+		ui.SetColorTheme(tc.mode)
+		if st := ui.timestampStyle(); st != tc.expected {
+			t.Errorf("mode %v, light theme %v: got style %+v, expected %+v", tc.mode, tc.lightTheme != nil, st, tc.expected)
+		}
+	}
+}
+
+func TestResolveServerStyles(t *testing.T) {
+	dark := vaxis.Style{Foreground: vaxis.IndexColor(1)}
+	light := vaxis.Style{Foreground: vaxis.IndexColor(2)}
+	ui := &UI{
+		config: Config{
+			Theme:      &Theme{Server: &dark},
+			LightTheme: &Theme{Server: &light},
+		},
+	}
+
+	var sb StyledStringBuilder
+	sb.SetServerStyle()
+	sb.WriteString("a")
+	sb.SetStyle(vaxis.Style{})
+	sb.WriteString("→")
+	sb.SetServerStyle()
+	sb.WriteString("b")
+	line := sb.StyledString()
+
+	for _, tc := range []struct {
+		mode     vaxis.ColorThemeMode
+		expected vaxis.Style
+	}{
+		{mode: vaxis.DarkMode, expected: dark},
+		{mode: vaxis.LightMode, expected: light},
+		{mode: vaxis.DarkMode, expected: dark},
+	} {
+		ui.SetColorTheme(tc.mode)
+		styles := ui.resolveStyles(line).styles
+		if len(styles) != 3 {
+			t.Fatalf("mode %v: got %d styles, expected 3", tc.mode, len(styles))
+		}
+		if styles[0].Style != tc.expected || styles[2].Style != tc.expected {
+			t.Errorf("mode %v: got styles %+v and %+v, expected %+v", tc.mode, styles[0].Style, styles[2].Style, tc.expected)
+		}
+		if styles[1].Style != (vaxis.Style{}) {
+			t.Errorf("mode %v: got style %+v for the arrow, expected the default style", tc.mode, styles[1].Style)
+		}
+	}
+	// The line itself keeps its style unresolved.
+	if line.styles[0].Style != (vaxis.Style{}) {
+		t.Errorf("resolving the styles changed the line: %+v", line.styles[0].Style)
+	}
+
+	link := ui.resolveStyles(ServerString("see https://example.org").ParseURLs())
+	if st := link.styleAt(len("see ")); st.Foreground != dark.Foreground || st.Hyperlink != "https://example.org" {
+		t.Errorf("got link style %+v, expected the server style with the link", st)
+	}
+}
+
+func TestCardRow(t *testing.T) {
+	card := vaxis.Style{Foreground: vaxis.IndexColor(3)}
+	ui := &UI{
+		config: Config{
+			Theme: &Theme{Card: &card},
+		},
+	}
+	var sb StyledStringBuilder
+	sb.WriteString("site ")
+	sb.SetStyle(vaxis.Style{Attribute: vaxis.AttrBold})
+	sb.WriteString("title")
+	row := ui.cardRow(sb.StyledString())
+	if st := row.styleAt(0); st != card {
+		t.Errorf("site: got style %+v, expected %+v", st, card)
+	}
+	bold := card
+	bold.Attribute = vaxis.AttrBold
+	if st := row.styleAt(len("site ")); st != bold {
+		t.Errorf("title: got style %+v, expected %+v", st, bold)
+	}
+}
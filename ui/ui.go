@@ -6,6 +6,7 @@ import (
 	"image"
 	"io"
 	"os"
+	"os/exec"
 	"reflect"
 	"runtime"
 	"strings"
@@ -32,17 +33,22 @@ type Config struct {
 	Mouse             bool
 	MergeLine         func(former *Line, addition Line)
 	Colors            ConfigColors
+	Theme             *Theme // may be nil
+	LightTheme        *Theme // theme for light terminals, Theme if nil
+	BufferGroups      []BufferGroup
 	LocalIntegrations bool
+	ViMode            bool // use the vi editing mode instead of emacs
 	WithConsole       console.Console
 	WithTTY           string
 }
 
 type ConfigColors struct {
-	Gray   vaxis.Color
-	Status vaxis.Color
-	Prompt vaxis.Color
-	Unread vaxis.Color
-	Nicks  ColorScheme
+	Gray      vaxis.Color
+	Status    vaxis.Color
+	Prompt    vaxis.Color
+	Unread    vaxis.Color
+	Highlight vaxis.Color
+	Nicks     ColorScheme
 }
 
 type Vaxis struct {
@@ -55,6 +61,23 @@ type Vaxis struct {
 type NotifyEvent struct {
 	NetID  string
 	Buffer string
+	At     time.Time // time of the notified message
+}
+
+// NotifyReplyEvent is sent when the user replies to a notification from the
+// notification itself.
+type NotifyReplyEvent struct {
+	NetID  string
+	Buffer string
+	Text   string
+}
+
+// NotifyReadEvent is sent when the user marks a notification as read from the
+// notification itself.
+type NotifyReadEvent struct {
+	NetID  string
+	Buffer string
+	At     time.Time // time of the notified message
 }
 
 type ScreenshotEvent struct {
@@ -98,6 +121,14 @@ type UI struct {
 	mouseLinks bool
 
 	colorThemeMode vaxis.ColorThemeMode
+
+	// suspended is true while the terminal is given back to another program.
+	suspended bool
+	tty       *os.File // terminal opened for that program, if any
+
+	// buffer of the draft being written in e
+	draftNetID string
+	draftTitle string
 }
 
 func New(config Config) (ui *UI, colors ConfigColors, err error) {
@@ -229,6 +260,18 @@ func New(config Config) (ui *UI, colors ConfigColors, err error) {
 	return ui, ui.config.Colors, nil
 }
 
+// NewHeadless returns a UI that is not attached to a terminal, and only keeps
+// buffers and their lines, for tests.
+func NewHeadless(config Config) *UI {
+	ui := &UI{
+		config:        config,
+		memberClicked: -1,
+	}
+	ui.bs = NewBufferList(ui)
+	ui.e = NewEditor(ui)
+	return ui
+}
+
 func (ui *UI) ShouldExit() bool {
 	return ui.exit.Load().(bool)
 }
@@ -237,6 +280,52 @@ func (ui *UI) Exit() {
 	ui.exit.Store(true)
 }
 
+// Suspend gives the terminal back until Resume is called, to run cmd in it.
+// It sets the standard streams of cmd to the terminal. The UI is not drawn
+// while suspended.
+func (ui *UI) Suspend(cmd *exec.Cmd) error {
+	path := ui.config.WithTTY
+	if ui.config.WithConsole != nil {
+		path = ui.config.WithConsole.Name()
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			return err
+		}
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = f, f, f
+		ui.tty = f
+	}
+	if err := ui.vx.Suspend(); err != nil {
+		if ui.tty != nil {
+			ui.tty.Close()
+			ui.tty = nil
+		}
+		return err
+	}
+	ui.suspended = true
+	return nil
+}
+
+// Resume takes the terminal back after Suspend.
+func (ui *UI) Resume() error {
+	if !ui.suspended {
+		return nil
+	}
+	if ui.tty != nil {
+		ui.tty.Close()
+		ui.tty = nil
+	}
+	if err := ui.vx.Resume(); err != nil {
+		return err
+	}
+	ui.suspended = false
+	ui.vx.SetTitle(ui.title)
+	ui.Resize()
+	return nil
+}
+
 func (ui *UI) Close() {
 	ui.vx.Refresh() // TODO is this needed?
 	ui.vx.Close()
@@ -253,21 +342,46 @@ func (ui *UI) CurrentBuffer() (netID, title string) {
 func (ui *UI) NextBuffer() {
 	ui.bs.Next()
 	ui.memberOffset = 0
+	ui.switchDraft()
 }
 
 func (ui *UI) PreviousBuffer() {
 	ui.bs.Previous()
 	ui.memberOffset = 0
+	ui.switchDraft()
 }
 
 func (ui *UI) NextUnreadBuffer() {
 	ui.bs.NextUnread()
 	ui.memberOffset = 0
+	ui.switchDraft()
 }
 
 func (ui *UI) PreviousUnreadBuffer() {
 	ui.bs.PreviousUnread()
 	ui.memberOffset = 0
+	ui.switchDraft()
+}
+
+// switchDraft saves the input as the draft of the buffer it was written in,
+// and restores the draft of the current buffer, if the current buffer
+// changed.
+func (ui *UI) switchDraft() {
+	if ui.bs.current < 0 || ui.bs.current >= len(ui.bs.list) {
+		return
+	}
+	netID, title := ui.bs.Current()
+	if netID == ui.draftNetID && strings.ToLower(title) == strings.ToLower(ui.draftTitle) {
+		return
+	}
+	text, cursor := ui.e.Draft()
+	ui.bs.SetDraft(ui.draftNetID, ui.draftTitle, text, cursor)
+	text, cursor = ui.bs.Draft(netID, title)
+	ui.e.SetDraft(text, cursor)
+	ui.bs.SetDraft(netID, title, nil, 0)
+	ui.draftNetID = netID
+	ui.draftTitle = title
+	ui.e.SetBuffer(netID, title)
 }
 
 func (ui *UI) ClickedBuffer() int {
@@ -324,6 +438,7 @@ func (ui *UI) GoToBufferNo(i int) {
 	if ui.bs.To(i) {
 		ui.memberOffset = 0
 		ui.ScrollToBuffer()
+		ui.switchDraft()
 	}
 }
 
@@ -383,6 +498,63 @@ func (ui *UI) ScrollDownHighlight() bool {
 	return ui.bs.ScrollDownHighlight()
 }
 
+func (ui *UI) SelectPrevious() bool {
+	return ui.bs.SelectPrevious()
+}
+
+func (ui *UI) SelectNext() bool {
+	return ui.bs.SelectNext()
+}
+
+func (ui *UI) SelectID(netID, buffer, id string) bool {
+	return ui.bs.SelectID(netID, buffer, id)
+}
+
+// CopyToClipboard sets the system clipboard to text, through the terminal.
+func (ui *UI) CopyToClipboard(text string) {
+	ui.vx.ClipboardPush(text)
+}
+
+func (ui *UI) ClearSelection() bool {
+	return ui.bs.ClearSelection()
+}
+
+func (ui *UI) Selection() (Line, bool) {
+	return ui.bs.Selection()
+}
+
+func (ui *UI) AddReaction(netID, buffer, id, user, text string, remove bool) bool {
+	return ui.bs.AddReaction(netID, buffer, id, user, text, remove)
+}
+
+func (ui *UI) RedactLine(netID, buffer, id string, tombstone StyledString) bool {
+	return ui.bs.RedactLine(netID, buffer, id, tombstone)
+}
+
+func (ui *UI) SetCard(netID, buffer string, f func(line *Line) bool, card []StyledString) bool {
+	return ui.bs.SetCard(netID, buffer, f, card)
+}
+
+func (ui *UI) SetReplies(netID, buffer string, replies map[string]StyledString) {
+	ui.bs.SetReplies(netID, buffer, replies)
+}
+
+func (ui *UI) LineByID(netID, buffer, id string) (Line, bool) {
+	return ui.bs.LineByID(netID, buffer, id)
+}
+
+func (ui *UI) FindLine(netID, buffer string, f func(line *Line) bool) (Line, bool) {
+	return ui.bs.FindLine(netID, buffer, f)
+}
+
+func (ui *UI) Lines(netID, buffer string, n int) ([]Line, bool) {
+	return ui.bs.Lines(netID, buffer, n)
+}
+
+func (ui *UI) Buffers() []BufferInfo {
+	return ui.bs.Buffers()
+}
+
 func (ui *UI) ScrollChannelUpBy(n int) {
 	ui.channelOffset -= n
 	if ui.channelOffset < 0 {
@@ -392,8 +564,9 @@ func (ui *UI) ScrollChannelUpBy(n int) {
 
 func (ui *UI) ScrollChannelDownBy(n int) {
 	ui.channelOffset += n
-	if ui.channelOffset > len(ui.bs.list) {
-		ui.channelOffset = len(ui.bs.list)
+	// The vertical buffer list has an additional row per group header.
+	if n := len(ui.bs.list) + len(ui.config.BufferGroups); ui.channelOffset > n {
+		ui.channelOffset = n
 	}
 }
 
@@ -405,6 +578,29 @@ func (ui *UI) VerticalBufferOffset(y int) int {
 	return ui.bs.VerticalBufferOffset(y, ui.channelOffset)
 }
 
+// ToggleBufferGroupAt collapses or expands the user-defined group whose
+// header is at row y of the vertical buffer list, if any.
+func (ui *UI) ToggleBufferGroupAt(y int) bool {
+	return ui.bs.ToggleCollapsedAt(y, ui.channelOffset)
+}
+
+// SetBufferGroupCollapsed collapses or expands the group of the current
+// buffer in the vertical buffer list: its user-defined group, or its network.
+func (ui *UI) SetBufferGroupCollapsed(collapsed bool) {
+	ui.bs.SetCollapsed(collapsed)
+	ui.ScrollToBuffer()
+}
+
+func (ui *UI) ToggleBufferGroup() {
+	ui.bs.ToggleCollapsed()
+	ui.ScrollToBuffer()
+}
+
+func (ui *UI) SetAllBufferGroupsCollapsed(collapsed bool) {
+	ui.bs.SetAllCollapsed(collapsed)
+	ui.ScrollToBuffer()
+}
+
 func (ui *UI) MemberOffset() int {
 	return ui.memberOffset
 }
@@ -476,17 +672,24 @@ func (ui *UI) AddBuffer(netID, netName, title string) (i int, added bool) {
 	if added {
 		ui.ScrollToBuffer()
 	}
+	if added && len(ui.bs.list) == 1 {
+		// The first buffer is the current one: the input is its draft.
+		ui.draftNetID, ui.draftTitle = ui.bs.Current()
+		ui.e.SetBuffer(ui.draftNetID, ui.draftTitle)
+	}
 	return
 }
 
 func (ui *UI) RemoveBuffer(netID, title string) {
 	_ = ui.bs.Remove(netID, title)
 	ui.memberOffset = 0
+	ui.switchDraft()
 }
 
 func (ui *UI) RemoveNetworkBuffers(netID string) {
 	ui.bs.RemoveNetwork(netID)
 	ui.memberOffset = 0
+	ui.switchDraft()
 }
 
 func (ui *UI) AddLine(netID, buffer string, line Line) {
@@ -495,7 +698,7 @@ func (ui *UI) AddLine(netID, buffer string, line Line) {
 	curNetID, curBuffer := ui.bs.Current()
 	_, b := ui.bs.at(netID, buffer)
 	focused := ui.bs.focused && curNetID == netID && curBuffer == buffer
-	if b != nil && line.Notify == NotifyHighlight && !focused {
+	if b != nil && (line.Notify == NotifyHighlight || line.Notify == NotifyMessage) && !focused {
 		var header string
 		if buffer != line.Head.String() {
 			header = fmt.Sprintf("%s — %s", buffer, line.Head.String())
@@ -505,6 +708,7 @@ func (ui *UI) AddLine(netID, buffer string, line Line) {
 		id := ui.notify(NotifyEvent{
 			NetID:  netID,
 			Buffer: buffer,
+			At:     line.At,
 		}, header, line.Body.String())
 		if id >= 0 {
 			b.notifications = append(b.notifications, id)
@@ -516,6 +720,35 @@ func (ui *UI) AddLines(netID, buffer string, before, after []Line) {
 	ui.bs.AddLines(netID, buffer, before, after)
 }
 
+// Detach replaces the displayed lines of a buffer with a window of history
+// that is not contiguous with its live lines. Lines added to the buffer
+// while it is detached are kept aside until it is attached again.
+func (ui *UI) Detach(netID, buffer string, lines []Line) {
+	ui.bs.Detach(netID, buffer, lines)
+}
+
+func (ui *UI) AddDetachedLines(netID, buffer string, before, after []Line) {
+	ui.bs.AddDetachedLines(netID, buffer, before, after)
+}
+
+// Attach drops the detached window of a buffer and displays its live lines
+// again. It returns false if the buffer was not detached.
+func (ui *UI) Attach(netID, buffer string) bool {
+	return ui.bs.Attach(netID, buffer)
+}
+
+func (ui *UI) IsDetached(netID, buffer string) bool {
+	return ui.bs.IsDetached(netID, buffer)
+}
+
+func (ui *UI) ScrollToTime(netID, buffer string, t time.Time) {
+	ui.bs.ScrollToTime(netID, buffer, t)
+}
+
+func (ui *UI) IsAtBottom() bool {
+	return ui.bs.cur().scrollAmt == 0
+}
+
 func (ui *UI) JumpBuffer(sub string) bool {
 	subLower := strings.ToLower(sub)
 	for i, b := range ui.bs.list {
@@ -528,6 +761,7 @@ func (ui *UI) JumpBuffer(sub string) bool {
 		if strings.Contains(strings.ToLower(title), subLower) {
 			if ui.bs.To(i) {
 				ui.memberOffset = 0
+				ui.switchDraft()
 			}
 			return true
 		}
@@ -540,6 +774,7 @@ func (ui *UI) JumpBufferIndex(i int) bool {
 	if i >= 0 && i < len(ui.bs.list) {
 		if ui.bs.To(i) {
 			ui.memberOffset = 0
+			ui.switchDraft()
 		}
 		return true
 	}
@@ -551,6 +786,7 @@ func (ui *UI) JumpBufferNetwork(netID, buffer string) bool {
 		if b.netID == netID && strings.ToLower(b.title) == strings.ToLower(buffer) {
 			if ui.bs.To(i) {
 				ui.memberOffset = 0
+				ui.switchDraft()
 			}
 			return true
 		}
@@ -590,6 +826,14 @@ func (ui *UI) SetRead(netID, buffer string, timestamp time.Time) {
 	ui.bs.SetRead(netID, buffer, timestamp)
 }
 
+func (ui *UI) ReadTime(netID, buffer string) time.Time {
+	_, b := ui.bs.at(netID, buffer)
+	if b == nil {
+		return time.Time{}
+	}
+	return b.read
+}
+
 func (ui *UI) UpdateRead() (netID, buffer string, timestamp time.Time) {
 	return ui.bs.UpdateRead()
 }
@@ -607,7 +851,9 @@ func (ui *UI) SetTitle(title string) {
 		return
 	}
 	ui.title = title
-	ui.vx.SetTitle(title)
+	if !ui.suspended {
+		ui.vx.SetTitle(title)
+	}
 }
 
 func (ui *UI) SetMouseShape(shape vaxis.MouseShape) {
@@ -692,6 +938,36 @@ func (ui *UI) InputDeleteNextWord() (ok bool) {
 	return ui.e.RemWordForward()
 }
 
+func (ui *UI) InputYank() (ok bool) {
+	return ui.e.Yank()
+}
+
+func (ui *UI) InputYankPop() (ok bool) {
+	return ui.e.YankPop()
+}
+
+func (ui *UI) InputTransposeChars() (ok bool) {
+	return ui.e.TransposeChars()
+}
+
+func (ui *UI) InputTransposeWords() (ok bool) {
+	return ui.e.TransposeWords()
+}
+
+func (ui *UI) InputUndo() (ok bool) {
+	return ui.e.Undo()
+}
+
+func (ui *UI) InputRedo() (ok bool) {
+	return ui.e.Redo()
+}
+
+// InputViKey handles a key in the vi editing mode. It returns false if the
+// key should be handled as usual.
+func (ui *UI) InputViKey(k vaxis.Key) (handled, changed bool) {
+	return ui.e.ViKey(k)
+}
+
 func (ui *UI) InputAutoComplete() (ok bool) {
 	return ui.e.AutoComplete()
 }
@@ -700,6 +976,11 @@ func (ui *UI) InputFlush() (content string) {
 	return ui.e.Flush()
 }
 
+// InputDropLastHistory removes the last entry of the input history.
+func (ui *UI) InputDropLastHistory() {
+	ui.e.DropLastHistory()
+}
+
 func (ui *UI) InputClear() bool {
 	return ui.e.Clear()
 }
@@ -708,8 +989,21 @@ func (ui *UI) InputSet(text string) {
 	ui.e.Set(text)
 }
 
-func (ui *UI) InputBackSearch() {
-	ui.e.BackSearch()
+// InputBackSearch searches the input history, only in the current buffer if
+// buffer is true.
+func (ui *UI) InputBackSearch(buffer bool) {
+	ui.e.BackSearch(buffer)
+}
+
+// InputHistory returns the messages and commands sent, oldest first.
+func (ui *UI) InputHistory() []HistoryEntry {
+	return ui.e.History()
+}
+
+// AddInputHistory adds entries, oldest first, before the messages and
+// commands sent so far.
+func (ui *UI) AddInputHistory(entries []HistoryEntry) {
+	ui.e.AddHistory(entries)
 }
 
 func (ui *UI) SetWinPixels(xPixel int, yPixel int) {
@@ -746,11 +1040,15 @@ func (ui *UI) Size() (int, int) {
 }
 
 func (ui *UI) Beep() {
-	ui.vx.Bell()
+	if !ui.suspended {
+		ui.vx.Bell()
+	}
 }
 
 func (ui *UI) Notify(title string, body string) {
-	ui.vx.Notify(title, body)
+	if !ui.suspended {
+		ui.vx.Notify(title, body)
+	}
 }
 
 func (ui *UI) Highlights() int {
@@ -791,6 +1089,9 @@ func (ui *UI) AsyncCompletions(id int, cs []Completion) {
 }
 
 func (ui *UI) Draw(members []irc.Member) {
+	if ui.suspended {
+		return
+	}
 	ui.clickEvents = ui.clickEvents[:0]
 
 	w, h := ui.vx.window.Size()
@@ -827,10 +1128,21 @@ func (ui *UI) Draw(members []irc.Member) {
 		}
 		printIdent(ui.vx, ui.channelWidth+7, h-1, ui.config.NickColWidth, prompt)
 	}
+	if mode := ui.e.Mode(); mode != "" {
+		x, y := ui.channelWidth, h-1
+		if ui.channelWidth == 0 {
+			y = h - 2
+		}
+		printString(ui.vx, &x, y, Styled(mode, vaxis.Style{
+			Foreground: ui.config.Colors.Status,
+		}))
+	}
 
 	var hint string
 	if ui.bs.HasOverlay() {
 		hint = ui.overlayHint
+	} else if ui.bs.cur().detached {
+		hint = "Viewing history, press Escape to return to present"
 	}
 	if ui.channelWidth == 0 {
 		ui.e.Draw(ui.vx, 9+ui.config.NickColWidth, h-2, hint)
@@ -847,15 +1159,24 @@ func (ui *UI) Draw(members []irc.Member) {
 }
 
 func (ui *UI) ScrollToBuffer() {
-	if ui.bs.current < ui.channelOffset {
-		ui.channelOffset = ui.bs.current
+	current := ui.bs.current
+	if ui.channelWidth > 0 {
+		if row := ui.bs.Row(current); row >= 0 {
+			current = row
+		}
+	}
+	if current < ui.channelOffset {
+		ui.channelOffset = current
+		return
+	}
+	if ui.vx == nil { // For tests only
 		return
 	}
 
 	w, h := ui.vx.window.Size()
 	var first int
 	if ui.channelWidth > 0 {
-		first = ui.bs.current - h + 1
+		first = current - h + 1
 	} else {
 		first = ui.bs.GetLeftMost(w - ui.memberWidth)
 	}
@@ -1010,10 +1331,8 @@ func (ui *UI) drawVerticalMemberList(vx *Vaxis, x0, y0, width, height int, b *bu
 		} else if m.PowerLevel != "" {
 			x += padding - 1
 			powerLevelText := m.PowerLevel[:1]
-			powerLevelSt := vaxis.Style{
-				Foreground: ColorGreen,
-				Attribute:  attr,
-			}
+			powerLevelSt := ui.memberPrefixStyle()
+			powerLevelSt.Attribute |= attr
 			printString(vx, &x, y, Styled(powerLevelText, powerLevelSt))
 		} else {
 			x += padding
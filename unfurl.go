@@ -0,0 +1,255 @@
+package senpai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"git.sr.ht/~rockorager/vaxis"
+
+	"git.sr.ht/~delthas/senpai/irc"
+	"git.sr.ht/~delthas/senpai/ui"
+)
+
+const (
+	unfurlMaxSize = 32 * 1024       // bytes of a page read to unfurl it
+	unfurlTimeout = 3 * time.Second // time allowed to unfurl a link
+)
+
+var (
+	patternMetaTag     = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	patternMetaName    = regexp.MustCompile(`(?is)\b(?:property|name)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	patternMetaContent = regexp.MustCompile(`(?is)\bcontent\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	patternTitle       = regexp.MustCompile(`(?is)<title[^>]*>([^<]*)</title>`)
+)
+
+// unfurl is the summary of a web page, from its OpenGraph metadata.
+type unfurl struct {
+	SiteName    string
+	Title       string
+	Description string
+}
+
+func attrValue(re *regexp.Regexp, tag string) (string, bool) {
+	m := re.FindStringSubmatch(tag)
+	if m == nil {
+		return "", false
+	}
+	return m[1] + m[2], true
+}
+
+// cleanText unescapes HTML text and collapses its whitespace.
+func cleanText(s string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+}
+
+// parseUnfurl extracts the summary of a web page from the start of its HTML.
+func parseUnfurl(b []byte) (unfurl, bool) {
+	var u unfurl
+	for _, tag := range patternMetaTag.FindAllString(string(b), -1) {
+		name, ok := attrValue(patternMetaName, tag)
+		if !ok {
+			continue
+		}
+		content, ok := attrValue(patternMetaContent, tag)
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(name) {
+		case "og:site_name":
+			u.SiteName = cleanText(content)
+		case "og:title":
+			u.Title = cleanText(content)
+		case "og:description":
+			u.Description = cleanText(content)
+		case "description":
+			if u.Description == "" {
+				u.Description = cleanText(content)
+			}
+		}
+	}
+	if u.Title == "" {
+		if m := patternTitle.FindSubmatch(b); m != nil {
+			u.Title = cleanText(string(m[1]))
+		}
+	}
+	return u, u.Title != "" || u.Description != ""
+}
+
+// unfurlCacheKey returns the key of the summary of link in the preview cache,
+// apart from the image preview of the same link.
+func unfurlCacheKey(link string) string {
+	return "unfurl:" + link
+}
+
+// Unfurl returns the summary of the web page at link, fetching it unless it
+// is in the preview cache.
+func (f *imageFetcher) Unfurl(ctx context.Context, link string) (unfurl, error) {
+	if f.cache != nil {
+		if b, ok := f.cache.Get(unfurlCacheKey(link)); ok {
+			var u unfurl
+			if err := json.Unmarshal(b, &u); err == nil {
+				return u, nil
+			}
+		}
+	}
+	u, err := f.unfurl(ctx, link)
+	if err != nil {
+		return unfurl{}, err
+	}
+	if f.cache != nil {
+		if b, err := json.Marshal(u); err == nil {
+			// The cache is best-effort: ignore errors.
+			f.cache.Put(unfurlCacheKey(link), b)
+		}
+	}
+	return u, nil
+}
+
+// unfurl fetches the summary of the web page at link, reading at most
+// unfurlMaxSize bytes of it.
+func (f *imageFetcher) unfurl(ctx context.Context, link string) (unfurl, error) {
+	req, c, err := f.request(ctx, "GET", link, unfurlTimeout)
+	if err != nil {
+		return unfurl{}, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%v", unfurlMaxSize-1))
+	res, err := c.Do(req)
+	if err != nil {
+		return unfurl{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusPartialContent {
+		return unfurl{}, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+	contentType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil || contentType != "text/html" {
+		return unfurl{}, fmt.Errorf("unexpected content type: %v", res.Header.Get("Content-Type"))
+	}
+	b, err := io.ReadAll(io.LimitReader(res.Body, unfurlMaxSize))
+	if err != nil && len(b) == 0 {
+		return unfurl{}, fmt.Errorf("unexpected read error: %v", err)
+	}
+	u, ok := parseUnfurl(b)
+	if !ok {
+		return unfurl{}, errors.New("no summary found")
+	}
+	return u, nil
+}
+
+// unfurlLoaded is posted when the summary of a link of a message is fetched.
+type unfurlLoaded struct {
+	netID  string
+	buffer string
+	id     string // msgid of the message, if any
+	at     time.Time
+	body   string
+	card   []ui.StyledString
+}
+
+// newUnfurlStore returns the store of whether links are unfurled in buffers,
+// as "on" or "off", when set with /unfurl.
+func newUnfurlStore(root string) *bufferSettings {
+	return newBufferSettings(root, func(value string) bool {
+		return value == "on" || value == "off"
+	})
+}
+
+// unfurlEnabled reports whether links are unfurled in a buffer.
+func (app *App) unfurlEnabled(netID, buffer string) bool {
+	s := app.sessions[netID]
+	if s == nil || buffer == "" {
+		return false
+	}
+	switch app.unfurls.Get(app.networkKey(netID), s.Casemap(buffer)) {
+	case "on":
+		return true
+	case "off":
+		return false
+	}
+	return app.cfg.Previews.Unfurl
+}
+
+// maybeUnfurl fetches the summary of the first link of a message, if links
+// are unfurled in its buffer, to show it below the message.
+func (app *App) maybeUnfurl(netID, buffer string, ev irc.MessageEvent, line ui.Line) {
+	if !app.unfurlEnabled(netID, buffer) || !app.previewAllowed(netID, buffer, ev.User) {
+		return
+	}
+	links := line.Body.URLs()
+	if len(links) == 0 {
+		return
+	}
+	link := links[0]
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), unfurlTimeout)
+		defer cancel()
+		u, err := app.images.Unfurl(ctx, link)
+		if err != nil {
+			return
+		}
+		app.postEvent(event{
+			src: "*",
+			content: &unfurlLoaded{
+				netID:  netID,
+				buffer: buffer,
+				id:     line.ID,
+				at:     line.At,
+				body:   line.Body.String(),
+				card:   unfurlCard(u),
+			},
+		})
+	}()
+}
+
+// unfurlCard returns the rows shown below a message for the summary of its
+// link. Their colors are those of the card style of the theme, set when
+// drawn.
+func unfurlCard(u unfurl) []ui.StyledString {
+	var card []ui.StyledString
+	var sb ui.StyledStringBuilder
+	if u.SiteName != "" {
+		sb.SetStyle(vaxis.Style{
+			Attribute: vaxis.AttrItalic,
+		})
+		sb.WriteString(u.SiteName)
+		if u.Title != "" {
+			sb.SetStyle(vaxis.Style{})
+			sb.WriteString(" — ")
+		}
+	}
+	if u.Title != "" {
+		sb.SetStyle(vaxis.Style{
+			Attribute: vaxis.AttrBold,
+		})
+		sb.WriteString(u.Title)
+	}
+	if sb.Len() > 0 {
+		card = append(card, sb.StyledString())
+	}
+	if u.Description != "" {
+		card = append(card, ui.PlainString(u.Description))
+	}
+	return card
+}
+
+func (app *App) handleUnfurlLoaded(ev *unfurlLoaded) {
+	app.win.SetCard(ev.netID, ev.buffer, func(line *ui.Line) bool {
+		if line.Redacted {
+			// The message was deleted while its link was fetched.
+			return false
+		}
+		if ev.id != "" {
+			return line.ID == ev.id
+		}
+		return line.At.Equal(ev.at) && line.Body.String() == ev.body
+	}, ev.card)
+}
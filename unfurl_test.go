@@ -0,0 +1,100 @@
+package senpai
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"git.sr.ht/~delthas/senpai/irc"
+)
+
+func TestParseUnfurl(t *testing.T) {
+	for _, tc := range []struct {
+		html     string
+		expected unfurl
+		ok       bool
+	}{{
+		html: `<meta property="og:site_name" content="Example"><meta property='og:title' content='A &amp; B'>
+			<meta content="Some
+			description" property="og:description" />`,
+		expected: unfurl{SiteName: "Example", Title: "A & B", Description: "Some description"},
+		ok:       true,
+	}, {
+		html:     `<title> Page title </title><meta name="description" content="Plain description">`,
+		expected: unfurl{Title: "Page title", Description: "Plain description"},
+		ok:       true,
+	}, {
+		html: `<html><body>nothing here</body></html>`,
+		ok:   false,
+	}} {
+		u, ok := parseUnfurl([]byte(tc.html))
+		if ok != tc.ok || u != tc.expected {
+			t.Errorf("%q: got %+v, %v, expected %+v, %v", tc.html, u, ok, tc.expected, tc.ok)
+		}
+	}
+}
+
+func TestUnfurl(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/page":
+			fmt.Fprint(w, `<meta property="og:title" content="Title">`)
+		case "/long":
+			// The metadata is past the bytes read to unfurl the page.
+			fmt.Fprint(w, strings.Repeat(" ", unfurlMaxSize))
+			fmt.Fprint(w, `<meta property="og:title" content="Title">`)
+		}
+	}))
+	defer srv.Close()
+
+	f := newImageFetcher(Defaults().Previews, t.TempDir())
+	if u, err := f.Unfurl(context.Background(), srv.URL+"/page"); err != nil || u.Title != "Title" {
+		t.Errorf("page: got %+v, %v", u, err)
+	}
+	if _, err := f.Unfurl(context.Background(), srv.URL+"/long"); err == nil {
+		t.Errorf("long page: expected an error")
+	}
+
+	n := hits.Load()
+	if u, err := f.Unfurl(context.Background(), srv.URL+"/page"); err != nil || u.Title != "Title" {
+		t.Errorf("cached page: got %+v, %v", u, err)
+	}
+	if hits.Load() != n {
+		t.Errorf("cached page: expected no requests, got %d", hits.Load()-n)
+	}
+}
+
+// TestUnfurlSetting checks that /unfurl is saved per buffer.
+func TestUnfurlSetting(t *testing.T) {
+	dir := t.TempDir()
+	app := &App{
+		cfg: Config{
+			ServerConfig: ServerConfig{Addr: "irc.example.org:6697"},
+		},
+		sessions: map[string]*irc.Session{
+			"": irc.NewSession(make(chan irc.Message, 64), irc.SessionParams{
+				Nickname: "me",
+			}),
+		},
+		unfurls: newUnfurlStore(dir),
+	}
+	app.cfg.Previews.Unfurl = true
+	if err := app.unfurls.Set(app.networkKey(""), "#off", "off"); err != nil {
+		t.Fatal(err)
+	}
+
+	app.unfurls = newUnfurlStore(dir)
+	if app.unfurlEnabled("", "#OFF") {
+		t.Errorf("#OFF: expected links not to be unfurled after loading")
+	}
+	if !app.unfurlEnabled("", "#other") {
+		t.Errorf("#other: expected links to be unfurled by default")
+	}
+}
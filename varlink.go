@@ -0,0 +1,286 @@
+package senpai
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"git.sr.ht/~delthas/senpai/events"
+	"git.sr.ht/~delthas/senpai/ui"
+	"git.sr.ht/~delthas/senpai/varlinkservice"
+)
+
+// varlinkTimeout is how long varlink calls wait for the event loop.
+var varlinkTimeout = 10 * time.Second
+
+// varlinkSubscriberBuffer is the number of events queued for each subscriber;
+// events are dropped for subscribers which do not keep up.
+const varlinkSubscriberBuffer = 64
+
+var errClosing = errors.New("senpai is closing")
+
+// varlinkCall is a function run by the event loop on behalf of a varlink
+// call, which must not access the state of the App concurrently.
+type varlinkCall struct {
+	f    func()
+	done chan struct{}
+
+	// claimed is set either by the event loop before running f, or by the
+	// caller giving up on the call, so that f is not run after a timeout.
+	claimed atomic.Bool
+}
+
+// run runs f, unless the call was cancelled.
+func (call *varlinkCall) run() {
+	if !call.claimed.CompareAndSwap(false, true) {
+		return
+	}
+	call.f()
+	close(call.done)
+}
+
+// cancel prevents f from being run. It returns false if f is already running
+// or done.
+func (call *varlinkCall) cancel() bool {
+	return call.claimed.CompareAndSwap(false, true)
+}
+
+type varlinkSubscribers struct {
+	lock   sync.Mutex
+	chans  map[chan *varlinkservice.SubscribeOut]struct{}
+	closed bool
+}
+
+// runSync runs f in the event loop, and waits for it to return.
+func (app *App) runSync(f func()) error {
+	if app.closing.Load() {
+		return errClosing
+	}
+	call := &varlinkCall{
+		f:    f,
+		done: make(chan struct{}),
+	}
+	go app.postEvent(event{
+		src:     "*",
+		content: call,
+	})
+	select {
+	case <-call.done:
+		return nil
+	case <-time.After(varlinkTimeout):
+		if !call.cancel() {
+			// Too late: f is running.
+			<-call.done
+			return nil
+		}
+		return errors.New("timed out waiting for senpai")
+	}
+}
+
+func (app *App) OpenLink(in *varlinkservice.OpenLinkIn) (*varlinkservice.OpenLinkOut, error) {
+	app.postEvent(event{
+		src: "*",
+		content: &events.EventOpenLink{
+			Link: in.Link,
+		},
+	})
+	return nil, nil
+}
+
+func (app *App) SendMessage(in *varlinkservice.SendMessageIn) (*varlinkservice.SendMessageOut, error) {
+	var err error
+	if err := app.runSync(func() {
+		err = app.sendMessage(in.NetworkId, in.Target, in.Content)
+	}); err != nil {
+		return nil, err
+	}
+	if err == errOffline {
+		return nil, &varlinkservice.OfflineError{
+			NetworkId: in.NetworkId,
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	return &varlinkservice.SendMessageOut{}, nil
+}
+
+func (app *App) ListNetworks(in *varlinkservice.ListNetworksIn) (*varlinkservice.ListNetworksOut, error) {
+	var networks []varlinkservice.Network
+	if err := app.runSync(func() {
+		app.networkLock.RLock()
+		ids := make([]string, 0, len(app.networks))
+		for id := range app.networks {
+			ids = append(ids, id)
+		}
+		app.networkLock.RUnlock()
+		for _, id := range ids {
+			networks = append(networks, varlinkservice.Network{
+				Id:        id,
+				Name:      app.networkName(id),
+				Connected: app.sessions[id] != nil,
+			})
+		}
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(networks, func(i, j int) bool {
+		return networks[i].Id < networks[j].Id
+	})
+	return &varlinkservice.ListNetworksOut{
+		Networks: networks,
+	}, nil
+}
+
+func (app *App) ListBuffers(in *varlinkservice.ListBuffersIn) (*varlinkservice.ListBuffersOut, error) {
+	var infos []ui.BufferInfo
+	if err := app.runSync(func() {
+		infos = app.win.Buffers()
+	}); err != nil {
+		return nil, err
+	}
+	buffers := make([]varlinkservice.Buffer, 0, len(infos))
+	for _, b := range infos {
+		buffers = append(buffers, varlinkservice.Buffer{
+			NetworkId:  b.NetID,
+			Name:       b.Title,
+			Unread:     b.Unread,
+			Highlights: b.Highlights,
+			Pinned:     b.Pinned,
+			Muted:      b.Muted,
+		})
+	}
+	return &varlinkservice.ListBuffersOut{
+		Buffers: buffers,
+	}, nil
+}
+
+func (app *App) SwitchBuffer(in *varlinkservice.SwitchBufferIn) (*varlinkservice.SwitchBufferOut, error) {
+	var ok bool
+	if err := app.runSync(func() {
+		ok = app.win.JumpBufferNetwork(in.NetworkId, in.Name)
+		if ok {
+			app.win.ScrollToBuffer()
+		}
+	}); err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, &varlinkservice.NoSuchBufferError{
+			NetworkId: in.NetworkId,
+			Name:      in.Name,
+		}
+	}
+	return &varlinkservice.SwitchBufferOut{}, nil
+}
+
+func (app *App) ReadLines(in *varlinkservice.ReadLinesIn) (*varlinkservice.ReadLinesOut, error) {
+	limit := 50
+	if in.Limit != nil && *in.Limit >= 0 {
+		limit = *in.Limit
+	}
+	var lines []ui.Line
+	var ok bool
+	if err := app.runSync(func() {
+		lines, ok = app.win.Lines(in.NetworkId, in.Name, limit)
+	}); err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, &varlinkservice.NoSuchBufferError{
+			NetworkId: in.NetworkId,
+			Name:      in.Name,
+		}
+	}
+	out := make([]varlinkservice.Line, 0, len(lines))
+	for _, line := range lines {
+		l := varlinkservice.Line{
+			Time:      line.At.Format(time.RFC3339Nano),
+			Head:      line.Head.String(),
+			Body:      line.Body.String(),
+			Highlight: line.Highlight,
+		}
+		if line.ID != "" {
+			id := line.ID
+			l.Id = &id
+		}
+		out = append(out, l)
+	}
+	return &varlinkservice.ReadLinesOut{
+		Lines: out,
+	}, nil
+}
+
+// Subscribe waits for the next event. Clients calling it with the "more" flag
+// receive all events instead, through SubscribeStream.
+func (app *App) Subscribe(in *varlinkservice.SubscribeIn) (*varlinkservice.SubscribeOut, error) {
+	events, cancel := app.SubscribeStream(in)
+	defer cancel()
+	ev, ok := <-events
+	if !ok {
+		return nil, errClosing
+	}
+	return ev, nil
+}
+
+func (app *App) SubscribeStream(in *varlinkservice.SubscribeIn) (<-chan *varlinkservice.SubscribeOut, func()) {
+	subs := &app.subscribers
+	ch := make(chan *varlinkservice.SubscribeOut, varlinkSubscriberBuffer)
+	subs.lock.Lock()
+	defer subs.lock.Unlock()
+	if subs.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if subs.chans == nil {
+		subs.chans = make(map[chan *varlinkservice.SubscribeOut]struct{})
+	}
+	subs.chans[ch] = struct{}{}
+	return ch, func() {
+		subs.lock.Lock()
+		defer subs.lock.Unlock()
+		if _, ok := subs.chans[ch]; ok {
+			delete(subs.chans, ch)
+			close(ch)
+		}
+	}
+}
+
+// publish sends an event to all varlink subscribers.
+func (app *App) publish(typ, netID, buffer, nick string, body *string, t time.Time) {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	ev := &varlinkservice.SubscribeOut{
+		Event: &varlinkservice.Event{
+			Type:      typ,
+			NetworkId: netID,
+			Buffer:    buffer,
+			Nick:      nick,
+			Body:      body,
+			Time:      t.Format(time.RFC3339Nano),
+		},
+	}
+	subs := &app.subscribers
+	subs.lock.Lock()
+	defer subs.lock.Unlock()
+	for ch := range subs.chans {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// closeSubscribers ends all varlink subscriptions.
+func (app *App) closeSubscribers() {
+	subs := &app.subscribers
+	subs.lock.Lock()
+	defer subs.lock.Unlock()
+	subs.closed = true
+	for ch := range subs.chans {
+		close(ch)
+	}
+	subs.chans = nil
+}
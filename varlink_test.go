@@ -0,0 +1,102 @@
+package senpai
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"git.sr.ht/~delthas/senpai/irc"
+	"git.sr.ht/~delthas/senpai/ui"
+	"git.sr.ht/~delthas/senpai/varlinkservice"
+)
+
+// newVarlinkTestApp returns an App without connections, and a function
+// running the varlink calls it receives, as its event loop would.
+func newVarlinkTestApp() (*App, func()) {
+	app := &App{
+		win:      ui.NewHeadless(ui.Config{}),
+		sessions: map[string]*irc.Session{},
+		events:   make(chan event, eventChanSize),
+	}
+	loop := func() {
+		for ev := range app.events {
+			if call, ok := ev.content.(*varlinkCall); ok {
+				call.run()
+			}
+		}
+	}
+	return app, loop
+}
+
+func TestVarlinkSendMessage(t *testing.T) {
+	app, loop := newVarlinkTestApp()
+	go loop()
+	defer close(app.events)
+
+	_, err := app.SendMessage(&varlinkservice.SendMessageIn{
+		NetworkId: "net",
+		Target:    "#chan",
+		Content:   "hello",
+	})
+	var offline *varlinkservice.OfflineError
+	if !errors.As(err, &offline) || offline.NetworkId != "net" {
+		t.Errorf("got error %v, expected an offline error", err)
+	}
+}
+
+func TestVarlinkReadLines(t *testing.T) {
+	app, loop := newVarlinkTestApp()
+	go loop()
+	defer close(app.events)
+
+	app.win.AddBuffer("net", "", "#chan")
+	for _, body := range []string{"first", "second", "third"} {
+		app.win.AddLine("net", "#chan", ui.Line{
+			At:   time.Now(),
+			Head: ui.PlainString("alice"),
+			Body: ui.PlainString(body),
+		})
+	}
+	limit := 2
+	out, err := app.ReadLines(&varlinkservice.ReadLinesIn{
+		NetworkId: "net",
+		Name:      "#chan",
+		Limit:     &limit,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Lines) != 2 || out.Lines[0].Body != "second" || out.Lines[1].Body != "third" {
+		t.Errorf("got lines %+v", out.Lines)
+	}
+
+	_, err = app.ReadLines(&varlinkservice.ReadLinesIn{
+		NetworkId: "net",
+		Name:      "#unknown",
+	})
+	var noSuchBuffer *varlinkservice.NoSuchBufferError
+	if !errors.As(err, &noSuchBuffer) {
+		t.Errorf("got error %v, expected a no such buffer error", err)
+	}
+}
+
+func TestVarlinkTimeout(t *testing.T) {
+	defer func(timeout time.Duration) {
+		varlinkTimeout = timeout
+	}(varlinkTimeout)
+	varlinkTimeout = 10 * time.Millisecond
+
+	app, _ := newVarlinkTestApp()
+	ran := false
+	if err := app.runSync(func() {
+		ran = true
+	}); err == nil {
+		t.Fatalf("expected a timeout")
+	}
+	// The event loop catches up after the caller gave up.
+	ev := <-app.events
+	ev.content.(*varlinkCall).run()
+	if ran {
+		t.Errorf("expected the call not to run after its timeout")
+	}
+}
@@ -4,15 +4,97 @@ package varlinkservice
 
 import (
 	"encoding/json"
-
 	govarlink "github.com/emersion/go-varlink"
 )
 
+type Buffer struct {
+	Highlights int    `json:"highlights"`
+	Muted      bool   `json:"muted"`
+	Name       string `json:"name"`
+	NetworkId  string `json:"network_id"`
+	Pinned     bool   `json:"pinned"`
+	Unread     bool   `json:"unread"`
+}
+type Event struct {
+	Body      *string `json:"body,omitempty"`
+	Buffer    string  `json:"buffer"`
+	NetworkId string  `json:"network_id"`
+	Nick      string  `json:"nick"`
+	Time      string  `json:"time"`
+	Type      string  `json:"type"`
+}
+type Line struct {
+	Body      string  `json:"body"`
+	Head      string  `json:"head"`
+	Highlight bool    `json:"highlight"`
+	Id        *string `json:"id,omitempty"`
+	Time      string  `json:"time"`
+}
+type Network struct {
+	Connected bool   `json:"connected"`
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+}
+
+type NoSuchBufferError struct {
+	Name      string `json:"name"`
+	NetworkId string `json:"network_id"`
+}
+
+func (err *NoSuchBufferError) Error() string {
+	return "varlink call failed: fr.delthas.senpai.NoSuchBuffer"
+}
+
+type OfflineError struct {
+	NetworkId string `json:"network_id"`
+}
+
+func (err *OfflineError) Error() string {
+	return "varlink call failed: fr.delthas.senpai.Offline"
+}
+
+type ListBuffersIn struct{}
+type ListBuffersOut struct {
+	Buffers []Buffer `json:"buffers"`
+}
+
+type ListNetworksIn struct{}
+type ListNetworksOut struct {
+	Networks []Network `json:"networks"`
+}
+
 type OpenLinkIn struct {
 	Link string `json:"link"`
 }
 type OpenLinkOut struct{}
 
+type ReadLinesIn struct {
+	Limit     *int   `json:"limit,omitempty"`
+	Name      string `json:"name"`
+	NetworkId string `json:"network_id"`
+}
+type ReadLinesOut struct {
+	Lines []Line `json:"lines"`
+}
+
+type SendMessageIn struct {
+	Content   string `json:"content"`
+	NetworkId string `json:"network_id"`
+	Target    string `json:"target"`
+}
+type SendMessageOut struct{}
+
+type SubscribeIn struct{}
+type SubscribeOut struct {
+	Event *Event `json:"event,omitempty"`
+}
+
+type SwitchBufferIn struct {
+	Name      string `json:"name"`
+	NetworkId string `json:"network_id"`
+}
+type SwitchBufferOut struct{}
+
 type Client struct {
 	*govarlink.Client
 }
@@ -24,6 +106,10 @@ func unmarshalError(err error) error {
 	}
 	var v error
 	switch verr.Name {
+	case "fr.delthas.senpai.NoSuchBuffer":
+		v = new(NoSuchBufferError)
+	case "fr.delthas.senpai.Offline":
+		v = new(OfflineError)
 	default:
 		return err
 	}
@@ -32,6 +118,22 @@ func unmarshalError(err error) error {
 	}
 	return v
 }
+func (c Client) ListBuffers(in *ListBuffersIn) (*ListBuffersOut, error) {
+	if in == nil {
+		in = new(ListBuffersIn)
+	}
+	out := new(ListBuffersOut)
+	err := c.Client.Do("fr.delthas.senpai.ListBuffers", in, out)
+	return out, unmarshalError(err)
+}
+func (c Client) ListNetworks(in *ListNetworksIn) (*ListNetworksOut, error) {
+	if in == nil {
+		in = new(ListNetworksIn)
+	}
+	out := new(ListNetworksOut)
+	err := c.Client.Do("fr.delthas.senpai.ListNetworks", in, out)
+	return out, unmarshalError(err)
+}
 func (c Client) OpenLink(in *OpenLinkIn) (*OpenLinkOut, error) {
 	if in == nil {
 		in = new(OpenLinkIn)
@@ -40,9 +142,47 @@ func (c Client) OpenLink(in *OpenLinkIn) (*OpenLinkOut, error) {
 	err := c.Client.Do("fr.delthas.senpai.OpenLink", in, out)
 	return out, unmarshalError(err)
 }
+func (c Client) ReadLines(in *ReadLinesIn) (*ReadLinesOut, error) {
+	if in == nil {
+		in = new(ReadLinesIn)
+	}
+	out := new(ReadLinesOut)
+	err := c.Client.Do("fr.delthas.senpai.ReadLines", in, out)
+	return out, unmarshalError(err)
+}
+func (c Client) SendMessage(in *SendMessageIn) (*SendMessageOut, error) {
+	if in == nil {
+		in = new(SendMessageIn)
+	}
+	out := new(SendMessageOut)
+	err := c.Client.Do("fr.delthas.senpai.SendMessage", in, out)
+	return out, unmarshalError(err)
+}
+func (c Client) Subscribe(in *SubscribeIn) (*SubscribeOut, error) {
+	if in == nil {
+		in = new(SubscribeIn)
+	}
+	out := new(SubscribeOut)
+	err := c.Client.Do("fr.delthas.senpai.Subscribe", in, out)
+	return out, unmarshalError(err)
+}
+func (c Client) SwitchBuffer(in *SwitchBufferIn) (*SwitchBufferOut, error) {
+	if in == nil {
+		in = new(SwitchBufferIn)
+	}
+	out := new(SwitchBufferOut)
+	err := c.Client.Do("fr.delthas.senpai.SwitchBuffer", in, out)
+	return out, unmarshalError(err)
+}
 
 type Backend interface {
+	ListBuffers(*ListBuffersIn) (*ListBuffersOut, error)
+	ListNetworks(*ListNetworksIn) (*ListNetworksOut, error)
 	OpenLink(*OpenLinkIn) (*OpenLinkOut, error)
+	ReadLines(*ReadLinesIn) (*ReadLinesOut, error)
+	SendMessage(*SendMessageIn) (*SendMessageOut, error)
+	Subscribe(*SubscribeIn) (*SubscribeOut, error)
+	SwitchBuffer(*SwitchBufferIn) (*SwitchBufferOut, error)
 }
 
 type Handler struct {
@@ -52,6 +192,10 @@ type Handler struct {
 func marshalError(err error) error {
 	var name string
 	switch err.(type) {
+	case *NoSuchBufferError:
+		name = "fr.delthas.senpai.NoSuchBuffer"
+	case *OfflineError:
+		name = "fr.delthas.senpai.Offline"
 	default:
 		return err
 	}
@@ -66,12 +210,48 @@ func (h Handler) HandleVarlink(call *govarlink.ServerCall, req *govarlink.Server
 		err error
 	)
 	switch req.Method {
+	case "fr.delthas.senpai.ListBuffers":
+		in := new(ListBuffersIn)
+		if err := json.Unmarshal(req.Parameters, in); err != nil {
+			return err
+		}
+		out, err = h.Backend.ListBuffers(in)
+	case "fr.delthas.senpai.ListNetworks":
+		in := new(ListNetworksIn)
+		if err := json.Unmarshal(req.Parameters, in); err != nil {
+			return err
+		}
+		out, err = h.Backend.ListNetworks(in)
 	case "fr.delthas.senpai.OpenLink":
 		in := new(OpenLinkIn)
 		if err := json.Unmarshal(req.Parameters, in); err != nil {
 			return err
 		}
 		out, err = h.Backend.OpenLink(in)
+	case "fr.delthas.senpai.ReadLines":
+		in := new(ReadLinesIn)
+		if err := json.Unmarshal(req.Parameters, in); err != nil {
+			return err
+		}
+		out, err = h.Backend.ReadLines(in)
+	case "fr.delthas.senpai.SendMessage":
+		in := new(SendMessageIn)
+		if err := json.Unmarshal(req.Parameters, in); err != nil {
+			return err
+		}
+		out, err = h.Backend.SendMessage(in)
+	case "fr.delthas.senpai.Subscribe":
+		in := new(SubscribeIn)
+		if err := json.Unmarshal(req.Parameters, in); err != nil {
+			return err
+		}
+		out, err = h.Backend.Subscribe(in)
+	case "fr.delthas.senpai.SwitchBuffer":
+		in := new(SwitchBufferIn)
+		if err := json.Unmarshal(req.Parameters, in); err != nil {
+			return err
+		}
+		out, err = h.Backend.SwitchBuffer(in)
 	default:
 		err = &govarlink.ServerError{
 			Name:       "org.varlink.service.MethodNotFound",
@@ -86,7 +266,7 @@ func (h Handler) HandleVarlink(call *govarlink.ServerCall, req *govarlink.Server
 
 func (h Handler) Register(reg *govarlink.Registry) {
 	reg.Add(&govarlink.RegistryInterface{
-		Definition: "interface fr.delthas.senpai\n\nmethod OpenLink(link: string) -> ()\n",
+		Definition: "interface fr.delthas.senpai\n\ntype Network (\n\tid: string,\n\tname: string,\n\tconnected: bool\n)\n\ntype Buffer (\n\tnetwork_id: string,\n\tname: string,\n\tunread: bool,\n\thighlights: int,\n\tpinned: bool,\n\tmuted: bool\n)\n\ntype Line (\n\ttime: string,\n\thead: string,\n\tbody: string,\n\tid: ?string,\n\thighlight: bool\n)\n\ntype Event (\n\ttype: (message, highlight, join),\n\tnetwork_id: string,\n\tbuffer: string,\n\tnick: string,\n\tbody: ?string,\n\ttime: string\n)\n\nmethod OpenLink(link: string) -> ()\n\nmethod SendMessage(network_id: string, target: string, content: string) -> ()\n\nmethod ListNetworks() -> (networks: []Network)\n\nmethod ListBuffers() -> (buffers: []Buffer)\n\nmethod SwitchBuffer(network_id: string, name: string) -> ()\n\nmethod ReadLines(network_id: string, name: string, limit: ?int) -> (lines: []Line)\n\nmethod Subscribe() -> (event: ?Event)\n\nerror NoSuchBuffer (network_id: string, name: string)\n\nerror Offline (network_id: string)\n",
 		Name:       "fr.delthas.senpai",
 	}, h)
 }
@@ -0,0 +1,128 @@
+package varlinkservice
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+
+	govarlink "github.com/emersion/go-varlink"
+)
+
+// Subscriber is implemented by backends which stream events to Subscribe
+// calls made with the "more" flag.
+type Subscriber interface {
+	// SubscribeStream returns a channel of events, closed when the backend
+	// stops, and a function to stop receiving events.
+	SubscribeStream(in *SubscribeIn) (events <-chan *SubscribeOut, cancel func())
+}
+
+// StreamHandler is a Handler which replies to Subscribe calls made with the
+// "more" flag with a stream of events, instead of a single event.
+type StreamHandler struct {
+	Handler
+	Subscriber Subscriber
+
+	// closed is closed when the client disconnects, to stop its streams.
+	// Set by Serve.
+	closed <-chan struct{}
+}
+
+func (h StreamHandler) HandleVarlink(call *govarlink.ServerCall, req *govarlink.ServerRequest) error {
+	if req.Method != "fr.delthas.senpai.Subscribe" || !req.More {
+		return h.Handler.HandleVarlink(call, req)
+	}
+	in := new(SubscribeIn)
+	if err := json.Unmarshal(req.Parameters, in); err != nil {
+		return err
+	}
+	events, cancel := h.Subscriber.SubscribeStream(in)
+	defer cancel()
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return call.CloseWithReply(&SubscribeOut{})
+			}
+			if err := call.Reply(ev); err != nil {
+				return err
+			}
+		case <-h.closed:
+			// The client is gone: end the call without reporting the
+			// failure to write its last reply.
+			call.CloseWithReply(&SubscribeOut{})
+			return nil
+		}
+	}
+}
+
+// Serve serves h on the connections accepted from ln. Unlike
+// govarlink.Server, it ends the streams of events of clients once they
+// disconnect, rather than when the next event fails to be sent.
+func Serve(ln net.Listener, h StreamHandler) error {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		wc := newWatchedConn(c)
+		h := h
+		h.closed = wc.closed
+		srv := &govarlink.Server{Handler: h}
+		go srv.Serve(&connListener{conn: wc})
+	}
+}
+
+// watchedConn reads ahead from its connection, to notice when the client
+// disconnects while no request is being read.
+type watchedConn struct {
+	net.Conn
+	r      *io.PipeReader
+	closed chan struct{}
+}
+
+func newWatchedConn(c net.Conn) *watchedConn {
+	pr, pw := io.Pipe()
+	wc := &watchedConn{
+		Conn:   c,
+		r:      pr,
+		closed: make(chan struct{}),
+	}
+	go func() {
+		_, err := io.Copy(pw, c)
+		close(wc.closed)
+		pw.CloseWithError(err)
+	}()
+	return wc
+}
+
+func (wc *watchedConn) Read(b []byte) (int, error) {
+	return wc.r.Read(b)
+}
+
+func (wc *watchedConn) Close() error {
+	wc.r.Close()
+	return wc.Conn.Close()
+}
+
+// connListener is a listener accepting a single connection, to serve each
+// connection with its own handler.
+type connListener struct {
+	conn     net.Conn
+	accepted bool
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	if l.accepted {
+		return nil, net.ErrClosed
+	}
+	l.accepted = true
+	return l.conn, nil
+}
+
+func (l *connListener) Close() error {
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
@@ -0,0 +1,54 @@
+package varlinkservice
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testSubscriber struct {
+	subscribed chan struct{}
+	cancelled  chan struct{}
+}
+
+func (s *testSubscriber) SubscribeStream(in *SubscribeIn) (<-chan *SubscribeOut, func()) {
+	close(s.subscribed)
+	return make(chan *SubscribeOut), func() {
+		close(s.cancelled)
+	}
+}
+
+// TestStreamDisconnect checks that a stream of events without any event ends
+// when its client disconnects.
+func TestStreamDisconnect(t *testing.T) {
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "test.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	sub := &testSubscriber{
+		subscribed: make(chan struct{}),
+		cancelled:  make(chan struct{}),
+	}
+	go Serve(ln, StreamHandler{Subscriber: sub})
+
+	c, err := net.Dial("unix", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write([]byte(`{"method":"fr.delthas.senpai.Subscribe","parameters":{},"more":true}` + "\x00")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-sub.subscribed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the subscription")
+	}
+	c.Close()
+	select {
+	case <-sub.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream did not end after the client disconnected")
+	}
+}
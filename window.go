@@ -57,6 +57,10 @@ func (app *App) setStatus() {
 		app.win.SetStatus(fmt.Sprintf("Uploading file (%02.1f%%)...", *app.uploadingProgress*100))
 		return
 	}
+	if app.gallery != nil {
+		app.win.SetStatus(app.galleryStatus())
+		return
+	}
 	if app.imageLoading {
 		app.win.SetStatus("Loading image...")
 		return